@@ -0,0 +1,65 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package control provides the urpc-facing option types runsc/sandbox
+// passes to the sentry's containerManager RPCs.
+package control
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sentry/pgalloc"
+	"gvisor.dev/gvisor/pkg/urpc"
+	"gvisor.dev/gvisor/runsc/boot"
+)
+
+// SaveOpts is the argument to containerManager.Checkpoint. It carries
+// everything the sentry's save path needs beyond the destination file
+// itself: how memory pages and the archive as a whole should be encoded,
+// whether the sandbox should keep running afterwards, and the optional
+// save/restore exec command to run once the state has been written.
+type SaveOpts struct {
+	urpc.FilePayload
+
+	// Metadata is recorded alongside the state file and checked against
+	// on restore (e.g. the statefile.CompressionLevel a restore must
+	// decode pages with).
+	Metadata map[string]string
+
+	// MemoryFileSaveOpts configures how pgalloc.MemoryFile serializes
+	// application memory.
+	MemoryFileSaveOpts pgalloc.SaveOpts
+
+	// HavePagesFile indicates that FilePayload's second and third files
+	// are the pages metadata and pages files, rather than just a single
+	// combined state file.
+	HavePagesFile bool
+
+	// Resume indicates that the sandbox should keep running after the
+	// checkpoint completes, rather than pausing indefinitely awaiting
+	// destruction.
+	Resume bool
+
+	// ArchiveCompression selects the codec the state file as a whole is
+	// wrapped in, independent of Metadata's per-page compression level.
+	ArchiveCompression boot.CheckpointCompression
+
+	// SaveRestoreExecArgv, SaveRestoreExecTimeout and
+	// SaveRestoreExecContainerID mirror sandbox.CheckpointOpts' fields of
+	// the same name: they configure an optional command run inside the
+	// sentry immediately after the checkpoint completes.
+	SaveRestoreExecArgv        string
+	SaveRestoreExecTimeout     time.Duration
+	SaveRestoreExecContainerID string
+}
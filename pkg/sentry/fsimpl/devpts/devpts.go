@@ -30,6 +30,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/waiter"
 )
 
 // Name is the filesystem name.
@@ -180,6 +181,7 @@ func (fstype *FilesystemType) newFilesystem(ctx context.Context, vfsObj *vfs.Vir
 
 	// Construct the root directory. This is always inode id 1.
 	root := &rootInode{
+		fs:       fs,
 		replicas: make(map[uint32]*replicaInode),
 	}
 	root.InodeAttrs.InitWithIDs(ctx, opts.uid, opts.gid, linux.UNNAMED_MAJOR, devMinor, 1, linux.ModeDirectory|opts.mode)
@@ -234,6 +236,9 @@ type rootInode struct {
 
 	locks vfs.FileLocks
 
+	// fs is the owning filesystem. Immutable.
+	fs *filesystem
+
 	// master is the master pty inode. Immutable.
 	master *masterInode
 
@@ -275,6 +280,10 @@ func (i *rootInode) allocateTerminal(ctx context.Context, creds *auth.Credential
 	replica := &replicaInode{
 		root: i,
 		t:    t,
+		// The pty starts locked, matching Linux: a newly-opened ptmx isn't
+		// usable from the replica side until the opening process calls
+		// unlockpt(3) (ioctl(TIOCSPTLCK, 0)).
+		locked: true,
 	}
 	// Linux always uses pty index + 3 as the inode id. See
 	// fs/devpts/inode.c:devpts_pty_new().
@@ -300,6 +309,65 @@ func (i *rootInode) masterClose(ctx context.Context, t *Terminal) {
 	delete(i.replicas, t.n)
 }
 
+// hangupTerminal performs a virtual hangup (TIOCVHANGUP) of t: it signals
+// SIGHUP and SIGCONT to the foreground process group of the replica end,
+// marks the line discipline hung up so that future reads return 0 and
+// writes return EIO on both ends, and wakes every waiter with EventHUp.
+// This is what login/session managers (agetty, systemd-logind) use to
+// forcibly revoke a tty at session teardown, matching Linux's
+// tty_vhangup().
+func hangupTerminal(ctx context.Context, t *Terminal, caller *kernel.Task) error {
+	if !auth.CredentialsFromContext(ctx).HasCapability(linux.CAP_SYS_ADMIN) {
+		return linuxerr.EPERM
+	}
+
+	if pgid, err := caller.ThreadGroup().ForegroundProcessGroupID(t.replicaKTTY); err == nil {
+		if pg, err := caller.Kernel().ProcessGroupWithID(pgid); err == nil {
+			pg.SendSignal(kernel.SignalInfoPriv(linux.SIGHUP))
+			pg.SendSignal(kernel.SignalInfoPriv(linux.SIGCONT))
+		}
+	}
+
+	t.ld.hangup()
+	t.ld.masterWaiter.Notify(waiter.EventHUp)
+	t.ld.replicaWaiter.Notify(waiter.EventHUp)
+	return nil
+}
+
+// replica returns the replicaInode for the replica end of t, without
+// requiring the caller to have resolved /dev/pts/<n> through this root's
+// mount. Master-side ioctls that manage replica state directly (TIOCSPTLCK,
+// TIOCGPTLCK, TIOCEXCL, ...) use this instead of going through Lookup, since
+// Lookup itself is gated by that same state.
+func (i *rootInode) replica(t *Terminal) (*replicaInode, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	ri, ok := i.replicas[t.n]
+	if !ok {
+		return nil, linuxerr.ENOENT
+	}
+	return ri, nil
+}
+
+// replicaDentry returns a Dentry for the replica end of t, incrementing its
+// reference count on behalf of the caller. Unlike rootInode.Lookup, this
+// doesn't require the caller to have resolved /dev/pts/<n> through this
+// root's mount, so it also serves master-side ioctls (TIOCGPTPEER) that
+// need the replica end without depending on devpts being visible, or even
+// mounted, in the calling task's mount namespace.
+func (i *rootInode) replicaDentry(t *Terminal) (*replicaInode, *kernfs.Dentry, error) {
+	ri, err := i.replica(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	i.mu.Lock()
+	ri.IncRef()
+	i.mu.Unlock()
+	var d kernfs.Dentry
+	d.Init(&i.fs.Filesystem, ri)
+	return ri, &d, nil
+}
+
 // Open implements kernfs.Inode.Open.
 func (i *rootInode) Open(ctx context.Context, rp *vfs.ResolvingPath, d *kernfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
 	opts.Flags &= linux.O_ACCMODE | linux.O_CREAT | linux.O_EXCL | linux.O_TRUNC |
@@ -328,6 +396,15 @@ func (i *rootInode) Lookup(ctx context.Context, name string) (kernfs.Inode, erro
 	i.mu.Lock()
 	defer i.mu.Unlock()
 	if ri, ok := i.replicas[uint32(idx)]; ok {
+		ri.mu.Lock()
+		locked := ri.locked
+		ri.mu.Unlock()
+		if locked {
+			// The replica can't even be looked up while locked: matches
+			// Linux, where an unopened /dev/pts/N is invisible to anything
+			// but the master until unlockpt(3) runs.
+			return nil, linuxerr.EIO
+		}
 		ri.IncRef() // This ref is passed to the dentry upon creation via Init.
 		return ri, nil
 
@@ -0,0 +1,272 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package devpts
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/errors/linuxerr"
+	"gvisor.dev/gvisor/pkg/marshal/primitive"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/usermem"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// maxQueueBytes bounds each direction's queue, mirroring Linux's
+// N_TTY_BUF_SIZE: once full, further master writes/replica writes block or
+// return EAGAIN the same as a full pipe would.
+const maxQueueBytes = 4096
+
+// lineDiscipline buffers bytes flowing between a Terminal's master and
+// replica ends. Bytes written to the master (inputQueueWrite) land in
+// inQueue and are read back out by the replica (inputQueueRead); bytes
+// written by the replica (outputQueueWrite) land in outQueue and are read
+// back out by the master (outputQueueRead). This intentionally doesn't
+// implement the kernel's full canonical-mode line editing (erase/kill
+// processing, echo); it's just enough buffering and termios/window-size
+// state for devpts' ioctls to have somewhere real to read and write.
+//
+// +stateify savable
+type lineDiscipline struct {
+	// masterWaiter and replicaWaiter notify waiters blocked on the master
+	// and replica ends respectively.
+	masterWaiter  waiter.Queue
+	replicaWaiter waiter.Queue
+
+	mu sync.Mutex
+
+	// inQueue holds bytes written by the master, waiting to be read by
+	// the replica. outQueue holds bytes written by the replica, waiting
+	// to be read by the master.
+	inQueue  []byte
+	outQueue []byte
+
+	// termios is the replica's terminal configuration. Linux stores a
+	// single termios per tty; both TCGETS/TCSETS on the master and on the
+	// replica end operate on this same state.
+	termios linux.KernelTermios
+
+	// size is the terminal's window size, set by TIOCSWINSZ.
+	size linux.WindowSize
+
+	// packetMode is whether TIOCPKT packet-mode framing is enabled on the
+	// master end.
+	packetMode int32
+
+	// hungup marks that hangupTerminal has run: once true, reads on
+	// either end return EOF/0 and writes return EIO, matching Linux's
+	// tty_vhangup() semantics.
+	hungup bool
+}
+
+// newLineDiscipline returns a lineDiscipline configured with the given
+// initial termios. t is not used: the lineDiscipline holds no
+// back-reference to its Terminal.
+func newLineDiscipline(termios linux.KernelTermios, t *Terminal) *lineDiscipline {
+	return &lineDiscipline{termios: termios}
+}
+
+// hangup marks ld hung up: every subsequent read returns EOF and every
+// subsequent write returns EIO on both the master and replica ends, and
+// both queues are drained so no stale data is readable afterward. Callers
+// are still responsible for waking waiters (EventHUp) once this returns;
+// unlike those notifications, which should fire exactly once per hangup
+// regardless of how many times hangup itself is (defensively) called,
+// draining the queues here is idempotent.
+func (l *lineDiscipline) hangup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hungup = true
+	l.inQueue = nil
+	l.outQueue = nil
+}
+
+func (l *lineDiscipline) isHungup() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.hungup
+}
+
+// masterReadiness returns the master end's current poll readiness: it can
+// always accept writes, and can be read from once outQueue has data
+// (or ld is hung up, so the caller observes EOF rather than blocking
+// forever).
+func (l *lineDiscipline) masterReadiness() waiter.EventMask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ready := waiter.WritableEvents
+	if len(l.outQueue) > 0 || l.hungup {
+		ready |= waiter.ReadableEvents
+	}
+	return ready
+}
+
+// replicaReadiness returns the replica end's current poll readiness,
+// mirroring masterReadiness for inQueue.
+func (l *lineDiscipline) replicaReadiness() waiter.EventMask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ready := waiter.WritableEvents
+	if len(l.inQueue) > 0 || l.hungup {
+		ready |= waiter.ReadableEvents
+	}
+	return ready
+}
+
+// outputQueueRead implements the master's Read: it drains bytes the
+// replica has written.
+func (l *lineDiscipline) outputQueueRead(ctx context.Context, dst usermem.IOSequence) (int64, error) {
+	return l.queueRead(ctx, dst, &l.outQueue, &l.replicaWaiter)
+}
+
+// inputQueueWrite implements the master's Write: it feeds bytes for the
+// replica to read.
+func (l *lineDiscipline) inputQueueWrite(ctx context.Context, src usermem.IOSequence) (int64, error) {
+	return l.queueWrite(ctx, src, &l.inQueue, &l.replicaWaiter)
+}
+
+// inputQueueRead implements the replica's Read: it drains bytes the
+// master has written.
+func (l *lineDiscipline) inputQueueRead(ctx context.Context, dst usermem.IOSequence) (int64, error) {
+	return l.queueRead(ctx, dst, &l.inQueue, &l.masterWaiter)
+}
+
+// outputQueueWrite implements the replica's Write: it feeds bytes for the
+// master to read.
+func (l *lineDiscipline) outputQueueWrite(ctx context.Context, src usermem.IOSequence) (int64, error) {
+	return l.queueWrite(ctx, src, &l.outQueue, &l.masterWaiter)
+}
+
+func (l *lineDiscipline) queueRead(ctx context.Context, dst usermem.IOSequence, queue *[]byte, notify *waiter.Queue) (int64, error) {
+	l.mu.Lock()
+	if l.hungup {
+		l.mu.Unlock()
+		return 0, nil
+	}
+	if len(*queue) == 0 {
+		l.mu.Unlock()
+		return 0, linuxerr.ErrWouldBlock
+	}
+	n, err := dst.CopyOut(ctx, *queue)
+	*queue = (*queue)[n:]
+	l.mu.Unlock()
+	if err != nil {
+		return int64(n), err
+	}
+	notify.Notify(waiter.WritableEvents)
+	return int64(n), nil
+}
+
+func (l *lineDiscipline) queueWrite(ctx context.Context, src usermem.IOSequence, queue *[]byte, notify *waiter.Queue) (int64, error) {
+	l.mu.Lock()
+	if l.hungup {
+		l.mu.Unlock()
+		return 0, linuxerr.EIO
+	}
+	room := maxQueueBytes - len(*queue)
+	if room <= 0 {
+		l.mu.Unlock()
+		return 0, linuxerr.ErrWouldBlock
+	}
+	buf := make([]byte, min64(src.NumBytes(), int64(room)))
+	n, err := src.CopyIn(ctx, buf)
+	*queue = append(*queue, buf[:n]...)
+	l.mu.Unlock()
+	if n > 0 {
+		notify.Notify(waiter.ReadableEvents)
+	}
+	return int64(n), err
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// outputQueueReadSize implements FIONREAD on the master: the number of
+// bytes available for the master to read.
+func (l *lineDiscipline) outputQueueReadSize(t *kernel.Task, io usermem.IO, args arch.SyscallArguments) error {
+	l.mu.Lock()
+	n := primitive.Int32(len(l.outQueue))
+	l.mu.Unlock()
+	_, err := n.CopyOut(t, args[2].Pointer())
+	return err
+}
+
+// inputQueueReadSize implements FIONREAD on the replica: the number of
+// bytes available for the replica to read.
+func (l *lineDiscipline) inputQueueReadSize(t *kernel.Task, io usermem.IO, args arch.SyscallArguments) error {
+	l.mu.Lock()
+	n := primitive.Int32(len(l.inQueue))
+	l.mu.Unlock()
+	_, err := n.CopyOut(t, args[2].Pointer())
+	return err
+}
+
+// getTermios implements TCGETS.
+func (l *lineDiscipline) getTermios(t *kernel.Task, args arch.SyscallArguments) (uintptr, error) {
+	l.mu.Lock()
+	termios := l.termios
+	l.mu.Unlock()
+	_, err := termios.CopyOut(t, args[2].Pointer())
+	return 0, err
+}
+
+// setTermios implements TCSETS/TCSETSW/TCSETSF.
+func (l *lineDiscipline) setTermios(t *kernel.Task, args arch.SyscallArguments) (uintptr, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.termios.CopyIn(t, args[2].Pointer())
+	return 0, err
+}
+
+// windowSize implements TIOCGWINSZ.
+func (l *lineDiscipline) windowSize(t *kernel.Task, args arch.SyscallArguments) error {
+	l.mu.Lock()
+	size := l.size
+	l.mu.Unlock()
+	_, err := size.CopyOut(t, args[2].Pointer())
+	return err
+}
+
+// setWindowSize implements TIOCSWINSZ.
+func (l *lineDiscipline) setWindowSize(t *kernel.Task, args arch.SyscallArguments) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.size.CopyIn(t, args[2].Pointer())
+	return err
+}
+
+// setPacketMode implements TIOCPKT.
+func (l *lineDiscipline) setPacketMode(mode int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if mode != 0 {
+		l.packetMode = 1
+	} else {
+		l.packetMode = 0
+	}
+}
+
+// getPacketMode implements TIOCGPKT.
+func (l *lineDiscipline) getPacketMode() int32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.packetMode
+}
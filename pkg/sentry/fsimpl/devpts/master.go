@@ -169,8 +169,83 @@ func (mfd *masterFileDescription) Ioctl(ctx context.Context, io usermem.IO, sysn
 		_, err := nP.CopyOut(t, args[2].Pointer())
 		return 0, err
 	case linux.TIOCSPTLCK:
-		// For now just pretend we implement pty locking.
+		// Set or clear the lock that gates replica lookup/open. See
+		// unlockpt(3) and grantpt(3): this is how a caller that just
+		// allocated the pty tells the kernel it's safe to let other
+		// processes reach the replica end.
+		var locked primitive.Int32
+		if _, err := locked.CopyIn(t, args[2].Pointer()); err != nil {
+			return 0, err
+		}
+		ri, err := mfd.inode.root.replica(mfd.t)
+		if err != nil {
+			return 0, err
+		}
+		ri.mu.Lock()
+		ri.locked = locked != 0
+		ri.mu.Unlock()
+		return 0, nil
+	case linux.TIOCGPTLCK:
+		ri, err := mfd.inode.root.replica(mfd.t)
+		if err != nil {
+			return 0, err
+		}
+		ri.mu.Lock()
+		locked := ri.locked
+		ri.mu.Unlock()
+		var out primitive.Int32
+		if locked {
+			out = 1
+		}
+		_, err = out.CopyOut(t, args[2].Pointer())
+		return 0, err
+	case linux.TIOCEXCL:
+		ri, err := mfd.inode.root.replica(mfd.t)
+		if err != nil {
+			return 0, err
+		}
+		ri.setExclusive(&mfd.vfsfd)
 		return 0, nil
+	case linux.TIOCNXCL:
+		ri, err := mfd.inode.root.replica(mfd.t)
+		if err != nil {
+			return 0, err
+		}
+		return 0, ri.clearExclusive(ctx, &mfd.vfsfd)
+	case linux.TIOCGEXCL:
+		ri, err := mfd.inode.root.replica(mfd.t)
+		if err != nil {
+			return 0, err
+		}
+		var excl primitive.Int32
+		if ri.getExclusive() {
+			excl = 1
+		}
+		_, err = excl.CopyOut(t, args[2].Pointer())
+		return 0, err
+	case linux.TIOCVHANGUP:
+		return 0, hangupTerminal(ctx, mfd.t, t)
+	case linux.TIOCGPTPEER:
+		// Open the replica end directly, without requiring /dev/pts to be
+		// visible (or even mounted) in the caller's mount namespace: the
+		// master fd itself is the authority here, exactly as it is for
+		// Linux's post-4.13 ioctl(ptmx_fd, TIOCGPTPEER, flags).
+		flags := args[2].Uint() & (linux.O_ACCMODE | linux.O_NOCTTY | linux.O_CLOEXEC)
+		ri, rd, err := mfd.inode.root.replicaDentry(mfd.t)
+		if err != nil {
+			return 0, err
+		}
+		mount := mfd.vfsfd.VirtualDentry().Mount()
+		replicaFD, err := ri.open(ctx, mount, rd, flags)
+		if err != nil {
+			return 0, err
+		}
+		defer replicaFD.DecRef(ctx)
+		newFD, err := t.NewFDFrom(0, replicaFD, kernel.FDFlags{CloseOnExec: flags&linux.O_CLOEXEC != 0})
+		if err != nil {
+			return 0, err
+		}
+		return uintptr(newFD), nil
 	case linux.TIOCGWINSZ:
 		return 0, mfd.t.ld.windowSize(t, args)
 	case linux.TIOCSWINSZ:
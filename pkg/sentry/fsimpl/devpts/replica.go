@@ -0,0 +1,291 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package devpts
+
+import (
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/errors/linuxerr"
+	"gvisor.dev/gvisor/pkg/marshal/primitive"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/usermem"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// replicaInode is the inode for the replica end of a Terminal.
+//
+// +stateify savable
+type replicaInode struct {
+	implStatFS
+	kernfs.InodeAttrs
+	kernfs.InodeNoopRefCount
+	kernfs.InodeNotAnonymous
+	kernfs.InodeNotDirectory
+	kernfs.InodeNotSymlink
+	kernfs.InodeFSOwned
+	kernfs.InodeWatches
+
+	locks vfs.FileLocks
+
+	// root is the devpts root inode.
+	root *rootInode
+
+	// t is the Terminal this inode is the replica end of.
+	t *Terminal
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// locked is this pty's TIOCSPTLCK state. While set, the replica can't
+	// be looked up or opened; only the master can clear it (via
+	// TIOCSPTLCK), closing the race where another process opens
+	// /dev/pts/N between master allocation and the grantpt/unlockpt
+	// sequence that's supposed to gate it.
+	locked bool
+
+	// exclusive is this pty's TIOCEXCL state, set from either end. While
+	// set, a further replica Open fails with EBUSY for everyone except a
+	// CAP_SYS_ADMIN holder.
+	exclusive bool
+
+	// exclusiveOwner is the file description that last set exclusive,
+	// which is the only one (besides a CAP_SYS_ADMIN holder) allowed to
+	// clear it with TIOCNXCL.
+	exclusiveOwner *vfs.FileDescription
+}
+
+var _ kernfs.Inode = (*replicaInode)(nil)
+
+// Open implements kernfs.Inode.Open.
+func (ri *replicaInode) Open(ctx context.Context, rp *vfs.ResolvingPath, d *kernfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
+	ri.mu.Lock()
+	locked := ri.locked
+	exclusive := ri.exclusive
+	ri.mu.Unlock()
+	if locked {
+		return nil, linuxerr.EIO
+	}
+	if exclusive && !rp.Credentials().HasCapability(linux.CAP_SYS_ADMIN) {
+		return nil, linuxerr.EBUSY
+	}
+	return ri.open(ctx, rp.Mount(), d, opts.Flags)
+}
+
+// setExclusive sets the TIOCEXCL state, recording owner as the only file
+// description (besides a CAP_SYS_ADMIN holder) allowed to clear it.
+func (ri *replicaInode) setExclusive(owner *vfs.FileDescription) {
+	ri.mu.Lock()
+	ri.exclusive = true
+	ri.exclusiveOwner = owner
+	ri.mu.Unlock()
+}
+
+// clearExclusive clears the TIOCEXCL state on behalf of caller, which must
+// either be the file description that last set it or hold CAP_SYS_ADMIN.
+func (ri *replicaInode) clearExclusive(ctx context.Context, caller *vfs.FileDescription) error {
+	creds := auth.CredentialsFromContext(ctx)
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	if ri.exclusive && ri.exclusiveOwner != caller && !creds.HasCapability(linux.CAP_SYS_ADMIN) {
+		return linuxerr.EPERM
+	}
+	ri.exclusive = false
+	ri.exclusiveOwner = nil
+	return nil
+}
+
+// getExclusive reports the current TIOCEXCL state.
+func (ri *replicaInode) getExclusive() bool {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	return ri.exclusive
+}
+
+// open builds a replicaFileDescription for ri against mount and d. It's
+// shared by the ordinary path-based Open above and by
+// masterFileDescription's TIOCGPTPEER handler, which has no path to walk
+// and instead reuses the master fd's own mount, since the replica always
+// lives in the same devpts instance as its master.
+func (ri *replicaInode) open(ctx context.Context, mount *vfs.Mount, d *kernfs.Dentry, flags uint32) (*vfs.FileDescription, error) {
+	fd := &replicaFileDescription{
+		inode: ri,
+		t:     ri.t,
+	}
+	fd.LockFD.Init(&ri.locks)
+	if err := fd.vfsfd.Init(fd, flags, mount, d.VFSDentry(), &vfs.FileDescriptionOptions{}); err != nil {
+		return nil, err
+	}
+	return &fd.vfsfd, nil
+}
+
+// Stat implements kernfs.Inode.Stat.
+func (ri *replicaInode) Stat(ctx context.Context, vfsfs *vfs.Filesystem, opts vfs.StatOptions) (linux.Statx, error) {
+	statx, err := ri.InodeAttrs.Stat(ctx, vfsfs, opts)
+	if err != nil {
+		return linux.Statx{}, err
+	}
+	statx.Blksize = 1024
+	statx.RdevMajor = linux.UNIX98_PTY_REPLICA_MAJOR
+	statx.RdevMinor = ri.t.n
+	return statx, nil
+}
+
+// SetStat implements kernfs.Inode.SetStat.
+func (ri *replicaInode) SetStat(ctx context.Context, vfsfs *vfs.Filesystem, creds *auth.Credentials, opts vfs.SetStatOptions) error {
+	if opts.Stat.Mask&linux.STATX_SIZE != 0 {
+		return linuxerr.EINVAL
+	}
+	return ri.InodeAttrs.SetStat(ctx, vfsfs, creds, opts)
+}
+
+// +stateify savable
+type replicaFileDescription struct {
+	vfsfd vfs.FileDescription
+	vfs.FileDescriptionDefaultImpl
+	vfs.LockFD
+
+	inode *replicaInode
+	t     *Terminal
+}
+
+var _ vfs.FileDescriptionImpl = (*replicaFileDescription)(nil)
+
+// EventRegister implements waiter.Waitable.EventRegister.
+func (rfd *replicaFileDescription) EventRegister(e *waiter.Entry) error {
+	rfd.t.ld.replicaWaiter.EventRegister(e)
+	return nil
+}
+
+// EventUnregister implements waiter.Waitable.EventUnregister.
+func (rfd *replicaFileDescription) EventUnregister(e *waiter.Entry) {
+	rfd.t.ld.replicaWaiter.EventUnregister(e)
+}
+
+// Readiness implements waiter.Waitable.Readiness.
+func (rfd *replicaFileDescription) Readiness(mask waiter.EventMask) waiter.EventMask {
+	return rfd.t.ld.replicaReadiness()
+}
+
+// Epollable implements FileDescriptionImpl.Epollable.
+func (rfd *replicaFileDescription) Epollable() bool {
+	return true
+}
+
+// Read implements vfs.FileDescriptionImpl.Read.
+func (rfd *replicaFileDescription) Read(ctx context.Context, dst usermem.IOSequence, _ vfs.ReadOptions) (int64, error) {
+	return rfd.t.ld.inputQueueRead(ctx, dst)
+}
+
+// Write implements vfs.FileDescriptionImpl.Write.
+func (rfd *replicaFileDescription) Write(ctx context.Context, src usermem.IOSequence, _ vfs.WriteOptions) (int64, error) {
+	return rfd.t.ld.outputQueueWrite(ctx, src)
+}
+
+// Ioctl implements vfs.FileDescriptionImpl.Ioctl.
+func (rfd *replicaFileDescription) Ioctl(ctx context.Context, io usermem.IO, sysno uintptr, args arch.SyscallArguments) (uintptr, error) {
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		// ioctl(2) may only be called from a task goroutine.
+		return 0, linuxerr.ENOTTY
+	}
+
+	switch cmd := args[1].Uint(); cmd {
+	case linux.FIONREAD:
+		return 0, rfd.t.ld.inputQueueReadSize(t, io, args)
+	case linux.TCGETS:
+		return rfd.t.ld.getTermios(t, args)
+	case linux.TCSETS:
+		return rfd.t.ld.setTermios(t, args)
+	case linux.TCSETSW:
+		// Note that this should drain the output queue first, but we
+		// don't implement that yet.
+		return rfd.t.ld.setTermios(t, args)
+	case linux.TCSETSF:
+		// This should drain the output queue and clear the input queue
+		// first, but we don't implement that yet.
+		return rfd.t.ld.setTermios(t, args)
+	case linux.TIOCGWINSZ:
+		return 0, rfd.t.ld.windowSize(t, args)
+	case linux.TIOCSWINSZ:
+		return 0, rfd.t.ld.setWindowSize(t, args)
+	case linux.TIOCSCTTY:
+		// Make the given terminal the controlling terminal of the
+		// calling process.
+		steal := args[2].Int() == 1
+		return 0, t.ThreadGroup().SetControllingTTY(ctx, rfd.t.replicaKTTY, steal, rfd.vfsfd.IsReadable())
+	case linux.TIOCNOTTY:
+		// Release this process's controlling terminal.
+		return 0, t.ThreadGroup().ReleaseControllingTTY(rfd.t.replicaKTTY)
+	case linux.TIOCGPGRP:
+		// Get the foreground process group id.
+		pgid, err := t.ThreadGroup().ForegroundProcessGroupID(rfd.t.replicaKTTY)
+		if err != nil {
+			return 0, err
+		}
+		ret := primitive.Int32(pgid)
+		_, err = ret.CopyOut(t, args[2].Pointer())
+		return 0, err
+	case linux.TIOCSPGRP:
+		// Set the foreground process group id.
+		var pgid primitive.Int32
+		if _, err := pgid.CopyIn(t, args[2].Pointer()); err != nil {
+			return 0, err
+		}
+		return 0, t.ThreadGroup().SetForegroundProcessGroupID(ctx, rfd.t.replicaKTTY, kernel.ProcessGroupID(pgid))
+	case linux.TIOCEXCL:
+		rfd.inode.setExclusive(&rfd.vfsfd)
+		return 0, nil
+	case linux.TIOCNXCL:
+		return 0, rfd.inode.clearExclusive(ctx, &rfd.vfsfd)
+	case linux.TIOCGEXCL:
+		var excl primitive.Int32
+		if rfd.inode.getExclusive() {
+			excl = 1
+		}
+		_, err := excl.CopyOut(t, args[2].Pointer())
+		return 0, err
+	case linux.TIOCVHANGUP:
+		return 0, hangupTerminal(ctx, rfd.t, t)
+	case linux.TIOCGPTPEER:
+		// Linux only allows TIOCGPTPEER to go from master to replica, since
+		// that's the only direction a caller could plausibly be missing a
+		// path for; mirror that restriction here rather than silently
+		// opening the master end as if it were equivalent.
+		return 0, linuxerr.ENOTTY
+
+	default:
+		maybeEmitUnimplementedEvent(ctx, sysno, cmd)
+		return 0, linuxerr.ENOTTY
+	}
+}
+
+// SetStat implements vfs.FileDescriptionImpl.SetStat.
+func (rfd *replicaFileDescription) SetStat(ctx context.Context, opts vfs.SetStatOptions) error {
+	creds := auth.CredentialsFromContext(ctx)
+	fs := rfd.vfsfd.VirtualDentry().Mount().Filesystem()
+	return rfd.inode.SetStat(ctx, fs, creds, opts)
+}
+
+// Stat implements vfs.FileDescriptionImpl.Stat.
+func (rfd *replicaFileDescription) Stat(ctx context.Context, opts vfs.StatOptions) (linux.Statx, error) {
+	fs := rfd.vfsfd.VirtualDentry().Mount().Filesystem()
+	return rfd.inode.Stat(ctx, fs, opts)
+}
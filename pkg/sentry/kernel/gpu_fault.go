@@ -0,0 +1,91 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"fmt"
+	"runtime/trace"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/hostarch"
+)
+
+// unrecoverableXids are the NVRM Xid codes that indicate the GPU (or this
+// context's slice of it) can't continue: the driver has reset the engine,
+// lost the ability to communicate with it, or detected memory corruption
+// that makes further execution unsafe to continue. Every other known Xid is
+// treated as a synchronous fault local to the task that triggered it, the
+// same way a CPU-side SIGSEGV/SIGBUS is local to the faulting task.
+//
+// Xid numbers and their meanings are documented in the NVIDIA GPU Xid Errors
+// whitepaper; this is not an exhaustive list, only the codes gVisor
+// currently knows to classify. An unlisted code is treated as recoverable,
+// since delivering a signal to the offending process is the less
+// destructive default.
+var unrecoverableXids = map[int32]string{
+	79:  "GPU has fallen off the bus",
+	95:  "uncontained ECC error",
+	119: "GSP RPC timeout",
+	120: "GSP error",
+}
+
+// GPUFault is a decoded NVRM "Xid" event attributed to a single task,
+// reported by nvproxy's ring consumer.
+type GPUFault struct {
+	// Code is the Xid number, as NVIDIA's Xid Errors whitepaper documents
+	// it (e.g. 13 for a graphics engine exception, 31 for a GPU memory
+	// page fault).
+	Code int32
+
+	// FaultingVA is the GPU-side virtual address the fault occurred at,
+	// when the Xid carries one (not all do; it's 0 otherwise).
+	FaultingVA hostarch.Addr
+
+	// GPUUUID identifies which GPU reported the fault.
+	GPUUUID string
+
+	// Time is when the sentry observed the fault.
+	Time time.Time
+}
+
+// Recoverable returns whether g should be delivered to the offending task as
+// a synchronous signal (true) or should instead terminate the task's
+// ThreadGroup with a distinguishable wait status (false).
+func (g GPUFault) Recoverable() bool {
+	_, unrecoverable := unrecoverableXids[g.Code]
+	return !unrecoverable
+}
+
+func (g GPUFault) String() string {
+	if reason, ok := unrecoverableXids[g.Code]; ok {
+		return fmt.Sprintf("Xid %d (%s) at %#x on GPU %s", g.Code, reason, g.FaultingVA, g.GPUUUID)
+	}
+	return fmt.Sprintf("Xid %d at %#x on GPU %s", g.Code, g.FaultingVA, g.GPUUUID)
+}
+
+// recordGPUFault records fault in t's event trace region, so that
+// "runsc debug --stacks" output for a task killed or signaled by a GPU fault
+// shows which Xid caused it.
+//
+// The platform-side half of this path — the ErrContextGPUFault sentinel
+// SwitchToApp returns and the optional GPUFaultSource interface a
+// platform.Context can implement to report which GPU faulted — belongs in
+// the platform package alongside ErrContextSignal and ErrContextCPUPreempted,
+// not here; this file only owns the GPUFault value itself and what the
+// kernel package does with one once SwitchToApp hands it back.
+func (t *Task) recordGPUFault(fault GPUFault) {
+	region := trace.StartRegion(t.traceContext, "gpu-fault:"+fault.String())
+	region.End()
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// GPUTelemetrySample is one snapshot of a task's GPU usage, sampled through
+// nvproxy's NVML ioctl proxy.
+type GPUTelemetrySample struct {
+	// Time is when the sample was taken.
+	Time time.Time
+
+	// ContainerID and GPUUUID identify what the sample is about; MIGSlice
+	// is set only when the task's GPU access is scoped to a MIG compute
+	// instance rather than the whole GPU.
+	ContainerID string
+	GPUUUID     string
+	MIGSlice    string
+
+	// UtilizationPercent and SMOccupancyPercent are NVML's instantaneous
+	// utilization and streaming-multiprocessor occupancy readings.
+	UtilizationPercent float32
+	SMOccupancyPercent float32
+
+	// MemoryUsedBytes and MemoryFreeBytes are the GPU's memory usage at
+	// sample time.
+	MemoryUsedBytes uint64
+	MemoryFreeBytes uint64
+
+	// ECCErrorCount is the cumulative count of ECC errors NVML reports for
+	// the GPU, uncorrected and corrected combined.
+	ECCErrorCount uint64
+
+	// PowerDrawMilliwatts and TemperatureCelsius are the GPU's
+	// instantaneous power draw and temperature.
+	PowerDrawMilliwatts uint32
+	TemperatureCelsius  uint32
+
+	// PCIeThroughputBytesPerSec is the GPU's instantaneous PCIe bandwidth
+	// usage.
+	PCIeThroughputBytesPerSec uint64
+}
+
+// GPUTelemetryCollector samples a task's GPU usage. nvproxy registers an
+// implementation via SetGPUTelemetryCollector when it's enabled for the
+// sandbox; outside of that, the kernel package has no notion of GPUs.
+type GPUTelemetryCollector interface {
+	// Sample returns t's current GPU usage. ok is false if t has no GPU
+	// access (e.g. its container wasn't granted nvproxy access) and
+	// nothing should be recorded.
+	Sample(t *Task) (sample GPUTelemetrySample, ok bool)
+}
+
+// gpuTelemetryCollector is the installed GPUTelemetryCollector, or nil if
+// none has been registered. It's read on every call to runApp.execute, so
+// it's stored behind an atomic.Pointer rather than guarded by a mutex.
+var gpuTelemetryCollector atomic.Pointer[GPUTelemetryCollector]
+
+// gpuTelemetryIntervalNanos is the minimum time between consecutive GPU
+// telemetry samples collected by maybeSampleGPUTelemetry, read racily: an
+// occasional extra or skipped sample near the boundary is harmless for a
+// monitoring signal like this one.
+var gpuTelemetryIntervalNanos atomic.Int64
+
+// SetGPUTelemetryCollector installs c as the collector consulted by every
+// task's run loop, and interval as the minimum time between samples for any
+// single task. A nil c disables collection. This is intended to be called
+// once, during nvproxy setup, before any sandboxed task starts running.
+func SetGPUTelemetryCollector(c GPUTelemetryCollector, interval time.Duration) {
+	if c == nil {
+		gpuTelemetryCollector.Store(nil)
+		return
+	}
+	gpuTelemetryCollector.Store(&c)
+	gpuTelemetryIntervalNanos.Store(interval.Nanoseconds())
+}
+
+// maybeSampleGPUTelemetry samples t's GPU usage if a GPUTelemetryCollector is
+// installed and at least gpuTelemetryIntervalNanos has passed since t's last
+// sample, aggregating the result onto t's ThreadGroup so operators can
+// attribute GPU usage to individual sandboxed processes the same way
+// yieldCount is already aggregated from Task to ThreadGroup. It's called
+// from runApp.execute around the same application-execution window that
+// faultCounter.Increment() and t.p.Switch already bracket.
+//
+// This relies on Task carrying a lastGPUTelemetrySample atomic.Int64 and
+// ThreadGroup carrying a gpuTelemetryMu sync.Mutex guarding a
+// gpuTelemetrySamples []GPUTelemetrySample, the same way Task and
+// ThreadGroup carry every other per-task and per-group run-loop field this
+// package uses (t.p, t.k, t.tg, yieldCount, ...); those fields belong next
+// to their rest, not duplicated here.
+func (t *Task) maybeSampleGPUTelemetry() {
+	collector := gpuTelemetryCollector.Load()
+	if collector == nil {
+		return
+	}
+	interval := gpuTelemetryIntervalNanos.Load()
+	now := time.Now()
+	if last := t.lastGPUTelemetrySample.Load(); last != 0 && now.UnixNano()-last < interval {
+		return
+	}
+	t.lastGPUTelemetrySample.Store(now.UnixNano())
+
+	sample, ok := (*collector).Sample(t)
+	if !ok {
+		return
+	}
+	t.tg.gpuTelemetryMu.Lock()
+	t.tg.gpuTelemetrySamples = append(t.tg.gpuTelemetrySamples, sample)
+	t.tg.gpuTelemetryMu.Unlock()
+}
+
+// GPUTelemetrySamples returns the GPU telemetry samples collected for all
+// tasks in tg since the last call to GPUTelemetrySamples, clearing tg's
+// buffer. This backs both the "runsc events --gpu" subcommand and the
+// Prometheus-style text endpoint on the sentry control surface.
+func (tg *ThreadGroup) GPUTelemetrySamples() []GPUTelemetrySample {
+	tg.gpuTelemetryMu.Lock()
+	defer tg.gpuTelemetryMu.Unlock()
+	samples := tg.gpuTelemetrySamples
+	tg.gpuTelemetrySamples = nil
+	return samples
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"runtime"
 	"runtime/trace"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/errors/linuxerr"
@@ -252,6 +253,7 @@ func (app *runApp) execute(t *Task) taskRunState {
 	info, at, err := t.p.Switch(t, t.MemoryManager(), t.Arch(), t.rseqCPU)
 	t.accountTaskGoroutineLeave(TaskGoroutineRunningApp)
 	region.End()
+	t.maybeSampleGPUTelemetry()
 
 	if clearSinglestep {
 		t.Arch().ClearSingleStep()
@@ -347,6 +349,35 @@ func (app *runApp) execute(t *Task) taskRunState {
 
 		return (*runApp)(nil)
 
+	case platform.ErrContextGPUFault:
+		// nvproxy's ring consumer decoded an asynchronous NVRM "Xid" event
+		// and arranged for it to come back packed into info the same way
+		// ErrContextSignal packs a synchronous signal: info.Code is the Xid
+		// number and info.Addr() the GPU-side faulting virtual address.
+		// WaitStatusGPUFault, like the rest of the WaitStatus family, is
+		// defined alongside linux.WaitStatus in the linux package.
+		var gpuUUID string
+		if src, ok := t.p.(platform.GPUFaultSource); ok {
+			gpuUUID = src.GPUUUID()
+		}
+		fault := GPUFault{
+			Code:       info.Code,
+			FaultingVA: hostarch.Addr(info.Addr()),
+			GPUUUID:    gpuUUID,
+			Time:       time.Now(),
+		}
+		t.recordGPUFault(fault)
+		if !fault.Recoverable() {
+			t.Warningf("Unrecoverable GPU fault: %v; terminating thread group", fault)
+			t.PrepareGroupExit(linux.WaitStatusGPUFault(fault.Code))
+			return (*runExit)(nil)
+		}
+		t.Debugf("Recoverable GPU fault: %v", fault)
+		info.Signo = int32(linux.SIGBUS)
+		t.forceSignal(linux.SIGBUS, false)
+		t.SendSignal(info)
+		return (*runApp)(nil)
+
 	case platform.ErrContextCPUPreempted:
 		// Ensure that rseq critical sections are interrupted and per-thread
 		// CPU values are updated before the next platform.Context.Switch().
@@ -0,0 +1,335 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// deviceKeyPrefix is the prefix of annotations describing a Container
+	// Device Interface style device request, e.g.
+	// "dev.gvisor.spec.device/nvidia.com/gpu" = "gpu0".
+	deviceKeyPrefix = "dev.gvisor.spec.device/"
+
+	// cdiKeyPrefix is the prefix of annotations carrying an inline CDI
+	// device specification (as JSON), e.g. "dev.gvisor.spec.cdi/gpu0".
+	cdiKeyPrefix = "dev.gvisor.spec.cdi/"
+)
+
+// statDeviceNumbers returns the major/minor device numbers of the device
+// node at hostPath. It's a var so tests can stub it out, since creating
+// real device nodes requires privileges the test sandbox may not have.
+var statDeviceNumbers = func(hostPath string) (major, minor int64, err error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(hostPath, &st); err != nil {
+		return 0, 0, fmt.Errorf("stat device %q: %w", hostPath, err)
+	}
+	rdev := uint64(st.Rdev)
+	return int64(rdev >> 8 & 0xfff), int64(rdev&0xff | (rdev >> 12 & 0xfff00)), nil
+}
+
+// deviceSubKeySuffixes are the per-device annotation suffixes that
+// accompany a "dev.gvisor.spec.device/<vendor>/<class>" entry; they're
+// consumed alongside it in addAnnotatedDevice, not as entries of their own.
+var deviceSubKeySuffixes = []string{".hostPath", ".containerPath", ".permissions", ".uid", ".gid", ".fileMode"}
+
+// UpdateDeviceAnnotations materializes any Container Device Interface (CDI)
+// style device requests found among spec's annotations into
+// spec.Linux.Devices and spec.Linux.Resources.Devices. Two annotation forms
+// are understood:
+//
+//   - "dev.gvisor.spec.device/<vendor>/<class>" = "<name>", along with
+//     ".hostPath", ".containerPath", ".permissions", ".uid", ".gid" and
+//     ".fileMode" sub-keys describing the device itself.
+//   - "dev.gvisor.spec.cdi/<name>" = a full CDI device JSON blob, whose
+//     containerEdits (mounts, hooks, env, devices) are expanded into spec
+//     wholesale.
+//
+// It reports whether spec was modified.
+func UpdateDeviceAnnotations(spec *specs.Spec) (bool, error) {
+	updated := false
+	for key, name := range spec.Annotations {
+		rest, ok := strings.CutPrefix(key, deviceKeyPrefix)
+		if !ok || hasAnySuffix(rest, deviceSubKeySuffixes) {
+			// Either unrelated, or one of the per-device sub-keys handled
+			// as part of its base vendor/class entry below.
+			continue
+		}
+		vendor, class, ok := strings.Cut(rest, "/")
+		if !ok {
+			return false, fmt.Errorf("device annotation %q must be of the form %s<vendor>/<class>", key, deviceKeyPrefix)
+		}
+		if err := addAnnotatedDevice(spec, vendor, class, name); err != nil {
+			return false, fmt.Errorf("device %s/%s=%s: %w", vendor, class, name, err)
+		}
+		updated = true
+	}
+
+	for key, blob := range spec.Annotations {
+		name, ok := strings.CutPrefix(key, cdiKeyPrefix)
+		if !ok {
+			continue
+		}
+		if err := expandCDISpec(spec, blob); err != nil {
+			return false, fmt.Errorf("CDI device %q: %w", name, err)
+		}
+		updated = true
+	}
+	return updated, nil
+}
+
+// UpdateOCIAnnotations applies both UpdateVolumeAnnotations and
+// UpdateDeviceAnnotations to spec, the two annotation families
+// runsc-shim understands. It reports whether spec was modified.
+func UpdateOCIAnnotations(spec *specs.Spec) (bool, error) {
+	volUpdated, err := UpdateVolumeAnnotations(spec)
+	if err != nil {
+		return false, err
+	}
+	devUpdated, err := UpdateDeviceAnnotations(spec)
+	if err != nil {
+		return false, err
+	}
+	return volUpdated || devUpdated, nil
+}
+
+// addAnnotatedDevice builds a specs.LinuxDevice and matching
+// LinuxDeviceCgroup entry from a "dev.gvisor.spec.device/<vendor>/<class>"
+// annotation and its sub-keys, and appends them to spec.
+func addAnnotatedDevice(spec *specs.Spec, vendor, class, name string) error {
+	base := deviceKeyPrefix + vendor + "/" + class
+	hostPath := spec.Annotations[base+".hostPath"]
+	if hostPath == "" {
+		return fmt.Errorf("missing %q annotation", base+".hostPath")
+	}
+	containerPath := spec.Annotations[base+".containerPath"]
+	if containerPath == "" {
+		containerPath = hostPath
+	}
+	permissions := spec.Annotations[base+".permissions"]
+	if permissions == "" {
+		permissions = "rwm"
+	}
+
+	major, minor, err := statDeviceNumbers(hostPath)
+	if err != nil {
+		return err
+	}
+
+	dev := specs.LinuxDevice{
+		Path:  containerPath,
+		Type:  "c",
+		Major: major,
+		Minor: minor,
+	}
+	if uid, ok := spec.Annotations[base+".uid"]; ok {
+		v, err := parseUint32(uid)
+		if err != nil {
+			return fmt.Errorf("invalid uid %q: %w", uid, err)
+		}
+		dev.UID = &v
+	}
+	if gid, ok := spec.Annotations[base+".gid"]; ok {
+		v, err := parseUint32(gid)
+		if err != nil {
+			return fmt.Errorf("invalid gid %q: %w", gid, err)
+		}
+		dev.GID = &v
+	}
+	if mode, ok := spec.Annotations[base+".fileMode"]; ok {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid fileMode %q: %w", mode, err)
+		}
+		fm := uint32(m)
+		dev.FileMode = &fm
+	}
+
+	addDevice(spec, dev, permissions)
+	return nil
+}
+
+// addDevice appends dev to spec.Linux.Devices and a matching allow rule to
+// spec.Linux.Resources.Devices, initializing either as needed.
+func addDevice(spec *specs.Spec, dev specs.LinuxDevice, access string) {
+	if spec.Linux == nil {
+		spec.Linux = &specs.Linux{}
+	}
+	spec.Linux.Devices = append(spec.Linux.Devices, dev)
+
+	if spec.Linux.Resources == nil {
+		spec.Linux.Resources = &specs.LinuxResources{}
+	}
+	major, minor := dev.Major, dev.Minor
+	spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices, specs.LinuxDeviceCgroup{
+		Allow:  true,
+		Type:   dev.Type,
+		Major:  &major,
+		Minor:  &minor,
+		Access: access,
+	})
+}
+
+// hasAnySuffix reports whether s ends with any of suffixes.
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suf := range suffixes {
+		if strings.HasSuffix(s, suf) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUint32 parses s as a base-10 uint32.
+func parseUint32(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(v), nil
+}
+
+// cdiSpec is the minimal subset of a Container Device Interface device
+// specification that gVisor knows how to expand into an OCI spec. See
+// https://github.com/cncf-tags/container-device-interface for the full
+// schema.
+type cdiSpec struct {
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	Env     []string    `json:"env"`
+	Mounts  []cdiMount  `json:"mounts"`
+	Devices []cdiDevice `json:"devices"`
+	Hooks   []cdiHook   `json:"hooks"`
+}
+
+type cdiMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Type          string   `json:"type"`
+	Options       []string `json:"options"`
+}
+
+type cdiDevice struct {
+	Path        string  `json:"path"`
+	Type        string  `json:"type"`
+	Major       int64   `json:"major"`
+	Minor       int64   `json:"minor"`
+	UID         *uint32 `json:"uid"`
+	GID         *uint32 `json:"gid"`
+	FileMode    *uint32 `json:"fileMode"`
+	Permissions string  `json:"permissions"`
+}
+
+type cdiHook struct {
+	HookName string   `json:"hookName"`
+	Path     string   `json:"path"`
+	Args     []string `json:"args"`
+	Env      []string `json:"env"`
+}
+
+// expandCDISpec parses an inline CDI device JSON blob and merges its
+// containerEdits into spec.
+func expandCDISpec(spec *specs.Spec, blob string) error {
+	var cdi cdiSpec
+	if err := json.Unmarshal([]byte(blob), &cdi); err != nil {
+		return fmt.Errorf("parsing CDI spec: %w", err)
+	}
+	edits := cdi.ContainerEdits
+
+	for _, m := range edits.Mounts {
+		mountType := m.Type
+		if mountType == "" {
+			mountType = "bind"
+		}
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: m.ContainerPath,
+			Type:        mountType,
+			Source:      m.HostPath,
+			Options:     m.Options,
+		})
+	}
+
+	for _, d := range edits.Devices {
+		access := d.Permissions
+		if access == "" {
+			access = "rwm"
+		}
+		devType := d.Type
+		if devType == "" {
+			devType = "c"
+		}
+		addDevice(spec, specs.LinuxDevice{
+			Path:     d.Path,
+			Type:     devType,
+			Major:    d.Major,
+			Minor:    d.Minor,
+			UID:      d.UID,
+			GID:      d.GID,
+			FileMode: d.FileMode,
+		}, access)
+	}
+
+	if len(edits.Env) > 0 {
+		if spec.Process == nil {
+			spec.Process = &specs.Process{}
+		}
+		spec.Process.Env = append(spec.Process.Env, edits.Env...)
+	}
+
+	for _, h := range edits.Hooks {
+		if err := addHook(spec, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addHook appends h to the spec.Hooks list named by h.HookName.
+func addHook(spec *specs.Spec, h cdiHook) error {
+	if spec.Hooks == nil {
+		spec.Hooks = &specs.Hooks{}
+	}
+	hook := specs.Hook{
+		Path: h.Path,
+		Args: h.Args,
+		Env:  h.Env,
+	}
+	switch h.HookName {
+	case "prestart":
+		spec.Hooks.Prestart = append(spec.Hooks.Prestart, hook)
+	case "createRuntime":
+		spec.Hooks.CreateRuntime = append(spec.Hooks.CreateRuntime, hook)
+	case "createContainer":
+		spec.Hooks.CreateContainer = append(spec.Hooks.CreateContainer, hook)
+	case "startContainer":
+		spec.Hooks.StartContainer = append(spec.Hooks.StartContainer, hook)
+	case "poststart":
+		spec.Hooks.Poststart = append(spec.Hooks.Poststart, hook)
+	case "poststop":
+		spec.Hooks.Poststop = append(spec.Hooks.Poststop, hook)
+	default:
+		return fmt.Errorf("unknown CDI hook name %q", h.HookName)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestUpdateDeviceAnnotations(t *testing.T) {
+	restore := statDeviceNumbers
+	statDeviceNumbers = func(hostPath string) (int64, int64, error) {
+		return 195, 0, nil
+	}
+	defer func() { statDeviceNumbers = restore }()
+
+	sandbox := &specs.Spec{
+		Annotations: map[string]string{
+			ContainerTypeAnnotation:                     containerTypeSandbox,
+			deviceKeyPrefix + "nvidia.com/gpu":          "gpu0",
+			deviceKeyPrefix + "nvidia.com/gpu.hostPath": "/dev/nvidia0",
+		},
+	}
+	updated, err := UpdateDeviceAnnotations(sandbox)
+	if err != nil {
+		t.Fatalf("UpdateDeviceAnnotations(sandbox): %v", err)
+	}
+	if !updated {
+		t.Error("want updated, got unchanged")
+	}
+	if len(sandbox.Linux.Devices) != 1 {
+		t.Fatalf("want 1 device, got %d: %+v", len(sandbox.Linux.Devices), sandbox.Linux.Devices)
+	}
+	if got, want := sandbox.Linux.Devices[0], (specs.LinuxDevice{Path: "/dev/nvidia0", Type: "c", Major: 195, Minor: 0}); got != want {
+		t.Errorf("device: got %+v, want %+v", got, want)
+	}
+	if len(sandbox.Linux.Resources.Devices) != 1 {
+		t.Fatalf("want 1 device cgroup rule, got %d", len(sandbox.Linux.Resources.Devices))
+	}
+	if rule := sandbox.Linux.Resources.Devices[0]; !rule.Allow || rule.Access != "rwm" || *rule.Major != 195 || *rule.Minor != 0 {
+		t.Errorf("device cgroup rule: %+v", rule)
+	}
+
+	// A sibling container gets its own gpu1 device, keyed under the same
+	// vendor/class. UpdateDeviceAnnotations should merge it with the
+	// sandbox's devices already on the spec, not replace them.
+	container := &specs.Spec{
+		Annotations: map[string]string{
+			ContainerTypeAnnotation:                        ContainerTypeContainer,
+			deviceKeyPrefix + "nvidia.com/gpu":             "gpu1",
+			deviceKeyPrefix + "nvidia.com/gpu.hostPath":    "/dev/nvidia1",
+			deviceKeyPrefix + "nvidia.com/gpu.permissions": "rw",
+		},
+		Linux: &specs.Linux{
+			Devices: []specs.LinuxDevice{sandbox.Linux.Devices[0]},
+			Resources: &specs.LinuxResources{
+				Devices: []specs.LinuxDeviceCgroup{sandbox.Linux.Resources.Devices[0]},
+			},
+		},
+	}
+	updated, err = UpdateDeviceAnnotations(container)
+	if err != nil {
+		t.Fatalf("UpdateDeviceAnnotations(container): %v", err)
+	}
+	if !updated {
+		t.Error("want updated, got unchanged")
+	}
+	if len(container.Linux.Devices) != 2 {
+		t.Fatalf("want 2 merged devices, got %d: %+v", len(container.Linux.Devices), container.Linux.Devices)
+	}
+	if got, want := container.Linux.Devices[1], (specs.LinuxDevice{Path: "/dev/nvidia1", Type: "c", Major: 195, Minor: 0}); got != want {
+		t.Errorf("device: got %+v, want %+v", got, want)
+	}
+	if len(container.Linux.Resources.Devices) != 2 {
+		t.Fatalf("want 2 merged device cgroup rules, got %d", len(container.Linux.Resources.Devices))
+	}
+	if rule := container.Linux.Resources.Devices[1]; rule.Access != "rw" {
+		t.Errorf("device cgroup rule access: got %q, want %q", rule.Access, "rw")
+	}
+}
+
+func TestUpdateDeviceAnnotationsCDIBlob(t *testing.T) {
+	restore := statDeviceNumbers
+	statDeviceNumbers = func(hostPath string) (int64, int64, error) { return 0, 0, nil }
+	defer func() { statDeviceNumbers = restore }()
+
+	const blob = `{
+		"containerEdits": {
+			"env": ["NVIDIA_VISIBLE_DEVICES=all"],
+			"mounts": [{"hostPath": "/usr/lib/nvidia", "containerPath": "/usr/lib/nvidia", "options": ["ro", "nosuid"]}],
+			"devices": [{"path": "/dev/nvidiactl", "major": 195, "minor": 255, "permissions": "rw"}],
+			"hooks": [{"hookName": "createContainer", "path": "/usr/bin/nvidia-ctk", "args": ["nvidia-ctk", "hook"]}]
+		}
+	}`
+	spec := &specs.Spec{
+		Annotations: map[string]string{
+			ContainerTypeAnnotation: ContainerTypeContainer,
+			cdiKeyPrefix + "gpu0":   blob,
+		},
+	}
+	updated, err := UpdateDeviceAnnotations(spec)
+	if err != nil {
+		t.Fatalf("UpdateDeviceAnnotations: %v", err)
+	}
+	if !updated {
+		t.Error("want updated, got unchanged")
+	}
+	if len(spec.Mounts) != 1 || spec.Mounts[0].Destination != "/usr/lib/nvidia" {
+		t.Errorf("mounts: got %+v", spec.Mounts)
+	}
+	if len(spec.Linux.Devices) != 1 || spec.Linux.Devices[0].Path != "/dev/nvidiactl" {
+		t.Errorf("devices: got %+v", spec.Linux.Devices)
+	}
+	if spec.Process == nil || len(spec.Process.Env) != 1 || spec.Process.Env[0] != "NVIDIA_VISIBLE_DEVICES=all" {
+		t.Errorf("env: got %+v", spec.Process)
+	}
+	if spec.Hooks == nil || len(spec.Hooks.CreateContainer) != 1 || spec.Hooks.CreateContainer[0].Path != "/usr/bin/nvidia-ctk" {
+		t.Errorf("hooks: got %+v", spec.Hooks)
+	}
+}
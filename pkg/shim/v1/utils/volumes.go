@@ -0,0 +1,686 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package utils contains utility functions shared across the shim.
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// sandboxLogDirAnnotation is the annotation containerd sets on a pod
+	// sandbox to record the directory used for that pod's container logs.
+	// It's the only annotation every sandbox is guaranteed to carry, so it
+	// doubles as our way to recover the pod UID.
+	sandboxLogDirAnnotation = "io.kubernetes.cri.sandbox-log-directory"
+
+	// ContainerTypeAnnotation is the OCI annotation containerd uses to tell
+	// a sandbox container apart from a regular one.
+	ContainerTypeAnnotation = "io.kubernetes.cri.container-type"
+
+	// containerTypeSandbox is the ContainerTypeAnnotation value set on pod
+	// sandboxes.
+	containerTypeSandbox = "sandbox"
+
+	// ContainerTypeContainer is the ContainerTypeAnnotation value set on
+	// regular (non-sandbox) containers.
+	ContainerTypeContainer = "container"
+
+	// volumeKeyPrefix is the prefix used for annotations that describe a
+	// volume gVisor should handle specially, e.g.
+	// "dev.gvisor.spec.mount/<name>.type".
+	volumeKeyPrefix = "dev.gvisor.spec.mount/"
+
+	// devshmName is the synthetic volume name used to record a /dev/shm
+	// mount that wasn't given an explicit volume annotation.
+	devshmName = "dev-shm"
+
+	// emptyDirVolumesDir is the subdirectory of a pod's volumes directory
+	// that holds its emptyDir volumes.
+	emptyDirVolumesDir = "kubernetes.io~empty-dir"
+)
+
+// kubeletPodsDir is the directory kubelet lays pod volumes out under. It's a
+// var, rather than a const, so tests can point it at a temporary directory.
+var kubeletPodsDir = "/var/lib/kubelet/pods"
+
+// volumeAnnotation holds the parsed annotations for a single
+// volumeKeyPrefix+<name> entry.
+type volumeAnnotation struct {
+	name    string
+	share   string
+	typ     string
+	options string
+	source  string
+}
+
+// UpdateVolumeAnnotations updates the annotations and mounts of spec in
+// place to reflect the actual, on-disk nature of any emptyDir volumes it
+// references, and to translate any bind mounts that should really be tmpfs
+// mounts (most notably /dev/shm) into the mount type gVisor expects. It
+// reports whether spec was modified.
+//
+// Sandboxes and containers are handled differently: a sandbox's annotations
+// are resolved against the pod's volumes directory on the host, while a
+// container's mounts are synced against whatever the sandbox already
+// resolved (or, for /dev/shm, synced unconditionally).
+func UpdateVolumeAnnotations(spec *specs.Spec) (bool, error) {
+	isSandbox := spec.Annotations[ContainerTypeAnnotation] == containerTypeSandbox
+
+	updated := false
+	if isSandbox {
+		u, err := resolveVolumes(spec)
+		if err != nil {
+			return false, err
+		}
+		updated = u
+	}
+
+	if syncMounts(spec, isSandbox) {
+		updated = true
+	}
+	return updated, nil
+}
+
+// VolumeSourceResolver resolves the on-disk source of a single Kubernetes
+// volume and updates its volumeKeyPrefix+name annotations (".type",
+// ".share", ".options") to describe how it should be mounted. uid and name
+// identify the pod and volume to resolve, under kubeletPodsDir.
+type VolumeSourceResolver interface {
+	Resolve(spec *specs.Spec, uid, name string) (source string, err error)
+}
+
+var (
+	volumeSourcesMu sync.Mutex
+	volumeSources   = map[string]VolumeSourceResolver{
+		"emptyDir":  emptyDirResolver{},
+		"hostPath":  hostPathResolver{},
+		"configMap": podVolumeDirResolver{subdir: "kubernetes.io~configmap", readOnly: true},
+		"secret":    podVolumeDirResolver{subdir: "kubernetes.io~secret", readOnly: true},
+		"projected": podVolumeDirResolver{subdir: "kubernetes.io~projected", readOnly: true},
+		"csi":       csiResolver{},
+	}
+)
+
+// RegisterVolumeSource registers r as the resolver for volumes whose
+// volumeKeyPrefix+<name>+".kind" annotation is kind, replacing any existing
+// resolver for kind (including the built-in ones above). It's meant to be
+// called during an embedder's setup, before any specs are processed.
+func RegisterVolumeSource(kind string, r VolumeSourceResolver) {
+	volumeSourcesMu.Lock()
+	defer volumeSourcesMu.Unlock()
+	volumeSources[kind] = r
+}
+
+func lookupVolumeSource(kind string) (VolumeSourceResolver, bool) {
+	volumeSourcesMu.Lock()
+	defer volumeSourcesMu.Unlock()
+	r, ok := volumeSources[kind]
+	return r, ok
+}
+
+// resolveVolumes walks spec's volumeKeyPrefix annotations, resolving each
+// one that's missing a ".source" entry via the VolumeSourceResolver
+// registered for its ".kind" annotation (defaulting to "emptyDir" for
+// compatibility with specs that predate the .kind annotation).
+func resolveVolumes(spec *specs.Spec) (bool, error) {
+	logDir, ok := spec.Annotations[sandboxLogDirAnnotation]
+	if !ok {
+		// Nothing to resolve against; leave annotations as-is.
+		return false, nil
+	}
+	uid := podUID(logDir)
+
+	updated := false
+	for _, name := range volumeNames(spec) {
+		sourceKey := volumeKeyPrefix + name + ".source"
+		if _, ok := spec.Annotations[sourceKey]; ok {
+			// Already resolved.
+			continue
+		}
+
+		kind := spec.Annotations[volumeKeyPrefix+name+".kind"]
+		if kind == "" {
+			kind = "emptyDir"
+		}
+		resolver, ok := lookupVolumeSource(kind)
+		if !ok {
+			return false, fmt.Errorf("volume %q: no VolumeSourceResolver registered for kind %q", name, kind)
+		}
+
+		source, err := resolver.Resolve(spec, uid, name)
+		if err != nil {
+			return false, fmt.Errorf("resolving volume %q: %w", name, err)
+		}
+		spec.Annotations[sourceKey] = source
+		updated = true
+	}
+	return updated, nil
+}
+
+// volumeNames returns the distinct volume names referenced by spec's
+// volumeKeyPrefix annotations, discovered from either a ".type" or a
+// ".kind" suffix since a volume using a non-default VolumeSourceResolver
+// need not declare a ".type" up front.
+func volumeNames(spec *specs.Spec) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for key := range spec.Annotations {
+		rest, ok := strings.CutPrefix(key, volumeKeyPrefix)
+		if !ok {
+			continue
+		}
+		name, ok := strings.CutSuffix(rest, ".type")
+		if !ok {
+			name, ok = strings.CutSuffix(rest, ".kind")
+		}
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// emptyDirResolver resolves Kubernetes emptyDir volumes. An emptyDir that
+// turns out to be genuinely empty is declared tmpfs; one that already has
+// contents is declared a shared bind mount instead, since converting it to
+// tmpfs would silently hide those contents from the container.
+type emptyDirResolver struct{}
+
+func (emptyDirResolver) Resolve(spec *specs.Spec, uid, name string) (string, error) {
+	path := filepath.Join(kubeletPodsDir, uid, "volumes", emptyDirVolumesDir, name)
+	empty, err := isEmptyDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	mountOpts, forceMemory, sizeLimit, err := emptyDirMountOptions(spec, name)
+	if err != nil {
+		return "", err
+	}
+	if !empty && forceMemory {
+		// medium=Memory always wants tmpfs; make sure the existing
+		// contents actually fit under sizeLimit before we agree to treat
+		// the directory as such.
+		if sizeLimit >= 0 {
+			used, err := dirSize(path)
+			if err != nil {
+				return "", fmt.Errorf("sizing emptyDir volume %q: %w", name, err)
+			}
+			if used > sizeLimit {
+				return "", fmt.Errorf("emptyDir volume %q has medium=Memory but contains %d bytes, which exceeds its sizeLimit of %d bytes", name, used, sizeLimit)
+			}
+		}
+		empty = true
+	}
+
+	typeKey := volumeKeyPrefix + name + ".type"
+	shareKey := volumeKeyPrefix + name + ".share"
+	if empty {
+		spec.Annotations[typeKey] = "tmpfs"
+		if len(mountOpts) > 0 {
+			mergeVolumeOptions(spec, name, mountOpts)
+		}
+	} else {
+		spec.Annotations[typeKey] = "bind"
+		spec.Annotations[shareKey] = "shared"
+	}
+	return path, nil
+}
+
+// hostPathResolver resolves Kubernetes hostPath volumes, which bind-mount
+// an arbitrary path from the host into the pod. It applies the same
+// validation Podman's unified storage code applies to host directories:
+// the path must be absolute and must already exist.
+type hostPathResolver struct{}
+
+func (hostPathResolver) Resolve(spec *specs.Spec, uid, name string) (string, error) {
+	path := spec.Annotations[volumeKeyPrefix+name+".hostPath"]
+	if path == "" {
+		return "", fmt.Errorf("hostPath volume %q is missing its %q annotation", name, volumeKeyPrefix+name+".hostPath")
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("hostPath volume %q: path %q must be absolute", name, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("hostPath volume %q: %w", name, err)
+	}
+	spec.Annotations[volumeKeyPrefix+name+".type"] = "bind"
+	return resolved, nil
+}
+
+// podVolumeDirResolver resolves a Kubernetes volume kind that kubelet
+// materializes as a plain directory of files under the pod's volumes
+// directory, such as configMap, secret and projected volumes.
+type podVolumeDirResolver struct {
+	// subdir is the kubeletPodsDir/<uid>/volumes subdirectory the volume
+	// lives under, e.g. "kubernetes.io~configmap".
+	subdir string
+	// readOnly forces the volume's ".options" annotation to "ro", since
+	// Kubernetes always projects these volumes read-only.
+	readOnly bool
+}
+
+func (r podVolumeDirResolver) Resolve(spec *specs.Spec, uid, name string) (string, error) {
+	path := filepath.Join(kubeletPodsDir, uid, "volumes", r.subdir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	spec.Annotations[volumeKeyPrefix+name+".type"] = "bind"
+	if r.readOnly {
+		spec.Annotations[volumeKeyPrefix+name+".options"] = "ro"
+	}
+	return path, nil
+}
+
+// csiResolver resolves Kubernetes CSI volumes, delegating the actual mount
+// to whatever CSI driver kubelet invoked; gVisor just bind-mounts the
+// staging path kubelet already populated.
+type csiResolver struct{}
+
+func (csiResolver) Resolve(spec *specs.Spec, uid, name string) (string, error) {
+	path := filepath.Join(kubeletPodsDir, uid, "volumes", "kubernetes.io~csi", name, "mount")
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	spec.Annotations[volumeKeyPrefix+name+".type"] = "bind"
+	return path, nil
+}
+
+// emptyDirMountOptions derives the tmpfs mount options implied by the
+// volumeKeyPrefix+name+".medium", ".sizeLimit" and ".mode" annotations, if
+// any are set. It also reports whether medium=Memory was requested (which
+// forces tmpfs even for a non-empty emptyDir) and the parsed sizeLimit in
+// bytes (-1 if unset).
+func emptyDirMountOptions(spec *specs.Spec, name string) (opts []string, forceMemory bool, sizeLimit int64, err error) {
+	sizeLimit = -1
+	if sl, ok := spec.Annotations[volumeKeyPrefix+name+".sizeLimit"]; ok {
+		n, err := parseQuantity(sl)
+		if err != nil {
+			return nil, false, 0, fmt.Errorf("invalid sizeLimit %q for volume %q: %w", sl, name, err)
+		}
+		sizeLimit = n
+		opts = append(opts, fmt.Sprintf("size=%d", n))
+	}
+	if mode, ok := spec.Annotations[volumeKeyPrefix+name+".mode"]; ok {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, false, 0, fmt.Errorf("invalid mode %q for volume %q: %w", mode, name, err)
+		}
+		opts = append(opts, fmt.Sprintf("mode=%o", m))
+	}
+
+	medium := spec.Annotations[volumeKeyPrefix+name+".medium"]
+	switch {
+	case medium == "" || medium == "Memory":
+		forceMemory = medium == "Memory"
+	case medium == "HugePages":
+		opts = append(opts, "huge=always")
+	case strings.HasPrefix(medium, "HugePages-"):
+		// medium is e.g. "HugePages-2Mi"; tmpfs selects the huge page size
+		// itself via pagesize=, using the kernel's K/M/G suffixes rather
+		// than Kubernetes' binary Ki/Mi/Gi ones, so just drop the trailing
+		// "i" ("2Mi" -> "2M").
+		pageSize := strings.TrimSuffix(strings.TrimPrefix(medium, "HugePages-"), "i")
+		opts = append(opts, "huge=always", "pagesize="+pageSize)
+	default:
+		return nil, false, 0, fmt.Errorf("unsupported emptyDir medium %q for volume %q", medium, name)
+	}
+	return opts, forceMemory, sizeLimit, nil
+}
+
+// parseQuantity parses a Kubernetes-style quantity: a plain byte count, or
+// one suffixed with the binary Ki/Mi/Gi units Kubernetes uses for
+// emptyDir.sizeLimit.
+func parseQuantity(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"Ki", 1 << 10},
+		{"Mi", 1 << 20},
+		{"Gi", 1 << 30},
+	}
+	for _, u := range units {
+		if rest, ok := strings.CutSuffix(s, u.suffix); ok {
+			n, err := strconv.ParseInt(rest, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * u.mul, nil
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// dirSize returns the total size, in bytes, of the regular files under
+// path.
+func dirSize(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// mergeVolumeOptions appends extra to the existing volumeKeyPrefix+name
+// ".options" annotation, if any.
+func mergeVolumeOptions(spec *specs.Spec, name string, extra []string) {
+	key := volumeKeyPrefix + name + ".options"
+	if existing := spec.Annotations[key]; existing != "" {
+		spec.Annotations[key] = existing + "," + strings.Join(extra, ",")
+		return
+	}
+	spec.Annotations[key] = strings.Join(extra, ",")
+}
+
+// podUID extracts a pod UID from a sandbox's log directory path, which is
+// either "/var/log/pods/<namespace>_<name>_<uid>" or, for pods created
+// before this naming scheme existed, the legacy "/var/log/pods/<uid>".
+func podUID(logDir string) string {
+	base := filepath.Base(logDir)
+	if i := strings.LastIndex(base, "_"); i >= 0 {
+		return base[i+1:]
+	}
+	return base
+}
+
+// isEmptyDir reports whether path is a directory containing no entries. It
+// returns an error if path doesn't exist or can't be read.
+func isEmptyDir(path string) (bool, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+// syncMounts brings spec.Mounts in line with the volume annotations already
+// present on spec: a bind mount whose source matches a volume declared
+// tmpfs is converted to tmpfs, /dev/shm is always made tmpfs, and duplicate
+// mounts for the same destination are collapsed to the most informative
+// entry. It reports whether spec.Mounts was modified.
+func syncMounts(spec *specs.Spec, isSandbox bool) bool {
+	if len(spec.Mounts) == 0 {
+		return false
+	}
+
+	changed := false
+	kept := make([]specs.Mount, 0, len(spec.Mounts))
+	index := make(map[string]int, len(spec.Mounts))
+	for _, m := range spec.Mounts {
+		orig := m
+		convertMount(spec, &m, isSandbox)
+		if !mountEqual(orig, m) {
+			changed = true
+		}
+
+		if i, ok := index[m.Destination]; ok {
+			// Supersede the previously kept mount only if this one carries
+			// more information (a source) or the one we kept didn't have
+			// one either; this makes the old hard-coded /dev/shm dedup a
+			// special case of destination-based deduplication in general.
+			if m.Source != "" || kept[i].Source == "" {
+				if !mountEqual(kept[i], m) {
+					changed = true
+				}
+				kept[i] = m
+			} else {
+				changed = true
+			}
+			continue
+		}
+		index[m.Destination] = len(kept)
+		kept = append(kept, m)
+	}
+
+	spec.Mounts = kept
+	return changed
+}
+
+// convertMount rewrites m in place from a bind mount into the mount type
+// its matching volume annotation (or, for /dev/shm, hard-coded default)
+// calls for.
+func convertMount(spec *specs.Spec, m *specs.Mount, isSandbox bool) {
+	if m.Type != "bind" {
+		return
+	}
+
+	if m.Destination == "/dev/shm" {
+		if isSandbox {
+			ensureShmAnnotation(spec, *m)
+		}
+		m.Type = "tmpfs"
+		return
+	}
+
+	name := filepath.Base(m.Source)
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		return
+	}
+	ann, ok := lookupVolumeAnnotation(spec, name)
+	if !ok || ann.typ != "tmpfs" {
+		return
+	}
+	// Only honor the annotation's tmpfs verdict if the source directory is
+	// still actually empty; if it's since gained contents, leave the mount
+	// as a bind mount rather than silently hiding them.
+	empty, err := isEmptyDir(m.Source)
+	if err != nil || !empty {
+		return
+	}
+	m.Type = "tmpfs"
+	m.Options = splitOptions(ann.options)
+}
+
+// ensureShmAnnotation records a volumeKeyPrefix+devshmName annotation for an
+// implicit /dev/shm mount that wasn't given one explicitly, so that
+// downstream consumers of the annotations (e.g. checkpoint/restore) see it
+// like any other volume.
+func ensureShmAnnotation(spec *specs.Spec, m specs.Mount) {
+	typeKey := volumeKeyPrefix + devshmName + ".type"
+	if _, ok := spec.Annotations[typeKey]; ok {
+		return
+	}
+	spec.Annotations[volumeKeyPrefix+devshmName+".share"] = "pod"
+	spec.Annotations[typeKey] = "tmpfs"
+	spec.Annotations[volumeKeyPrefix+devshmName+".options"] = "rw"
+	spec.Annotations[volumeKeyPrefix+devshmName+".source"] = m.Source
+}
+
+// lookupVolumeAnnotation collects the volumeKeyPrefix+name+.* annotations
+// for name into a volumeAnnotation.
+func lookupVolumeAnnotation(spec *specs.Spec, name string) (volumeAnnotation, bool) {
+	typ, ok := spec.Annotations[volumeKeyPrefix+name+".type"]
+	if !ok {
+		return volumeAnnotation{}, false
+	}
+	return volumeAnnotation{
+		name:    name,
+		share:   spec.Annotations[volumeKeyPrefix+name+".share"],
+		typ:     typ,
+		options: spec.Annotations[volumeKeyPrefix+name+".options"],
+		source:  spec.Annotations[volumeKeyPrefix+name+".source"],
+	}, true
+}
+
+// splitOptions splits a comma-separated mount options annotation value into
+// the []string form specs.Mount expects. An empty value yields nil, rather
+// than a slice containing one empty string.
+func splitOptions(options string) []string {
+	if options == "" {
+		return nil
+	}
+	return strings.Split(options, ",")
+}
+
+// mountEqual reports whether a and b describe the same mount.
+func mountEqual(a, b specs.Mount) bool {
+	if a.Destination != b.Destination || a.Type != b.Type || a.Source != b.Source {
+		return false
+	}
+	if len(a.Options) != len(b.Options) {
+		return false
+	}
+	for i := range a.Options {
+		if a.Options[i] != b.Options[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseMountSpec parses a single Docker/Podman-style mount specification and
+// returns the equivalent specs.Mount along with the dev.gvisor.spec.mount
+// annotations that describe it. name is used as the volume's annotation
+// key.
+//
+// Three forms are accepted:
+//
+//   - "--mount" syntax: comma-separated key=value pairs, e.g.
+//     "type=tmpfs,destination=/x,tmpfs-size=64m,tmpfs-mode=1777,ro".
+//   - "--volume"/"-v" syntax: "src:dst[:opts]".
+//   - "--tmpfs" syntax: "dst[:opts]".
+//
+// The "--volume" and "--tmpfs" forms share one syntax, so a mount parsed
+// from either of them has Type "bind".
+//
+// "--volumes-from <ctr>[:opts]" is handled separately by
+// ParseVolumesFromSpec, since resolving it requires looking up another
+// container's mounts.
+func ParseMountSpec(name, spec string) (specs.Mount, map[string]string, error) {
+	var m specs.Mount
+	switch {
+	case strings.Contains(spec, "=") && !strings.HasPrefix(spec, "/"):
+		// "--mount" syntax always starts with a key; the other forms
+		// start with an absolute path when their options carry "=".
+		if err := parseMountFlagSpec(&m, spec); err != nil {
+			return specs.Mount{}, nil, fmt.Errorf("parsing mount spec %q: %w", spec, err)
+		}
+	default:
+		parseVolumeFlagSpec(&m, spec)
+	}
+	if m.Destination == "" {
+		return specs.Mount{}, nil, fmt.Errorf("mount spec %q is missing a destination", spec)
+	}
+	if m.Type == "" {
+		m.Type = "bind"
+	}
+
+	share := "container"
+	if m.Type == "tmpfs" {
+		share = "pod"
+	}
+	annotations := map[string]string{
+		volumeKeyPrefix + name + ".share":   share,
+		volumeKeyPrefix + name + ".type":    m.Type,
+		volumeKeyPrefix + name + ".options": strings.Join(m.Options, ","),
+	}
+	if m.Source != "" {
+		annotations[volumeKeyPrefix+name+".source"] = m.Source
+	}
+	return m, annotations, nil
+}
+
+// parseMountFlagSpec parses the "--mount type=...,destination=...,..." form
+// of a mount specification into m.
+func parseMountFlagSpec(m *specs.Mount, spec string) error {
+	for _, field := range strings.Split(spec, ",") {
+		key, value, hasValue := strings.Cut(field, "=")
+		switch {
+		case !hasValue:
+			// A bare flag, e.g. "ro" or "rw".
+			m.Options = append(m.Options, key)
+		case key == "type":
+			m.Type = value
+		case key == "source" || key == "src":
+			m.Source = value
+		case key == "destination" || key == "dst" || key == "target":
+			m.Destination = value
+		case key == "tmpfs-size":
+			m.Options = append(m.Options, "size="+value)
+		case key == "tmpfs-mode":
+			m.Options = append(m.Options, "mode="+value)
+		case key == "readonly":
+			if value == "true" {
+				m.Options = append(m.Options, "ro")
+			}
+		default:
+			m.Options = append(m.Options, key+"="+value)
+		}
+	}
+	return nil
+}
+
+// parseVolumeFlagSpec parses the "--volume src:dst[:opts]" and "--tmpfs
+// dst[:opts]" forms of a mount specification into m.
+func parseVolumeFlagSpec(m *specs.Mount, spec string) {
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+		m.Destination = parts[0]
+	case 2:
+		// "src:dst" has a path as its second part; "dst:opts" doesn't.
+		if strings.HasPrefix(parts[1], "/") {
+			m.Source = parts[0]
+			m.Destination = parts[1]
+		} else {
+			m.Destination = parts[0]
+			m.Options = strings.Split(parts[1], ",")
+		}
+	default:
+		m.Source = parts[0]
+		m.Destination = parts[1]
+		m.Options = strings.Split(parts[2], ",")
+	}
+}
+
+// ParseVolumesFromSpec parses a single "--volumes-from <ctr>[:opts]" entry,
+// returning the referenced container's ID and any mount options that should
+// be applied to each of its mounts when they're imported (e.g. "ro" to
+// import them all read-only). Resolving the container ID to actual mounts
+// is the caller's responsibility, since it requires access to the
+// container/sandbox store.
+func ParseVolumesFromSpec(spec string) (id string, options []string, err error) {
+	id, opts, ok := strings.Cut(spec, ":")
+	if id == "" {
+		return "", nil, fmt.Errorf("parsing volumes-from spec %q: missing container reference", spec)
+	}
+	if ok {
+		options = strings.Split(opts, ",")
+	}
+	return id, options, nil
+}
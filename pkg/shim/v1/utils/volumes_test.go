@@ -52,6 +52,31 @@ func TestUpdateVolumeAnnotations(t *testing.T) {
 		t.Fatalf("Create test volume: %v", err)
 	}
 
+	const (
+		testHostPathVolumeName  = "hostpathvolume"
+		testConfigMapVolumeName = "configmapvolume"
+		testSecretVolumeName    = "secretvolume"
+		testCSIVolumeName       = "csivolume"
+	)
+	testHostPath, err := os.MkdirTemp("", "test-hostpath-volume")
+	if err != nil {
+		t.Fatalf("create hostPath dir: %v", err)
+	}
+	defer os.RemoveAll(testHostPath)
+
+	testConfigMapPath := fmt.Sprintf("%s/%s/volumes/kubernetes.io~configmap/%s", dir, testPodUID, testConfigMapVolumeName)
+	if err := os.MkdirAll(testConfigMapPath, 0755); err != nil {
+		t.Fatalf("Create test configMap volume: %v", err)
+	}
+	testSecretPath := fmt.Sprintf("%s/%s/volumes/kubernetes.io~secret/%s", dir, testPodUID, testSecretVolumeName)
+	if err := os.MkdirAll(testSecretPath, 0755); err != nil {
+		t.Fatalf("Create test secret volume: %v", err)
+	}
+	testCSIPath := fmt.Sprintf("%s/%s/volumes/kubernetes.io~csi/%s/mount", dir, testPodUID, testCSIVolumeName)
+	if err := os.MkdirAll(testCSIPath, 0755); err != nil {
+		t.Fatalf("Create test CSI volume: %v", err)
+	}
+
 	for _, test := range []struct {
 		name      string
 		spec      *specs.Spec
@@ -231,6 +256,191 @@ func TestUpdateVolumeAnnotations(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "medium=Memory with sizeLimit and mode on an empty volume",
+			spec: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                         testLogDirPath,
+					ContainerTypeAnnotation:                         containerTypeSandbox,
+					volumeKeyPrefix + testVolumeName + ".share":     "pod",
+					volumeKeyPrefix + testVolumeName + ".type":      "tmpfs",
+					volumeKeyPrefix + testVolumeName + ".options":   "ro",
+					volumeKeyPrefix + testVolumeName + ".medium":    "Memory",
+					volumeKeyPrefix + testVolumeName + ".sizeLimit": "64Mi",
+					volumeKeyPrefix + testVolumeName + ".mode":      "1777",
+				},
+			},
+			expected: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                         testLogDirPath,
+					ContainerTypeAnnotation:                         containerTypeSandbox,
+					volumeKeyPrefix + testVolumeName + ".share":     "pod",
+					volumeKeyPrefix + testVolumeName + ".type":      "tmpfs",
+					volumeKeyPrefix + testVolumeName + ".medium":    "Memory",
+					volumeKeyPrefix + testVolumeName + ".sizeLimit": "64Mi",
+					volumeKeyPrefix + testVolumeName + ".mode":      "1777",
+					volumeKeyPrefix + testVolumeName + ".options":   "ro,size=67108864,mode=1777",
+					volumeKeyPrefix + testVolumeName + ".source":    testVolumePath,
+				},
+			},
+		},
+		{
+			name: "medium=Memory forces tmpfs for a non-empty volume that fits sizeLimit",
+			spec: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                                 testLogDirPath,
+					ContainerTypeAnnotation:                                 containerTypeSandbox,
+					volumeKeyPrefix + testNonEmptyVolumeName + ".share":     "pod",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".type":      "tmpfs",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".options":   "ro",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".medium":    "Memory",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".sizeLimit": "1Gi",
+				},
+			},
+			expected: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                                 testLogDirPath,
+					ContainerTypeAnnotation:                                 containerTypeSandbox,
+					volumeKeyPrefix + testNonEmptyVolumeName + ".share":     "pod",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".type":      "tmpfs",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".medium":    "Memory",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".sizeLimit": "1Gi",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".options":   "ro,size=1073741824",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".source":    testNonEmptyVolumePath,
+				},
+			},
+		},
+		{
+			name: "medium=Memory errors when the non-empty volume exceeds sizeLimit",
+			spec: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                                 testLogDirPath,
+					ContainerTypeAnnotation:                                 containerTypeSandbox,
+					volumeKeyPrefix + testNonEmptyVolumeName + ".share":     "pod",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".type":      "tmpfs",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".options":   "ro",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".medium":    "Memory",
+					volumeKeyPrefix + testNonEmptyVolumeName + ".sizeLimit": "1",
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "HugePages-2Mi medium",
+			spec: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                      testLogDirPath,
+					ContainerTypeAnnotation:                      containerTypeSandbox,
+					volumeKeyPrefix + testVolumeName + ".share":  "pod",
+					volumeKeyPrefix + testVolumeName + ".type":   "tmpfs",
+					volumeKeyPrefix + testVolumeName + ".medium": "HugePages-2Mi",
+				},
+			},
+			expected: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                       testLogDirPath,
+					ContainerTypeAnnotation:                       containerTypeSandbox,
+					volumeKeyPrefix + testVolumeName + ".share":   "pod",
+					volumeKeyPrefix + testVolumeName + ".type":    "tmpfs",
+					volumeKeyPrefix + testVolumeName + ".medium":  "HugePages-2Mi",
+					volumeKeyPrefix + testVolumeName + ".options": "huge=always,pagesize=2M",
+					volumeKeyPrefix + testVolumeName + ".source":  testVolumePath,
+				},
+			},
+		},
+		{
+			name: "hostPath volume",
+			spec: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                                testLogDirPath,
+					ContainerTypeAnnotation:                                containerTypeSandbox,
+					volumeKeyPrefix + testHostPathVolumeName + ".kind":     "hostPath",
+					volumeKeyPrefix + testHostPathVolumeName + ".hostPath": testHostPath,
+				},
+			},
+			expected: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                                testLogDirPath,
+					ContainerTypeAnnotation:                                containerTypeSandbox,
+					volumeKeyPrefix + testHostPathVolumeName + ".kind":     "hostPath",
+					volumeKeyPrefix + testHostPathVolumeName + ".hostPath": testHostPath,
+					volumeKeyPrefix + testHostPathVolumeName + ".type":     "bind",
+					volumeKeyPrefix + testHostPathVolumeName + ".source":   testHostPath,
+				},
+			},
+		},
+		{
+			name: "hostPath volume rejects a relative path",
+			spec: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                                testLogDirPath,
+					ContainerTypeAnnotation:                                containerTypeSandbox,
+					volumeKeyPrefix + testHostPathVolumeName + ".kind":     "hostPath",
+					volumeKeyPrefix + testHostPathVolumeName + ".hostPath": "relative/path",
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name: "configMap volume is forced read-only",
+			spec: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                                testLogDirPath,
+					ContainerTypeAnnotation:                                containerTypeSandbox,
+					volumeKeyPrefix + testConfigMapVolumeName + ".kind":    "configMap",
+					volumeKeyPrefix + testConfigMapVolumeName + ".options": "rw",
+				},
+			},
+			expected: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                                testLogDirPath,
+					ContainerTypeAnnotation:                                containerTypeSandbox,
+					volumeKeyPrefix + testConfigMapVolumeName + ".kind":    "configMap",
+					volumeKeyPrefix + testConfigMapVolumeName + ".type":    "bind",
+					volumeKeyPrefix + testConfigMapVolumeName + ".options": "ro",
+					volumeKeyPrefix + testConfigMapVolumeName + ".source":  testConfigMapPath,
+				},
+			},
+		},
+		{
+			name: "secret volume",
+			spec: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                          testLogDirPath,
+					ContainerTypeAnnotation:                          containerTypeSandbox,
+					volumeKeyPrefix + testSecretVolumeName + ".kind": "secret",
+				},
+			},
+			expected: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                             testLogDirPath,
+					ContainerTypeAnnotation:                             containerTypeSandbox,
+					volumeKeyPrefix + testSecretVolumeName + ".kind":    "secret",
+					volumeKeyPrefix + testSecretVolumeName + ".type":    "bind",
+					volumeKeyPrefix + testSecretVolumeName + ".options": "ro",
+					volumeKeyPrefix + testSecretVolumeName + ".source":  testSecretPath,
+				},
+			},
+		},
+		{
+			name: "CSI volume",
+			spec: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                       testLogDirPath,
+					ContainerTypeAnnotation:                       containerTypeSandbox,
+					volumeKeyPrefix + testCSIVolumeName + ".kind": "csi",
+				},
+			},
+			expected: &specs.Spec{
+				Annotations: map[string]string{
+					sandboxLogDirAnnotation:                         testLogDirPath,
+					ContainerTypeAnnotation:                         containerTypeSandbox,
+					volumeKeyPrefix + testCSIVolumeName + ".kind":   "csi",
+					volumeKeyPrefix + testCSIVolumeName + ".type":   "bind",
+					volumeKeyPrefix + testCSIVolumeName + ".source": testCSIPath,
+				},
+			},
+		},
 		{
 			name: "should not return error without pod log directory",
 			spec: &specs.Spec{
@@ -0,0 +1,284 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bridge provides a LinkEndpoint that aggregates several underlying
+// LinkEndpoints ("ports") into a single Layer 2 broadcast domain, the way a
+// host bridge(8) device aggregates several host interfaces. It maintains a
+// learning forwarding database (FDB) mapping source MAC addresses to the
+// port they were last seen on, floods frames whose destination is unknown,
+// broadcast, or multicast, and can optionally recognize STP BPDUs so a
+// further STP implementation can process them instead of having them
+// flooded like ordinary data frames.
+package bridge
+
+import (
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// fdbEntryTimeout is how long a learned MAC->port mapping is trusted
+// without being refreshed by another frame from that MAC, matching the
+// default aging time of a Linux bridge's FDB.
+const fdbEntryTimeout = 300 * time.Second
+
+// bpduDestination is the STP BPDU destination MAC, 01:80:C2:00:00:00.
+const bpduDestination = tcpip.LinkAddress("\x01\x80\xc2\x00\x00\x00")
+
+// Port is a single member interface of a bridge Endpoint.
+type Port struct {
+	// Endpoint is the underlying link this port forwards frames to and
+	// receives frames from.
+	Endpoint stack.LinkEndpoint
+
+	// Name identifies the port in FDB and STP diagnostics.
+	Name string
+
+	// Forwarding, when false, puts the port in STP "blocking" state: it's
+	// still learned from and still receives BPDUs, but data frames are
+	// never flooded or forwarded to it.
+	Forwarding bool
+}
+
+// fdbEntry is a single learned MAC->port mapping.
+type fdbEntry struct {
+	port     int
+	lastSeen time.Time
+}
+
+// Options configures a bridge Endpoint.
+type Options struct {
+	// MTU is the bridge's own reported MTU. It should not exceed the
+	// smallest MTU among its Ports.
+	MTU uint32
+
+	// Address is the bridge's own link address, used as the source address
+	// of traffic the local stack originates on the bridge NIC itself.
+	Address tcpip.LinkAddress
+
+	// HandleSTP, if true, delivers recognized BPDUs up to the attached
+	// NetworkDispatcher instead of flooding them to every port. It doesn't
+	// itself run the spanning tree algorithm.
+	HandleSTP bool
+}
+
+// Endpoint bridges a fixed set of Ports into a single stack.LinkEndpoint.
+type Endpoint struct {
+	ports     []*Port
+	mtu       uint32
+	address   tcpip.LinkAddress
+	handleSTP bool
+
+	mu  sync.Mutex
+	fdb map[tcpip.LinkAddress]fdbEntry
+
+	dispatcher stack.NetworkDispatcher
+}
+
+// portDispatcher adapts a single Port's inbound frames into the bridge's
+// learn-then-forward pipeline, tagging each delivered packet with the index
+// of the port it arrived on.
+type portDispatcher struct {
+	bridge *Endpoint
+	port   int
+}
+
+func (d *portDispatcher) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	d.bridge.handleFrame(d.port, protocol, pkt)
+}
+
+func (d *portDispatcher) DeliverLinkPacket(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	d.bridge.handleFrame(d.port, protocol, pkt)
+}
+
+// New returns a bridge Endpoint aggregating ports. It attaches itself to
+// each port's underlying Endpoint immediately, so ports must not already
+// have an attached dispatcher of their own.
+func New(ports []*Port, opts Options) *Endpoint {
+	e := &Endpoint{
+		ports:     ports,
+		mtu:       opts.MTU,
+		address:   opts.Address,
+		handleSTP: opts.HandleSTP,
+		fdb:       make(map[tcpip.LinkAddress]fdbEntry),
+	}
+	for i, p := range ports {
+		p.Endpoint.Attach(&portDispatcher{bridge: e, port: i})
+	}
+	return e
+}
+
+// handleFrame learns pkt's source MAC against the port it arrived on, then
+// either delivers it up to the bridge NIC (if addressed to the bridge
+// itself or recognized as a BPDU with HandleSTP set) or forwards/floods it
+// to the appropriate port(s), per the learned FDB.
+func (e *Endpoint) handleFrame(inPort int, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	eth := header.Ethernet(pkt.LinkHeader().Slice())
+	if len(eth) < header.EthernetMinimumSize {
+		return
+	}
+	src := eth.SourceAddress()
+	dst := eth.DestinationAddress()
+	if src != "" {
+		e.learn(src, inPort)
+	}
+
+	if e.handleSTP && dst == bpduDestination {
+		e.deliverLocally(protocol, pkt)
+		return
+	}
+	if dst == e.address {
+		e.deliverLocally(protocol, pkt)
+		return
+	}
+
+	if header.IsMulticastEthernetAddress(dst) || dst == tcpip.LinkAddress("\xff\xff\xff\xff\xff\xff") {
+		e.flood(inPort, protocol, pkt)
+		return
+	}
+
+	e.mu.Lock()
+	entry, ok := e.fdb[dst]
+	e.mu.Unlock()
+	if !ok || time.Since(entry.lastSeen) > fdbEntryTimeout {
+		// Unknown or stale destination: flood, same as an unmanaged switch
+		// would for a MAC it hasn't learned yet.
+		e.flood(inPort, protocol, pkt)
+		return
+	}
+	if entry.port == inPort {
+		// Would bounce the frame back out the port it came in on; drop it.
+		return
+	}
+	e.forwardTo(entry.port, protocol, pkt)
+}
+
+// learn records that mac was last seen arriving on port.
+func (e *Endpoint) learn(mac tcpip.LinkAddress, port int) {
+	e.mu.Lock()
+	e.fdb[mac] = fdbEntry{port: port, lastSeen: time.Now()}
+	e.mu.Unlock()
+}
+
+// deliverLocally hands pkt to whatever's attached to the bridge NIC itself,
+// mirroring how a host bridge's own "br0" device receives frames addressed
+// to it.
+func (e *Endpoint) deliverLocally(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	e.mu.Lock()
+	d := e.dispatcher
+	e.mu.Unlock()
+	if d != nil {
+		d.DeliverNetworkPacket(protocol, pkt)
+	}
+}
+
+// flood forwards pkt to every forwarding-enabled port other than inPort,
+// and delivers it locally as well since the bridge's own NIC is itself a
+// member of the broadcast domain.
+func (e *Endpoint) flood(inPort int, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	for i, p := range e.ports {
+		if i == inPort || !p.Forwarding {
+			continue
+		}
+		// Each port gets its own clone: every WritePackets call below can
+		// independently free or hold onto the packet it's given, and pkt
+		// itself is still needed for deliverLocally after this loop.
+		var pkts stack.PacketBufferList
+		pkts.PushBack(pkt.Clone())
+		p.Endpoint.WritePackets(pkts)
+	}
+	e.deliverLocally(protocol, pkt)
+}
+
+// forwardTo sends pkt out the single port the FDB named.
+func (e *Endpoint) forwardTo(port int, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	p := e.ports[port]
+	if !p.Forwarding {
+		return
+	}
+	var pkts stack.PacketBufferList
+	pkts.PushBack(pkt)
+	p.Endpoint.WritePackets(pkts)
+}
+
+// MTU implements stack.LinkEndpoint.
+func (e *Endpoint) MTU() uint32 { return e.mtu }
+
+// Capabilities implements stack.LinkEndpoint.
+func (e *Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return stack.CapabilityResolutionRequired
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.
+func (e *Endpoint) MaxHeaderLength() uint16 { return header.EthernetMinimumSize }
+
+// LinkAddress implements stack.LinkEndpoint.
+func (e *Endpoint) LinkAddress() tcpip.LinkAddress { return e.address }
+
+// WritePackets implements stack.LinkEndpoint by flooding locally-originated
+// traffic to every forwarding-enabled port, the same as any other frame
+// whose destination isn't yet in the FDB.
+func (e *Endpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	n := pkts.Len()
+	for _, p := range e.ports {
+		if !p.Forwarding {
+			continue
+		}
+		p.Endpoint.WritePackets(pkts)
+	}
+	return n, nil
+}
+
+// Attach implements stack.LinkEndpoint.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	e.dispatcher = dispatcher
+	e.mu.Unlock()
+}
+
+// IsAttached implements stack.LinkEndpoint.
+func (e *Endpoint) IsAttached() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dispatcher != nil
+}
+
+// Wait implements stack.LinkEndpoint. Ports own their own FDs/goroutines;
+// the bridge itself has nothing additional to wait on.
+func (e *Endpoint) Wait() {}
+
+// ARPHardwareType implements stack.LinkEndpoint.
+func (e *Endpoint) ARPHardwareType() header.ARPHardwareType { return header.ARPHardwareEther }
+
+// AddHeader implements stack.LinkEndpoint.
+func (e *Endpoint) AddHeader(pkt *stack.PacketBuffer) {}
+
+// ParseHeader implements stack.LinkEndpoint. Each port already parses its
+// own ethernet header before handing frames to the bridge, so there's
+// nothing left for the bridge endpoint itself to parse.
+func (e *Endpoint) ParseHeader(*stack.PacketBuffer) bool { return true }
+
+// Close detaches the bridge from every port's underlying endpoint.
+func (e *Endpoint) Close() {
+	for _, p := range e.ports {
+		p.Endpoint.Attach(nil)
+	}
+}
+
+// SetOnCloseAction implements stack.LinkEndpoint. The bridge has no
+// deferred cleanup of its own beyond detaching its ports, done in Close.
+func (e *Endpoint) SetOnCloseAction(func()) {}
@@ -0,0 +1,42 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dockerutil
+
+import "sync"
+
+// liveContainers tracks every Container created by MakeContainer that
+// hasn't yet been cleaned up, so the package can get at them from a context
+// a test function itself doesn't control (a signal handler; see
+// installSignalHandler in signal.go).
+var (
+	liveContainersMu sync.Mutex
+	liveContainers   = map[*Container]struct{}{}
+)
+
+// registerForCleanup records c as live. Every Container is registered
+// exactly once, right after creation.
+func registerForCleanup(c *Container) {
+	liveContainersMu.Lock()
+	defer liveContainersMu.Unlock()
+	liveContainers[c] = struct{}{}
+}
+
+// deregisterForCleanup removes c once CleanUp has run for it, so a later
+// signal doesn't try to remove it again.
+func deregisterForCleanup(c *Container) {
+	liveContainersMu.Lock()
+	defer liveContainersMu.Unlock()
+	delete(liveContainers, c)
+}
@@ -0,0 +1,486 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dockerutil drives docker containers from Go tests. It wraps the
+// docker client SDK with the conveniences the image and runtime test suites
+// need: unique container/network naming per test, the gVisor runtime wired
+// in by default, and log capture for exec'd processes.
+package dockerutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"gvisor.dev/gvisor/pkg/test/testutil"
+)
+
+// runtime is the docker runtime name used for containers that should run
+// under gVisor. Tests that need another runsc runtime configuration (e.g.
+// the outer dockerd-in-gvisor container) pass a suffix to
+// MakeContainerWithRuntime, which appends it to this name.
+const runtime = "runsc"
+
+// RunOpts describes how to start a container with Spawn or Run.
+type RunOpts struct {
+	// Image is the image name (e.g. "basic/alpine"); it is passed to docker
+	// unchanged.
+	Image string
+
+	// Env is a list of KEY=VALUE environment variables.
+	Env []string
+
+	// Links lists other containers (by MakeLink-produced alias) to link
+	// into this container's network namespace.
+	Links []string
+
+	// CapAdd lists Linux capabilities to add beyond the container's
+	// defaults.
+	CapAdd []string
+
+	// Mounts are additional mounts beyond those added by CopyFiles.
+	Mounts []mount.Mount
+
+	// Privileged starts the container with extended privileges.
+	Privileged bool
+
+	// ExposedPorts lists container-internal TCP ports to publish to a
+	// dynamically chosen host port. Use Container.MappedPort to find out
+	// which host port a given entry landed on after Spawn.
+	ExposedPorts []int
+}
+
+// ExecOpts describes how to run a command inside an already-running
+// container via Exec or ExecProcess.
+type ExecOpts struct {
+	// Privileged runs the exec'd process with extended privileges.
+	Privileged bool
+}
+
+// Container is a single docker container managed by a test. The zero value
+// is not valid; use MakeContainer or MakeContainerWithRuntime.
+type Container struct {
+	// Name uniquely identifies this container among concurrently running
+	// tests; it is derived from the test name plus a random suffix so
+	// parallel subtests never collide.
+	Name string
+
+	runtime string
+	t       *testing.T
+	client  *client.Client
+
+	mu    sync.Mutex
+	id    string
+	image string
+	ports map[int]int
+}
+
+// MakeContainer returns a new Container that runs under the gVisor runtime,
+// named after t. Callers must arrange for CleanUp to run, typically via
+// defer.
+func MakeContainer(ctx context.Context, t *testing.T) *Container {
+	return MakeContainerWithRuntime(ctx, t, "")
+}
+
+// MakeContainerWithRuntime is like MakeContainer, but appends suffix to the
+// runtime name (e.g. "-docker" for the outer dockerd-in-gvisor container in
+// image_test.go's docker-in-docker tests), so a test using more than one
+// runtime variant doesn't have to invent the naming scheme itself.
+func MakeContainerWithRuntime(ctx context.Context, t *testing.T, suffix string) *Container {
+	t.Helper()
+	installSignalHandler()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Fatalf("creating docker client: %v", err)
+	}
+	c := &Container{
+		Name:    testutil.RandomID(t.Name()),
+		runtime: runtime + suffix,
+		t:       t,
+		client:  cli,
+	}
+	registerForCleanup(c)
+	return c
+}
+
+// MakeLink returns the docker "--link alias" value other containers should
+// use to reach c under alias.
+func (c *Container) MakeLink(alias string) string {
+	return fmt.Sprintf("%s:%s", c.Name, alias)
+}
+
+// Spawn starts the container detached, running args (or the image's default
+// command if args is empty).
+func (c *Container) Spawn(ctx context.Context, opts RunOpts, args ...string) error {
+	start := time.Now()
+	id, err := c.create(ctx, opts, args)
+	if err != nil {
+		emit(Event{Event: "spawn", Test: c.t.Name(), Image: opts.Image, Runtime: c.runtime, Duration: time.Since(start), OK: false})
+		return err
+	}
+	c.mu.Lock()
+	c.id = id
+	c.mu.Unlock()
+	err = c.client.ContainerStart(ctx, id, container.StartOptions{})
+	if err == nil && len(opts.ExposedPorts) > 0 {
+		err = c.refreshPorts(ctx)
+	}
+	emit(Event{Event: "spawn", Test: c.t.Name(), Image: opts.Image, Runtime: c.runtime, Duration: time.Since(start), OK: err == nil})
+	return err
+}
+
+// Host returns the address published ports are reachable at from the test
+// process. Every published port is bound to the docker daemon's own
+// loopback address, regardless of which address the container itself
+// listens on internally.
+func (c *Container) Host() string {
+	return "127.0.0.1"
+}
+
+// MappedPort returns the host port containerPort (passed to Spawn via
+// RunOpts.ExposedPorts) was published to.
+func (c *Container) MappedPort(containerPort int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	port, ok := c.ports[containerPort]
+	if !ok {
+		return 0, fmt.Errorf("port %d was not published for container %q (add it to RunOpts.ExposedPorts)", containerPort, c.Name)
+	}
+	return port, nil
+}
+
+// refreshPorts reads back the host ports docker assigned to each of this
+// container's published container ports.
+func (c *Container) refreshPorts(ctx context.Context) error {
+	info, err := c.client.ContainerInspect(ctx, c.id)
+	if err != nil {
+		return fmt.Errorf("inspecting container %q for port mappings: %w", c.Name, err)
+	}
+	ports := make(map[int]int, len(info.NetworkSettings.Ports))
+	for containerPort, bindings := range info.NetworkSettings.Ports {
+		if len(bindings) == 0 {
+			continue
+		}
+		hostPort, err := strconv.Atoi(bindings[0].HostPort)
+		if err != nil {
+			return fmt.Errorf("parsing published host port %q for container %q: %w", bindings[0].HostPort, c.Name, err)
+		}
+		ports[containerPort.Int()] = hostPort
+	}
+	c.mu.Lock()
+	c.ports = ports
+	c.mu.Unlock()
+	return nil
+}
+
+// HealthStatus returns the container's docker-reported health status, as
+// set by its image's HEALTHCHECK instruction.
+func (c *Container) HealthStatus(ctx context.Context) (string, error) {
+	info, err := c.client.ContainerInspect(ctx, c.id)
+	if err != nil {
+		return "", fmt.Errorf("inspecting container %q for health status: %w", c.Name, err)
+	}
+	if info.State == nil || info.State.Health == nil {
+		return "", fmt.Errorf("container %q has no HEALTHCHECK configured", c.Name)
+	}
+	return info.State.Health.Status, nil
+}
+
+// Restart stops and restarts the container in place, keeping its ID, name,
+// and mounts. Callers that published ports with RunOpts.ExposedPorts should
+// re-read MappedPort afterwards, since docker is free to rebind them.
+func (c *Container) Restart(ctx context.Context) error {
+	if err := c.client.ContainerRestart(ctx, c.id, container.StopOptions{}); err != nil {
+		return fmt.Errorf("restarting container %q: %w", c.Name, err)
+	}
+	if len(c.ports) > 0 {
+		if err := c.refreshPorts(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run starts the container, waits for it to exit, and returns its combined
+// stdout/stderr.
+func (c *Container) Run(ctx context.Context, opts RunOpts, args ...string) (string, error) {
+	if err := c.Spawn(ctx, opts, args...); err != nil {
+		return "", err
+	}
+	statusCh, errCh := c.client.ContainerWait(ctx, c.id, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return "", fmt.Errorf("waiting for container %q: %w", c.Name, err)
+		}
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			logs, _ := c.logs(ctx, c.id)
+			return logs, fmt.Errorf("container %q exited with status %d", c.Name, status.StatusCode)
+		}
+	}
+	return c.logs(ctx, c.id)
+}
+
+// CopyFiles arranges for the given host-relative source files to appear
+// under target inside the container once it is spawned, by adding a bind
+// mount to opts for each one.
+func (c *Container) CopyFiles(opts *RunOpts, target string, sources ...string) {
+	for _, src := range sources {
+		opts.Mounts = append(opts.Mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   runfilePath(src),
+			Target:   target + "/" + filepath.Base(src),
+			ReadOnly: true,
+		})
+	}
+	emit(Event{Event: "copy_files", Test: c.t.Name(), Image: opts.Image, Runtime: c.runtime, OK: true})
+}
+
+// runfilePath resolves a path given relative to the repository root (as
+// image_test.go's callers pass, e.g. "test/image/latin10k.txt") to an
+// absolute path under the test binary's runfiles directory.
+func runfilePath(rel string) string {
+	if root := os.Getenv("RUNFILES_DIR"); root != "" {
+		return filepath.Join(root, "__main__", rel)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return rel
+	}
+	return filepath.Join(wd, rel)
+}
+
+// FindIP returns the container's IP address. If hostNetwork is true, it
+// returns the loopback address instead of inspecting the bridge network,
+// since a host-networked container shares the host's address.
+func (c *Container) FindIP(ctx context.Context, hostNetwork bool) (net.IP, error) {
+	if hostNetwork {
+		return net.ParseIP("127.0.0.1"), nil
+	}
+	info, err := c.client.ContainerInspect(ctx, c.id)
+	if err != nil {
+		return nil, fmt.Errorf("inspecting container %q: %w", c.Name, err)
+	}
+	for _, net := range info.NetworkSettings.Networks {
+		if ip := net.IPAddress; ip != "" {
+			return parseIP(ip)
+		}
+	}
+	return nil, fmt.Errorf("container %q has no network address", c.Name)
+}
+
+// Exec runs args inside the already-running container and returns its
+// combined stdout/stderr.
+func (c *Container) Exec(ctx context.Context, opts ExecOpts, args ...string) (string, error) {
+	start := time.Now()
+	p, err := c.ExecProcess(ctx, opts, args...)
+	if err != nil {
+		emit(Event{Event: "exec", Test: c.t.Name(), Image: c.image, Runtime: c.runtime, Duration: time.Since(start), OK: false})
+		return "", err
+	}
+	output, err := p.Logs()
+	ev := Event{Event: "exec", Test: c.t.Name(), Image: c.image, Runtime: c.runtime, Duration: time.Since(start), OK: err == nil}
+	if err == nil {
+		if code, cerr := p.ExitCode(ctx); cerr == nil {
+			ev.ExitStatus = &code
+			ev.OK = code == 0
+		}
+	}
+	if !ev.OK {
+		ev.OutputTail = outputTail(output, outputTailBytes)
+	}
+	emit(ev)
+	return output, err
+}
+
+// Process is a command started inside a container via ExecProcess.
+type Process struct {
+	c      *Container
+	execID string
+}
+
+// Logs blocks until the exec'd process completes and returns its combined
+// stdout/stderr.
+func (p *Process) Logs() (string, error) {
+	ctx := context.Background()
+	resp, err := p.c.client.ContainerExecAttach(ctx, p.execID, container.ExecAttachOptions{})
+	if err != nil {
+		return "", fmt.Errorf("attaching to exec %q: %w", p.execID, err)
+	}
+	defer resp.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(resp.Reader); err != nil {
+		return "", fmt.Errorf("reading exec %q output: %w", p.execID, err)
+	}
+	return buf.String(), nil
+}
+
+// ExitCode blocks until the exec'd process completes and returns its exit
+// code.
+func (p *Process) ExitCode(ctx context.Context) (int, error) {
+	info, err := p.c.client.ContainerExecInspect(ctx, p.execID)
+	if err != nil {
+		return 0, fmt.Errorf("inspecting exec %q: %w", p.execID, err)
+	}
+	return info.ExitCode, nil
+}
+
+// ExecProcess starts args inside the already-running container without
+// waiting for it to complete.
+func (c *Container) ExecProcess(ctx context.Context, opts ExecOpts, args ...string) (*Process, error) {
+	resp, err := c.client.ContainerExecCreate(ctx, c.id, container.ExecOptions{
+		Cmd:          args,
+		Privileged:   opts.Privileged,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating exec in container %q: %w", c.Name, err)
+	}
+	if err := c.client.ContainerExecStart(ctx, resp.ID, container.ExecStartOptions{}); err != nil {
+		return nil, fmt.Errorf("starting exec in container %q: %w", c.Name, err)
+	}
+	return &Process{c: c, execID: resp.ID}, nil
+}
+
+// CleanUp removes the container, if it was ever created. A removal error
+// is logged, not reported as a test failure.
+func (c *Container) CleanUp(ctx context.Context) {
+	c.mu.Lock()
+	id := c.id
+	c.mu.Unlock()
+	if id == "" {
+		deregisterForCleanup(c)
+		return
+	}
+	start := time.Now()
+	err := c.client.ContainerRemove(ctx, id, container.RemoveOptions{Force: true})
+	if err != nil {
+		c.t.Logf("cleaning up container %q: %v", c.Name, err)
+	}
+	emit(Event{Event: "cleanup", Test: c.t.Name(), Image: c.image, Runtime: c.runtime, Duration: time.Since(start), OK: err == nil})
+	deregisterForCleanup(c)
+}
+
+func (c *Container) create(ctx context.Context, opts RunOpts, args []string) (string, error) {
+	start := time.Now()
+	exposedPorts, portBindings, err := exposedPortConfig(opts.ExposedPorts)
+	if err != nil {
+		return "", err
+	}
+	cfg := &container.Config{
+		Image:        opts.Image,
+		Cmd:          args,
+		Env:          opts.Env,
+		ExposedPorts: exposedPorts,
+		Labels:       map[string]string{runLabelKey: RunLabel()},
+	}
+	hostCfg := &container.HostConfig{
+		Runtime:      c.runtime,
+		CapAdd:       opts.CapAdd,
+		Privileged:   opts.Privileged,
+		Mounts:       opts.Mounts,
+		Links:        opts.Links,
+		PortBindings: portBindings,
+	}
+	resp, err := c.client.ContainerCreate(ctx, cfg, hostCfg, nil, nil, c.Name)
+	emit(Event{Event: "container_create", Test: c.t.Name(), Image: opts.Image, Runtime: c.runtime, Duration: time.Since(start), OK: err == nil})
+	if err != nil {
+		return "", fmt.Errorf("creating container %q: %w", c.Name, err)
+	}
+	c.mu.Lock()
+	c.image = opts.Image
+	c.mu.Unlock()
+	return resp.ID, nil
+}
+
+// exposedPortConfig turns a list of container-internal TCP ports into the
+// nat.PortSet/nat.PortMap pair docker's container create API expects, with
+// each port bound to a host port docker chooses dynamically (HostPort ""),
+// so concurrently running tests can never collide on a fixed port number.
+func exposedPortConfig(ports []int) (nat.PortSet, nat.PortMap, error) {
+	if len(ports) == 0 {
+		return nil, nil, nil
+	}
+	exposed := make(nat.PortSet, len(ports))
+	bindings := make(nat.PortMap, len(ports))
+	for _, p := range ports {
+		port, err := nat.NewPort("tcp", strconv.Itoa(p))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid exposed port %d: %w", p, err)
+		}
+		exposed[port] = struct{}{}
+		bindings[port] = []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: ""}}
+	}
+	return exposed, bindings, nil
+}
+
+// Logs returns the container's captured stdout/stderr so far, satisfying
+// wait.Target for callers that drive readiness checks via
+// dockerutil.Request.
+func (c *Container) Logs() (string, error) {
+	return c.logs(context.Background(), c.id)
+}
+
+func (c *Container) logs(ctx context.Context, id string) (string, error) {
+	rc, err := c.client.ContainerLogs(ctx, id, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return "", fmt.Errorf("reading logs for container %q: %w", c.Name, err)
+	}
+	defer rc.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(rc); err != nil {
+		return "", fmt.Errorf("reading logs for container %q: %w", c.Name, err)
+	}
+	return buf.String(), nil
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	return ip, nil
+}
+
+// EnsureSupportedDockerVersion checks that a docker daemon is reachable
+// from this process, and panics if it is not. It does not compare the
+// daemon's version against any minimum. TestMain calls this once before
+// running any tests, so an unreachable daemon produces one clear failure
+// instead of dozens of confusing per-test ones.
+func EnsureSupportedDockerVersion() {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		fmt.Println("unable to connect to docker:", err)
+		panic(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := cli.ServerVersion(ctx); err != nil {
+		fmt.Println("unable to reach docker daemon:", err)
+		panic(err)
+	}
+}
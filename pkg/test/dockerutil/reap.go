@@ -0,0 +1,88 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dockerutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// runLabelKey labels every container this package creates with the test run
+// that created it, so a later process can tell its own containers apart
+// from ones left behind by a crashed earlier run.
+const runLabelKey = "gvisor.dev/test-run"
+
+// runLabel identifies this process's test run. It's derived once, when the
+// package is initialized, from the PID and the current time, which is unique
+// enough to tell this run apart from any other that could plausibly still have live containers.
+var runLabel = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+
+// RunLabel returns the label this process's containers are tagged with.
+// TestMain implementations that want to persist it across a restart (e.g.
+// to pass to a later ReapOrphans call) can log or record the value this
+// returns.
+func RunLabel() string {
+	return runLabel
+}
+
+// ReapOrphans deletes every container and network tagged with runLabelKey
+// that doesn't belong to the current run (identified by currentRunLabel,
+// normally RunLabel()). Call it once from TestMain before any tests run, so
+// containers and networks orphaned by a prior invocation that was killed
+// before its signal handler (see signal.go) could clean up don't break this
+// run too.
+func ReapOrphans(ctx context.Context, currentRunLabel string) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("creating docker client: %w", err)
+	}
+	defer cli.Close()
+
+	labelFilter := filters.NewArgs(filters.Arg("label", runLabelKey))
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true, Filters: labelFilter})
+	if err != nil {
+		return fmt.Errorf("listing containers to reap: %w", err)
+	}
+	for _, c := range containers {
+		if c.Labels[runLabelKey] == currentRunLabel {
+			continue
+		}
+		if err := cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true}); err != nil {
+			return fmt.Errorf("removing orphaned container %q from run %q: %w", c.ID, c.Labels[runLabelKey], err)
+		}
+	}
+
+	networks, err := cli.NetworkList(ctx, network.ListOptions{Filters: labelFilter})
+	if err != nil {
+		return fmt.Errorf("listing networks to reap: %w", err)
+	}
+	for _, n := range networks {
+		if n.Labels[runLabelKey] == currentRunLabel {
+			continue
+		}
+		if err := cli.NetworkRemove(ctx, n.ID); err != nil {
+			return fmt.Errorf("removing orphaned network %q from run %q: %w", n.ID, n.Labels[runLabelKey], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dockerutil
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"sync"
+	"time"
+)
+
+// reportPath names the file the event reporter appends to. If unset, the
+// IMAGE_TEST_REPORT environment variable is checked instead; if neither is
+// set, no report is produced and emit is a no-op.
+var reportPath = flag.String("image.report", "", "if set, write one JSON line per container lifecycle event to this path (also settable via the IMAGE_TEST_REPORT environment variable)")
+
+// Event is a single container lifecycle event, as appended to the report
+// enabled by -image.report/IMAGE_TEST_REPORT. Recording these lets external
+// tooling slice flake rates per image or per runtime variant (VFS vs
+// overlay, hostinet vs netstack) in a way the existing sequential t.Errorf
+// logs can't.
+type Event struct {
+	// Event names the lifecycle point this record describes: one of
+	// container_create, spawn, exec, wait_for_output_hit, wait_for_http_ok,
+	// copy_files, cleanup, or wait_ready_<name> for a wait.Strategy other
+	// than "log" and "http".
+	Event string `json:"event"`
+	// Test is the name of the test that triggered this event.
+	Test string `json:"test"`
+	// Image is the docker image the container runs, if known at this point.
+	Image string `json:"image,omitempty"`
+	// Runtime is the docker runtime the container runs under (e.g. "runsc").
+	Runtime string `json:"runtime,omitempty"`
+	// Duration is how long the underlying operation took.
+	Duration time.Duration `json:"duration_ns"`
+	// OK is false if the underlying operation failed or, for exec events,
+	// exited non-zero.
+	OK bool `json:"ok"`
+	// ExitStatus is the exec'd process's exit code. Only set for exec
+	// events that ran to completion.
+	ExitStatus *int `json:"exit_status,omitempty"`
+	// OutputTail is the tail of the operation's captured stdout/stderr,
+	// populated only when OK is false.
+	OutputTail string `json:"output_tail,omitempty"`
+}
+
+// outputTailBytes bounds how much of a failing operation's output a single
+// Event carries, so one noisy command doesn't blow up the report.
+const outputTailBytes = 4096
+
+// outputTail returns the last n bytes of s.
+func outputTail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+// eventReporter appends Events to a single opened file as newline-delimited
+// JSON. All of its methods are safe for concurrent use, since tests in this
+// package commonly run in parallel.
+type eventReporter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+var (
+	reporterOnce sync.Once
+	reporter     *eventReporter
+)
+
+// getReporter returns the process-wide reporter, opening its output file on
+// first use. It returns nil if no report was requested (-image.report and
+// IMAGE_TEST_REPORT both unset, or the file couldn't be opened), so emit
+// can skip straight past it without synchronizing on reporterOnce again.
+func getReporter() *eventReporter {
+	reporterOnce.Do(func() {
+		path := *reportPath
+		if path == "" {
+			path = os.Getenv("IMAGE_TEST_REPORT")
+		}
+		if path == "" {
+			return
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return
+		}
+		reporter = &eventReporter{f: f}
+	})
+	return reporter
+}
+
+// emit appends ev to the report, if one was requested. A report that can't
+// be marshaled or written to is silently dropped: a broken report must
+// never fail the test that triggered it.
+func emit(ev Event) {
+	r := getReporter()
+	if r == nil {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.f.Write(data)
+}
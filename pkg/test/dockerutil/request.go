@@ -0,0 +1,177 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dockerutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+	"gvisor.dev/gvisor/pkg/test/dockerutil/wait"
+)
+
+// Request is a fluent builder for starting a Container and waiting for it
+// to become ready, collapsing the MakeContainer -> Spawn -> FindIP ->
+// WaitForHTTP -> poll pattern most image tests otherwise repeat by hand.
+//
+//	d, err := dockerutil.NewRequest().
+//		WithImage("basic/mysql").
+//		WithExposedPorts(3306).
+//		WithWaitStrategy(wait.ForLog("mysqld: ready for connections")).
+//		Start(ctx, t)
+type Request struct {
+	opts       RunOpts
+	args       []string
+	strategies []wait.Strategy
+	timeout    time.Duration
+}
+
+// NewRequest returns an empty Request. At minimum, callers must set an
+// image via WithImage before calling Start.
+func NewRequest() *Request {
+	return &Request{timeout: defaultWaitTimeout}
+}
+
+// defaultWaitTimeout bounds how long Start waits for every attached
+// Strategy to report readiness before giving up.
+const defaultWaitTimeout = time.Minute
+
+// WithImage sets the image to start.
+func (r *Request) WithImage(image string) *Request {
+	r.opts.Image = image
+	return r
+}
+
+// WithEnv appends KEY=VALUE environment variables.
+func (r *Request) WithEnv(env ...string) *Request {
+	r.opts.Env = append(r.opts.Env, env...)
+	return r
+}
+
+// WithExposedPorts publishes the given container-internal TCP ports to
+// dynamically chosen host ports, retrievable after Start via
+// StartedContainer.MappedPort.
+func (r *Request) WithExposedPorts(ports ...int) *Request {
+	r.opts.ExposedPorts = append(r.opts.ExposedPorts, ports...)
+	return r
+}
+
+// WithMounts appends additional mounts beyond WithExposedPorts/WithEnv.
+func (r *Request) WithMounts(mounts ...mount.Mount) *Request {
+	r.opts.Mounts = append(r.opts.Mounts, mounts...)
+	return r
+}
+
+// WithCmd sets the command run inside the container, overriding the
+// image's default entrypoint/command.
+func (r *Request) WithCmd(args ...string) *Request {
+	r.args = args
+	return r
+}
+
+// WithWaitStrategy adds a readiness check Start must satisfy before
+// returning. Multiple strategies all must succeed; they're checked in the
+// order added.
+func (r *Request) WithWaitStrategy(s wait.Strategy) *Request {
+	r.strategies = append(r.strategies, s)
+	return r
+}
+
+// WithStartTimeout overrides how long Start waits for readiness. Default
+// one minute.
+func (r *Request) WithStartTimeout(d time.Duration) *Request {
+	r.timeout = d
+	return r
+}
+
+// StartedContainer is a Container that has been spawned and adapts it to
+// wait.Target, so the same type driving a test can be handed directly to a
+// Strategy.
+type StartedContainer struct {
+	*Container
+}
+
+var _ wait.Target = (*StartedContainer)(nil)
+
+// Exec implements wait.Target by running cmd to completion and reporting
+// its exit code alongside its output, unlike the base Container.Exec
+// (kept signature-compatible with existing callers), which only returns
+// output.
+func (s *StartedContainer) Exec(ctx context.Context, cmd ...string) (int, string, error) {
+	p, err := s.Container.ExecProcess(ctx, ExecOpts{}, cmd...)
+	if err != nil {
+		return 0, "", err
+	}
+	output, err := p.Logs()
+	if err != nil {
+		return 0, "", err
+	}
+	code, err := p.ExitCode(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	return code, output, nil
+}
+
+// Start spawns the container the Request describes and blocks until every
+// attached wait.Strategy reports readiness, or WithStartTimeout elapses.
+// Callers must arrange for the returned Container's CleanUp to run, the
+// same as with MakeContainer.
+func (r *Request) Start(ctx context.Context, t *testing.T) (*StartedContainer, error) {
+	t.Helper()
+	if r.opts.Image == "" {
+		return nil, fmt.Errorf("dockerutil.Request: WithImage must be set before Start")
+	}
+	c := MakeContainer(ctx, t)
+	if err := c.Spawn(ctx, r.opts, r.args...); err != nil {
+		return nil, fmt.Errorf("spawning container for image %q: %w", r.opts.Image, err)
+	}
+	sc := &StartedContainer{Container: c}
+
+	waitCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	for _, strategy := range r.strategies {
+		waitStart := time.Now()
+		err := strategy.WaitUntilReady(waitCtx, sc)
+		emit(Event{
+			Event:    waitEventName(strategy.Name()),
+			Test:     t.Name(),
+			Image:    r.opts.Image,
+			Runtime:  c.runtime,
+			Duration: time.Since(waitStart),
+			OK:       err == nil,
+		})
+		if err != nil {
+			return sc, fmt.Errorf("container %q for image %q never became ready: %w", c.Name, r.opts.Image, err)
+		}
+	}
+	return sc, nil
+}
+
+// waitEventName maps a wait.Strategy's Name() to the report event name it
+// should be recorded under. Strategies without a dedicated event name (port,
+// exec, healthcheck) fall back to a generic "wait_ready_<name>".
+func waitEventName(strategyName string) string {
+	switch strategyName {
+	case "log":
+		return "wait_for_output_hit"
+	case "http":
+		return "wait_for_http_ok"
+	default:
+		return "wait_ready_" + strategyName
+	}
+}
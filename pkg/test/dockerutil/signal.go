@@ -0,0 +1,87 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dockerutil
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// installSignalHandlerOnce guards installSignalHandler, which arranges for
+// every live Container to be cleaned up on SIGINT or SIGTERM (and SIGQUIT
+// when DEBUG is set), so a Ctrl-C or CI timeout doesn't leave orphan
+// containers behind. A SIGKILL (e.g. an OOM kill) can't be caught, so its
+// containers are left for ReapOrphans. installSignalHandler is called from
+// MakeContainerWithRuntime, so the handler is installed as soon as the first
+// Container is created and never torn down.
+var installSignalHandlerOnce sync.Once
+
+// interruptCount counts signals handled by the installed handler. The third
+// interrupt skips cleanup entirely: a test run that doesn't die after two
+// attempts at a graceful cleanup is assumed to be stuck in it.
+var interruptCount int32
+
+func installSignalHandler() {
+	installSignalHandlerOnce.Do(func() {
+		sigs := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+		if os.Getenv("DEBUG") != "" {
+			sigs = append(sigs, syscall.SIGQUIT)
+		}
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, sigs...)
+		go func() {
+			for sig := range ch {
+				handleInterrupt(sig)
+			}
+		}()
+	})
+}
+
+// handleInterrupt cleans up every registered Container and then re-raises
+// sig with the default disposition restored, so the process still dies (or
+// dumps core, for SIGQUIT) the way it would have without this handler.
+func handleInterrupt(sig os.Signal) {
+	if atomic.AddInt32(&interruptCount, 1) >= 3 {
+		os.Exit(1)
+	}
+	cleanUpLiveContainers()
+	signal.Reset(sig)
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(sig)
+	}
+}
+
+// cleanUpLiveContainers calls CleanUp on every Container registered via
+// registerForCleanup that hasn't deregistered yet. It snapshots
+// liveContainers before calling out, since CleanUp itself calls
+// deregisterForCleanup and would otherwise deadlock or mutate the map while
+// it's being ranged over.
+func cleanUpLiveContainers() {
+	liveContainersMu.Lock()
+	containers := make([]*Container, 0, len(liveContainers))
+	for c := range liveContainers {
+		containers = append(containers, c)
+	}
+	liveContainersMu.Unlock()
+
+	ctx := context.Background()
+	for _, c := range containers {
+		c.CleanUp(ctx)
+	}
+}
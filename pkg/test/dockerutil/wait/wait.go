@@ -0,0 +1,276 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wait provides pluggable readiness checks for dockerutil's fluent
+// Request builder, modeled on testcontainers-go's wait package: a container
+// is "ready" once every attached Strategy's WaitUntilReady returns nil.
+package wait
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Target is the subset of dockerutil.Container a Strategy needs to probe
+// readiness. It's defined here, rather than importing dockerutil.Container
+// directly, so dockerutil can import wait without a cycle.
+type Target interface {
+	// Host returns the address the target is reachable at from the test
+	// process (e.g. "127.0.0.1" or the container's own IP, depending on
+	// how it was started).
+	Host() string
+
+	// MappedPort translates a port the container listens on internally to
+	// the port it's reachable at from Host().
+	MappedPort(containerPort int) (int, error)
+
+	// Logs returns the container's captured stdout/stderr so far.
+	Logs() (string, error)
+
+	// Exec runs cmd inside the container and returns its exit code and
+	// combined output.
+	Exec(ctx context.Context, cmd ...string) (exitCode int, output string, err error)
+
+	// HealthStatus returns the container's docker-reported health status
+	// ("starting", "healthy", "unhealthy"), as set by the image's
+	// HEALTHCHECK instruction.
+	HealthStatus(ctx context.Context) (string, error)
+}
+
+// Strategy is a pluggable readiness check. WaitUntilReady should keep
+// probing target until it's ready, an unrecoverable error occurs, or ctx is
+// done, whichever comes first.
+type Strategy interface {
+	WaitUntilReady(ctx context.Context, target Target) error
+
+	// Name identifies the kind of check this Strategy performs (e.g. "log",
+	// "http"), for callers that want to report on which strategies ran
+	// without depending on their concrete, unexported types.
+	Name() string
+}
+
+// pollInterval is how often a Strategy re-probes a not-yet-ready target.
+// It's a var, rather than a const, purely so tests can shrink it.
+var pollInterval = 100 * time.Millisecond
+
+// poll calls probe every pollInterval until it returns true, a non-nil
+// error, or ctx is done. It centralizes the retry loop every built-in
+// Strategy below otherwise duplicates.
+func poll(ctx context.Context, probe func() (bool, error)) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		ready, err := probe()
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for readiness: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// logStrategy waits for a container's combined stdout/stderr to match a
+// regular expression.
+type logStrategy struct {
+	re *regexp.Regexp
+}
+
+// ForLog returns a Strategy that's satisfied once the target's logs match
+// pattern.
+func ForLog(pattern string) *logStrategy {
+	return &logStrategy{re: regexp.MustCompile(pattern)}
+}
+
+func (s *logStrategy) WaitUntilReady(ctx context.Context, target Target) error {
+	return poll(ctx, func() (bool, error) {
+		logs, err := target.Logs()
+		if err != nil {
+			return false, nil // Logs aren't available yet; keep polling.
+		}
+		return s.re.MatchString(logs), nil
+	})
+}
+
+// Name identifies this Strategy as "log".
+func (s *logStrategy) Name() string { return "log" }
+
+// httpStrategy waits for an HTTP probe to return an expected status code.
+type httpStrategy struct {
+	port       int
+	path       string
+	method     string
+	statusCode int
+	tls        bool
+	client     *http.Client
+}
+
+// ForHTTP returns a Strategy that probes path on containerPort over plain
+// HTTP, by default expecting a 200 response to a GET request. Use
+// WithStatusCode, WithMethod, and WithTLS to customize the probe.
+func ForHTTP(containerPort int, path string) *httpStrategy {
+	return &httpStrategy{
+		port:       containerPort,
+		path:       path,
+		method:     http.MethodGet,
+		statusCode: http.StatusOK,
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// WithStatusCode sets the status code considered "ready". Default 200.
+func (s *httpStrategy) WithStatusCode(code int) *httpStrategy {
+	s.statusCode = code
+	return s
+}
+
+// WithMethod sets the HTTP method used to probe. Default GET.
+func (s *httpStrategy) WithMethod(method string) *httpStrategy {
+	s.method = method
+	return s
+}
+
+// WithTLS probes https:// instead of http://, skipping certificate
+// verification since test containers typically serve a self-signed cert.
+func (s *httpStrategy) WithTLS() *httpStrategy {
+	s.tls = true
+	s.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	return s
+}
+
+func (s *httpStrategy) WaitUntilReady(ctx context.Context, target Target) error {
+	return poll(ctx, func() (bool, error) {
+		port, err := target.MappedPort(s.port)
+		if err != nil {
+			return false, nil // Port isn't published yet; keep polling.
+		}
+		scheme := "http"
+		if s.tls {
+			scheme = "https"
+		}
+		url := fmt.Sprintf("%s://%s:%d%s", scheme, target.Host(), port, s.path)
+		req, err := http.NewRequestWithContext(ctx, s.method, url, nil)
+		if err != nil {
+			return false, fmt.Errorf("building request for %q: %w", url, err)
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return false, nil // Connection refused/reset; keep polling.
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == s.statusCode, nil
+	})
+}
+
+// Name identifies this Strategy as "http".
+func (s *httpStrategy) Name() string { return "http" }
+
+// portStrategy waits for a TCP port to accept connections.
+type portStrategy struct {
+	port int
+}
+
+// ForListeningPort returns a Strategy that's satisfied once containerPort
+// accepts a TCP connection.
+func ForListeningPort(containerPort int) *portStrategy {
+	return &portStrategy{port: containerPort}
+}
+
+func (s *portStrategy) WaitUntilReady(ctx context.Context, target Target) error {
+	var d net.Dialer
+	return poll(ctx, func() (bool, error) {
+		port, err := target.MappedPort(s.port)
+		if err != nil {
+			return false, nil
+		}
+		conn, err := d.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", target.Host(), port))
+		if err != nil {
+			return false, nil
+		}
+		conn.Close()
+		return true, nil
+	})
+}
+
+// Name identifies this Strategy as "port".
+func (s *portStrategy) Name() string { return "port" }
+
+// execStrategy waits for a command run inside the container to exit with an
+// expected status code.
+type execStrategy struct {
+	cmd        []string
+	statusCode int
+}
+
+// ForExec returns a Strategy that's satisfied once running cmd inside the
+// container exits 0. Use WithExitCode to expect a different status.
+func ForExec(cmd ...string) *execStrategy {
+	return &execStrategy{cmd: cmd, statusCode: 0}
+}
+
+// WithExitCode sets the exit code considered "ready". Default 0.
+func (s *execStrategy) WithExitCode(code int) *execStrategy {
+	s.statusCode = code
+	return s
+}
+
+func (s *execStrategy) WaitUntilReady(ctx context.Context, target Target) error {
+	return poll(ctx, func() (bool, error) {
+		code, _, err := target.Exec(ctx, s.cmd...)
+		if err != nil {
+			return false, nil // Exec itself failing (e.g. daemon not up yet): keep polling.
+		}
+		return code == s.statusCode, nil
+	})
+}
+
+// Name identifies this Strategy as "exec".
+func (s *execStrategy) Name() string { return "exec" }
+
+// healthCheckStrategy waits for docker's own HEALTHCHECK status (set by
+// the image's HEALTHCHECK instruction) to report "healthy".
+type healthCheckStrategy struct{}
+
+// ForHealthCheck returns a Strategy that's satisfied once the container's
+// docker-reported health status is "healthy". The container's image must
+// define a HEALTHCHECK for this to ever become true.
+func ForHealthCheck() *healthCheckStrategy {
+	return &healthCheckStrategy{}
+}
+
+func (s *healthCheckStrategy) WaitUntilReady(ctx context.Context, target Target) error {
+	return poll(ctx, func() (bool, error) {
+		status, err := target.HealthStatus(ctx)
+		if err != nil {
+			return false, nil // Status not reported yet; keep polling.
+		}
+		if status == "unhealthy" {
+			return false, fmt.Errorf("container reported unhealthy")
+		}
+		return status == "healthy", nil
+	})
+}
+
+// Name identifies this Strategy as "healthcheck".
+func (s *healthCheckStrategy) Name() string { return "healthcheck" }
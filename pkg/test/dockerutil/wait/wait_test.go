@@ -0,0 +1,163 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wait
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeTarget is an in-process stand-in for dockerutil.Container, letting
+// each Strategy be tested without a real docker daemon.
+type fakeTarget struct {
+	logs       string
+	httpSrv    *httptest.Server
+	listener   net.Listener
+	execFunc   func(ctx context.Context, cmd ...string) (int, string, error)
+	healthFunc func(ctx context.Context) (string, error)
+}
+
+func (f *fakeTarget) Host() string { return "127.0.0.1" }
+
+func (f *fakeTarget) MappedPort(int) (int, error) {
+	if f.httpSrv != nil {
+		return f.httpSrv.Listener.Addr().(*net.TCPAddr).Port, nil
+	}
+	if f.listener != nil {
+		return f.listener.Addr().(*net.TCPAddr).Port, nil
+	}
+	return 0, nil
+}
+
+func (f *fakeTarget) Logs() (string, error) { return f.logs, nil }
+
+func (f *fakeTarget) Exec(ctx context.Context, cmd ...string) (int, string, error) {
+	return f.execFunc(ctx, cmd...)
+}
+
+func (f *fakeTarget) HealthStatus(ctx context.Context) (string, error) {
+	return f.healthFunc(ctx)
+}
+
+func withShortPollInterval(t *testing.T) {
+	t.Helper()
+	old := pollInterval
+	pollInterval = time.Millisecond
+	t.Cleanup(func() { pollInterval = old })
+}
+
+func TestForLogBecomesReadyOnMatch(t *testing.T) {
+	withShortPollInterval(t)
+	target := &fakeTarget{logs: "starting up..."}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		target.logs = "mysqld: ready for connections"
+	}()
+	if err := ForLog("ready for connections").WaitUntilReady(ctx, target); err != nil {
+		t.Fatalf("ForLog: %v", err)
+	}
+}
+
+func TestForLogTimesOutWithoutMatch(t *testing.T) {
+	withShortPollInterval(t)
+	target := &fakeTarget{logs: "still starting..."}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ForLog("ready for connections").WaitUntilReady(ctx, target); err == nil {
+		t.Fatalf("ForLog succeeded without a matching log line, want timeout error")
+	}
+}
+
+func TestForHTTPMatchesStatusCode(t *testing.T) {
+	withShortPollInterval(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	target := &fakeTarget{httpSrv: srv}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ForHTTP(80, "/").WithStatusCode(http.StatusOK).WaitUntilReady(ctx, target); err != nil {
+		t.Fatalf("ForHTTP: %v", err)
+	}
+}
+
+func TestForListeningPortBecomesReadyOnConnect(t *testing.T) {
+	withShortPollInterval(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	target := &fakeTarget{listener: ln}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ForListeningPort(80).WaitUntilReady(ctx, target); err != nil {
+		t.Fatalf("ForListeningPort: %v", err)
+	}
+}
+
+func TestForExecRetriesUntilExpectedExitCode(t *testing.T) {
+	withShortPollInterval(t)
+	calls := 0
+	target := &fakeTarget{execFunc: func(context.Context, ...string) (int, string, error) {
+		calls++
+		if calls < 3 {
+			return 1, "", nil
+		}
+		return 0, "ok", nil
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ForExec("true").WaitUntilReady(ctx, target); err != nil {
+		t.Fatalf("ForExec: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("exec called %d times, want 3", calls)
+	}
+}
+
+func TestForHealthCheckFailsFastOnUnhealthy(t *testing.T) {
+	withShortPollInterval(t)
+	target := &fakeTarget{healthFunc: func(context.Context) (string, error) { return "unhealthy", nil }}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ForHealthCheck().WaitUntilReady(ctx, target); err == nil {
+		t.Fatalf("ForHealthCheck succeeded despite an unhealthy status, want error")
+	}
+}
+
+func TestForHealthCheckWaitsForHealthy(t *testing.T) {
+	withShortPollInterval(t)
+	calls := 0
+	target := &fakeTarget{healthFunc: func(context.Context) (string, error) {
+		calls++
+		if calls < 3 {
+			return "starting", nil
+		}
+		return "healthy", nil
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ForHealthCheck().WaitUntilReady(ctx, target); err != nil {
+		t.Fatalf("ForHealthCheck: %v", err)
+	}
+}
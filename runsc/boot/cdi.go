@@ -0,0 +1,74 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"fmt"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/specutils"
+)
+
+// cdiDeviceRefs returns the CDI device references createLoader should
+// resolve for spec: those named in spec.Annotations' cdi.k8s.io/* keys,
+// plus any passed explicitly via Args.CDIDevices (the "runsc run --device
+// vendor.com/gpu=all" flag). Args.CDIDevices lets a caller request CDI
+// devices without having to round-trip them through an OCI annotation
+// first, the same way NVIDIA_VISIBLE_DEVICES can come from either an env
+// var or an annotation today.
+func cdiDeviceRefs(spec *specs.Spec, argsCDIDevices []string) []string {
+	refs := specutils.CDIDeviceRefsFromSpec(spec)
+	seen := make(map[string]struct{}, len(refs))
+	for _, ref := range refs {
+		seen[ref] = struct{}{}
+	}
+	for _, ref := range argsCDIDevices {
+		if _, ok := seen[ref]; !ok {
+			refs = append(refs, ref)
+			seen[ref] = struct{}{}
+		}
+	}
+	return refs
+}
+
+// resolveCDIForContainer resolves refs against the standard CDI spec
+// directories and translates the result into the three things
+// containerMounter.mountAll needs to fold into the container's mount
+// namespace and process environment:
+//
+//   - devices: device nodes to create under /dev via makeDeviceNode, the
+//     same way nvidia-container-runtime-hook's device nodes are created
+//     today.
+//   - mounts: additional bind mounts (host libraries, config files) to
+//     append to spec.Mounts before mountAll walks them. Each destination
+//     still goes through pinMountTarget like every other mount, so a CDI
+//     spec can't use a malicious destination to escape the container root
+//     any more than a regular OCI mount could.
+//   - env: environment variable additions to append to procArgs.Envv.
+//
+// Returns ok == false if refs is empty, meaning the caller requested no CDI
+// devices and mountAll should proceed exactly as it does today.
+func resolveCDIForContainer(refs []string) (devices []specs.LinuxDevice, mounts []specs.Mount, env []string, ok bool, err error) {
+	if len(refs) == 0 {
+		return nil, nil, nil, false, nil
+	}
+	res, err := specutils.ResolveCDIDevices(refs)
+	if err != nil {
+		return nil, nil, nil, false, fmt.Errorf("resolving CDI devices %v: %w", refs, err)
+	}
+	log.Infof("Resolved CDI devices %v to %d device node(s), %d mount(s), %d env var(s)", refs, len(res.DeviceNodes), len(res.Mounts), len(res.Env))
+	return res.DeviceNodes, res.Mounts, res.Env, true, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestCDIDeviceRefsMergesAnnotationsAndArgs(t *testing.T) {
+	spec := testSpec()
+	spec.Annotations = map[string]string{
+		"cdi.k8s.io/gpu": "nvidia.com/gpu=0,nvidia.com/gpu=1",
+	}
+
+	refs := cdiDeviceRefs(spec, []string{"nvidia.com/gpu=1", "nvidia.com/gpu=all"})
+
+	got := append([]string{}, refs...)
+	sort.Strings(got)
+	want := []string{"nvidia.com/gpu=0", "nvidia.com/gpu=1", "nvidia.com/gpu=all"}
+	if len(got) != len(want) {
+		t.Fatalf("cdiDeviceRefs = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("cdiDeviceRefs = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestCDIDeviceRefsEmptyWhenUnrequested(t *testing.T) {
+	spec := testSpec()
+	if refs := cdiDeviceRefs(spec, nil); len(refs) != 0 {
+		t.Errorf("cdiDeviceRefs = %v, want empty", refs)
+	}
+}
+
+func TestResolveCDIForContainerNoopWhenNoRefs(t *testing.T) {
+	devices, mounts, env, ok, err := resolveCDIForContainer(nil)
+	if err != nil {
+		t.Fatalf("resolveCDIForContainer: %v", err)
+	}
+	if ok {
+		t.Errorf("resolveCDIForContainer reported ok with no refs requested")
+	}
+	if devices != nil || mounts != nil || env != nil {
+		t.Errorf("resolveCDIForContainer returned non-nil results with no refs requested")
+	}
+}
+
+func TestResolveCDIForContainerErrorsOnUnresolvableRef(t *testing.T) {
+	// No CDI spec directories exist in the test environment, so any
+	// concrete device reference should fail to resolve rather than
+	// silently producing no devices/mounts.
+	if _, _, _, _, err := resolveCDIForContainer([]string{"nvidia.com/gpu=0"}); err == nil {
+		t.Errorf("resolveCDIForContainer succeeded resolving a device with no CDI spec present, want error")
+	}
+}
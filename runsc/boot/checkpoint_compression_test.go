@@ -0,0 +1,130 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestParseCheckpointCompression(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want CheckpointCompression
+	}{
+		{"none", CheckpointCompressionNone},
+		{"gzip", CheckpointCompressionGzip},
+		{"zstd", CheckpointCompressionZstd},
+	} {
+		got, err := ParseCheckpointCompression(tc.s)
+		if err != nil {
+			t.Errorf("ParseCheckpointCompression(%q): %v", tc.s, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseCheckpointCompression(%q) = %v, want %v", tc.s, got, tc.want)
+		}
+		if got.String() != tc.s {
+			t.Errorf("%v.String() = %q, want %q", got, got.String(), tc.s)
+		}
+	}
+	if _, err := ParseCheckpointCompression("bzip2"); err == nil {
+		t.Errorf("ParseCheckpointCompression(\"bzip2\") succeeded, want error")
+	}
+}
+
+func TestCheckpointCompressionNoneRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewCheckpointCompressor(&buf, CheckpointCompressionNone)
+	if err != nil {
+		t.Fatalf("NewCheckpointCompressor: %v", err)
+	}
+	want := []byte("sentry state bytes")
+	if _, err := w.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	codec, r, err := DetectCheckpointCompression(&buf)
+	if err != nil {
+		t.Fatalf("DetectCheckpointCompression: %v", err)
+	}
+	if codec != CheckpointCompressionNone {
+		t.Errorf("DetectCheckpointCompression = %v, want %v", codec, CheckpointCompressionNone)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("round-tripped data = %q, want %q", got, want)
+	}
+}
+
+func TestCheckpointCompressionGzipRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewCheckpointCompressor(&buf, CheckpointCompressionGzip)
+	if err != nil {
+		t.Fatalf("NewCheckpointCompressor: %v", err)
+	}
+	want := []byte("sentry state bytes, compressed with gzip for backward compatibility")
+	if _, err := w.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A restore that doesn't know the archive's codec ahead of time must
+	// still detect it from the header, exactly as it would for an archive
+	// produced before zstd became the default.
+	codec, sniffed, err := DetectCheckpointCompression(&buf)
+	if err != nil {
+		t.Fatalf("DetectCheckpointCompression: %v", err)
+	}
+	if codec != CheckpointCompressionGzip {
+		t.Errorf("DetectCheckpointCompression = %v, want %v", codec, CheckpointCompressionGzip)
+	}
+	dr, err := NewCheckpointDecompressor(sniffed, codec)
+	if err != nil {
+		t.Fatalf("NewCheckpointDecompressor: %v", err)
+	}
+	got, err := io.ReadAll(dr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("round-tripped data = %q, want %q", got, want)
+	}
+}
+
+func TestDetectCheckpointCompressionZstdMagic(t *testing.T) {
+	// NewCheckpointCompressor's zstd path is exercised indirectly via
+	// Checkpoint/Restore in sandbox_test.go; here we only confirm the
+	// sniffing logic recognizes a real zstd frame header so a zstd archive
+	// restores without the caller having to say --checkpoint-compression
+	// explicitly.
+	zstdHeader := []byte{0x28, 0xb5, 0x2f, 0xfd}
+	codec, _, err := DetectCheckpointCompression(bytes.NewReader(append(zstdHeader, []byte("...frame body")...)))
+	if err != nil {
+		t.Fatalf("DetectCheckpointCompression: %v", err)
+	}
+	if codec != CheckpointCompressionZstd {
+		t.Errorf("DetectCheckpointCompression = %v, want %v", codec, CheckpointCompressionZstd)
+	}
+}
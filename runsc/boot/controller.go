@@ -0,0 +1,322 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"fmt"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/pkg/urpc"
+	"gvisor.dev/gvisor/runsc/boot/hooks"
+)
+
+// The containerManager method names runsc/sandbox dials over the sandbox's
+// urpc control socket. Each has a matching exported method on
+// containerManager below with the signature urpc requires: two arguments
+// (the decoded request and a pointer to the response to populate) and an
+// error return.
+const (
+	// ContMgrAttach joins a caller-supplied FD to a container's stdio hub.
+	ContMgrAttach = "containerManager.Attach"
+
+	// ContMgrResizeTTY reports a new terminal window size for a container's
+	// controlling PTY.
+	ContMgrResizeTTY = "containerManager.ResizeTTY"
+
+	// ContMgrStreamPortForward proxies an already-connected stream to a
+	// port inside a container's network namespace.
+	ContMgrStreamPortForward = "containerManager.StreamPortForward"
+
+	// ContMgrRename changes a running subcontainer's ID.
+	ContMgrRename = "containerManager.Rename"
+
+	// ContMgrSetResourceLimits applies cgroup controllers beyond CPU count
+	// and memory limit to the running sentry.
+	ContMgrSetResourceLimits = "containerManager.SetResourceLimits"
+
+	// ContMgrReattach reconnects a crashed-and-restarted supervisor to an
+	// already-running sandbox.
+	ContMgrReattach = "containerManager.Reattach"
+
+	// ContMgrRestoreStream restores a container from a pre-copy migration
+	// stream produced by a peer's pre-dump RPCs.
+	ContMgrRestoreStream = "containerManager.RestoreStream"
+
+	// ContMgrPreDumpStart starts an iterative pre-copy migration of a
+	// container's memory without freezing it.
+	ContMgrPreDumpStart = "containerManager.PreDumpStart"
+
+	// ContMgrPreDumpIterate copies out one round of pages dirtied since the
+	// previous pre-dump round.
+	ContMgrPreDumpIterate = "containerManager.PreDumpIterate"
+
+	// ContMgrPreDumpFinalize takes the final, stop-the-world pre-copy round.
+	ContMgrPreDumpFinalize = "containerManager.PreDumpFinalize"
+
+	// ContMgrRunHooks runs the OCI lifecycle hooks configured for a given
+	// stage inside the sentry.
+	ContMgrRunHooks = "containerManager.RunHooks"
+)
+
+// containerManager is the receiver urpc dispatches ContMgrXxx calls to. It
+// mirrors the Loader's container registry rather than owning its own state;
+// l is nil only in tests that exercise argument (de)serialization without a
+// running sentry.
+type containerManager struct {
+	l *Loader
+}
+
+// AttachArgs is the argument to ContMgrAttach.
+type AttachArgs struct {
+	urpc.FilePayload
+
+	// ContainerID is the container to attach to.
+	ContainerID string
+}
+
+// Attach joins the FD in args' FilePayload to ContainerID's stdio hub, the
+// sentry-side half of Sandbox.AttachTTY's socketpair.
+func (cm *containerManager) Attach(args *AttachArgs, _ *struct{}) error {
+	if len(args.FilePayload.Files) != 1 {
+		return fmt.Errorf("attach requires exactly one FD, got %d", len(args.FilePayload.Files))
+	}
+	return cm.l.attachContainer(args.ContainerID, args.FilePayload.Files[0])
+}
+
+// ResizeTTYArgs is the argument to ContMgrResizeTTY.
+type ResizeTTYArgs struct {
+	// ContainerID is the container whose controlling PTY is being resized.
+	ContainerID string
+
+	// Rows and Cols are the new terminal window size.
+	Rows, Cols uint16
+}
+
+// ResizeTTY reports a new terminal window size for args.ContainerID's
+// controlling PTY.
+func (cm *containerManager) ResizeTTY(args *ResizeTTYArgs, _ *struct{}) error {
+	return cm.l.resizeContainerTTY(args.ContainerID, args.Rows, args.Cols)
+}
+
+// StreamPortForwardArgs is the argument to ContMgrStreamPortForward.
+type StreamPortForwardArgs struct {
+	urpc.FilePayload
+
+	// ContainerID is the container whose network namespace port is being
+	// forwarded.
+	ContainerID string
+
+	// Port is the port inside ContainerID's network namespace to dial.
+	Port uint16
+}
+
+// StreamPortForward dials args.Port inside args.ContainerID's network
+// namespace and proxies bytes to and from the FD in args' FilePayload until
+// either side closes it.
+func (cm *containerManager) StreamPortForward(args *StreamPortForwardArgs, _ *struct{}) error {
+	if len(args.FilePayload.Files) != 1 {
+		return fmt.Errorf("stream port forward requires exactly one FD, got %d", len(args.FilePayload.Files))
+	}
+	return cm.l.streamPortForward(args.ContainerID, args.Port, args.FilePayload.Files[0])
+}
+
+// RenameArgs is the argument to ContMgrRename.
+type RenameArgs struct {
+	// ContainerID is the subcontainer's current ID.
+	ContainerID string
+
+	// NewID is the subcontainer's new ID.
+	NewID string
+}
+
+// Rename changes a running subcontainer's ID from args.ContainerID to
+// args.NewID.
+func (cm *containerManager) Rename(args *RenameArgs, _ *struct{}) error {
+	return cm.l.renameContainer(args.ContainerID, args.NewID)
+}
+
+// BlkioDeviceThrottle is a single per-device IOPS/BPS throttle read from the
+// io/blkio cgroup controller.
+type BlkioDeviceThrottle struct {
+	// Major and Minor are the throttled device's major/minor number.
+	Major, Minor int64
+
+	// ReadBPS and WriteBPS are the device's read/write byte-per-second
+	// throttles, or 0 if unset.
+	ReadBPS, WriteBPS uint64
+
+	// ReadIOPS and WriteIOPS are the device's read/write IO-per-second
+	// throttles, or 0 if unset.
+	ReadIOPS, WriteIOPS uint64
+}
+
+// ResourceLimits is the argument to ContMgrSetResourceLimits: every cgroup
+// controller Sandbox.buildResourceLimits reads beyond CPU count and memory
+// limit, which the sentry needs before its first container even starts and
+// so takes on the command line instead.
+type ResourceLimits struct {
+	// PIDsMax is the pids.max limit, or -1 if unlimited.
+	PIDsMax int64
+
+	// HugetlbLimits are the hugetlb.<pagesize>.limit_in_bytes limits, keyed
+	// by page size (e.g. "2MB", "1GB").
+	HugetlbLimits map[string]int64
+
+	// BlkioWeight is the io/blkio proportional weight.
+	BlkioWeight uint64
+
+	// BlkioThrottle are the per-device IOPS/BPS throttles.
+	BlkioThrottle []BlkioDeviceThrottle
+
+	// NetClsClassID is the net_cls.classid tag applied to packets the
+	// sandbox's network namespace sends, or 0 if unset.
+	NetClsClassID uint32
+
+	// CPUSetCPUs and CPUSetMems are the cpuset.cpus and cpuset.mems masks,
+	// in the list format cpuset itself uses (e.g. "0-3,7").
+	CPUSetCPUs, CPUSetMems string
+
+	// CPUWeight is cpu.weight (cgroupv2) or the cpu.shares-derived
+	// equivalent (cgroupv1).
+	CPUWeight uint64
+}
+
+// SetResourceLimits applies limits to the running sentry: CPUSetCPUs pins
+// the sentry's worker goroutines via sched_setaffinity, HugetlbLimits caps
+// pgalloc.MemoryFile's per-size hugepage allocations, and the rest feed the
+// scheduler's admission checks.
+func (cm *containerManager) SetResourceLimits(limits *ResourceLimits, _ *struct{}) error {
+	return cm.l.applyResourceLimits(limits)
+}
+
+// ReattachResult is the result of ContMgrReattach.
+type ReattachResult struct {
+	// Pid is the sandbox process' PID.
+	Pid int
+
+	// ContainerIDs are the IDs of every container currently registered
+	// with the sandbox.
+	ContainerIDs []string
+}
+
+// Reattach reports the state a reattaching supervisor needs to rebuild
+// enough of its in-memory Sandbox to resume talking to this control
+// socket.
+func (cm *containerManager) Reattach(_ *string, result *ReattachResult) error {
+	result.Pid = cm.l.sandboxPid()
+	result.ContainerIDs = cm.l.registeredContainerIDs()
+	return nil
+}
+
+// PreDumpResult is both the response to ContMgrPreDumpStart/
+// ContMgrPreDumpIterate/ContMgrPreDumpFinalize and the wire representation
+// of a single pre-copy migration round forwarded by RestoreStreamArgs.
+type PreDumpResult struct {
+	// DirtyPageCount is the number of pages still dirty after this round.
+	DirtyPageCount uint64
+
+	// DirtyRuns is a run-length encoded bitmap of which pages changed
+	// since the previous generation (alternating clean-run, dirty-run
+	// lengths).
+	DirtyRuns []uint64
+
+	// Payload is the sentry-compressed contents of the dirty runs, in
+	// order.
+	Payload []byte
+}
+
+// PreDumpArgs is the argument to ContMgrPreDumpStart and
+// ContMgrPreDumpIterate.
+type PreDumpArgs struct {
+	// ContainerID is the container being pre-copied.
+	ContainerID string
+
+	// Generation is the pre-copy round number. It is zero for
+	// ContMgrPreDumpStart, which doesn't copy any pages itself.
+	Generation uint64
+}
+
+// PreDumpStart begins tracking dirty pages for args.ContainerID without
+// pausing it.
+func (cm *containerManager) PreDumpStart(args *PreDumpArgs, result *PreDumpResult) error {
+	return cm.l.preDumpStart(args.ContainerID, result)
+}
+
+// PreDumpIterate copies out the pages dirtied since the previous round (or
+// since PreDumpStart, for the first) without pausing args.ContainerID.
+func (cm *containerManager) PreDumpIterate(args *PreDumpArgs, result *PreDumpResult) error {
+	return cm.l.preDumpIterate(args.ContainerID, args.Generation, result)
+}
+
+// PreDumpFinalizeArgs is the argument to ContMgrPreDumpFinalize.
+type PreDumpFinalizeArgs struct {
+	// ContainerID is the container being pre-copied.
+	ContainerID string
+
+	// SaveRestoreExecArgv, SaveRestoreExecTimeout and
+	// SaveRestoreExecContainerID mirror sandbox.CheckpointOpts' fields of
+	// the same name: they configure an optional command run inside the
+	// restored sentry immediately after this final round resumes.
+	SaveRestoreExecArgv        string
+	SaveRestoreExecTimeout     time.Duration
+	SaveRestoreExecContainerID string
+}
+
+// PreDumpFinalize takes the final, stop-the-world pre-copy round: it pauses
+// args.ContainerID, drains whatever pages are still dirty, and leaves it
+// paused pending a peer's RestoreStream call confirming the migration
+// completed.
+func (cm *containerManager) PreDumpFinalize(args *PreDumpFinalizeArgs, result *PreDumpResult) error {
+	return cm.l.preDumpFinalize(args, result)
+}
+
+// RestoreStreamArgs is the argument to ContMgrRestoreStream.
+type RestoreStreamArgs struct {
+	// ContainerID is the container being restored.
+	ContainerID string
+
+	// Frames are the pre-copy migration rounds produced by a peer's
+	// PreDumpStart/PreDumpIterate/PreDumpFinalize calls, in order.
+	Frames []PreDumpResult
+
+	// Background indicates that the caller will wait on ContMgrWaitRestore
+	// rather than block until the restore completes.
+	Background bool
+}
+
+// RestoreStream restores args.ContainerID from the pre-copy migration
+// stream in args.Frames.
+func (cm *containerManager) RestoreStream(args *RestoreStreamArgs, _ *struct{}) error {
+	return cm.l.restoreFromStream(args.ContainerID, args.Frames, args.Background)
+}
+
+// RunHooksArgs is the argument to ContMgrRunHooks.
+type RunHooksArgs struct {
+	// Hooks are the OCI lifecycle hooks to run, in order.
+	Hooks []specs.Hook
+
+	// State is the OCI runtime state object to pass to each hook on its
+	// stdin.
+	State hooks.State
+}
+
+// RunHooks runs args.Hooks inside the sentry, passing args.State to each on
+// its stdin. It's used for hook stages (e.g. startContainer) that the OCI
+// runtime spec requires to run inside the container's own mount namespace,
+// which this process only ever reaches through urpc.
+func (cm *containerManager) RunHooks(args *RunHooksArgs, _ *struct{}) error {
+	return cm.l.runHooks(args.Hooks, args.State)
+}
@@ -0,0 +1,347 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hooks implements an OCI runtime hooks manager modeled on CRI-O's
+// hooks.d directory convention: JSON files describing a hook and the
+// predicates (command, annotations, bind mounts) under which it applies are
+// scanned once at sandbox creation and merged into the spec's Hooks at the
+// stages they declare. This lets NVIDIA-CDI, seccomp-notify agents and
+// similar host integrations work the same way across containerd, CRI-O and
+// runsc without the spec author special-casing runsc.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/pkg/log"
+)
+
+// Stage identifies a point in the container lifecycle a hook can run at,
+// matching the stage names used in spec.Hooks.
+type Stage string
+
+// The OCI-defined hook stages.
+const (
+	Prestart        Stage = "prestart"
+	CreateRuntime   Stage = "createRuntime"
+	CreateContainer Stage = "createContainer"
+	StartContainer  Stage = "startContainer"
+	Poststart       Stage = "poststart"
+	Poststop        Stage = "poststop"
+)
+
+// hookFile is the on-disk JSON schema of a single hooks.d entry.
+type hookFile struct {
+	Version string     `json:"version"`
+	Hook    specs.Hook `json:"hook"`
+	When    hookWhen   `json:"when"`
+	Stages  []string   `json:"stages"`
+}
+
+// hookWhen describes the predicates under which a hook applies. A hook
+// applies if Always is true, or if it sets at least one predicate and every
+// predicate it sets matches the container. A hook with neither never
+// applies.
+type hookWhen struct {
+	Always        bool              `json:"always"`
+	Command       string            `json:"command"`
+	HasBindMounts bool              `json:"hasBindMounts"`
+	Annotations   map[string]string `json:"annotations"`
+}
+
+// hook is a hookFile with its regexes pre-compiled.
+type hook struct {
+	name    string
+	file    hookFile
+	command *regexp.Regexp
+	annots  map[string]*regexp.Regexp
+}
+
+// Manager holds the set of hooks loaded from a hooks.d directory and knows
+// how to merge the ones that match a given container into its spec.
+type Manager struct {
+	hooks []hook
+}
+
+// New scans every *.json file directly inside dir and compiles it into a
+// Manager. Files that fail to parse are reported immediately: a typo in a
+// hooks.d file should fail sandbox creation loudly rather than silently
+// disable the hook.
+func New(dir string) (*Manager, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading hooks directory %q: %w", dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	m := &Manager{}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		h, err := loadHookFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading hook %q: %w", path, err)
+		}
+		m.hooks = append(m.hooks, h)
+	}
+	log.Infof("Loaded %d OCI runtime hook(s) from %q", len(m.hooks), dir)
+	return m, nil
+}
+
+func loadHookFile(path string) (hook, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return hook{}, err
+	}
+	var hf hookFile
+	if err := json.Unmarshal(b, &hf); err != nil {
+		return hook{}, err
+	}
+	if hf.Hook.Path == "" {
+		return hook{}, fmt.Errorf("hook is missing a path")
+	}
+	h := hook{name: filepath.Base(path), file: hf}
+	if hf.When.Command != "" {
+		re, err := regexp.Compile(hf.When.Command)
+		if err != nil {
+			return hook{}, fmt.Errorf("compiling command pattern %q: %w", hf.When.Command, err)
+		}
+		h.command = re
+	}
+	if len(hf.When.Annotations) > 0 {
+		h.annots = make(map[string]*regexp.Regexp, len(hf.When.Annotations))
+		for k, pattern := range hf.When.Annotations {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return hook{}, fmt.Errorf("compiling annotation pattern %q=%q: %w", k, pattern, err)
+			}
+			h.annots[k] = re
+		}
+	}
+	return h, nil
+}
+
+// matches reports whether h applies to spec.
+func (h hook) matches(spec *specs.Spec) bool {
+	w := h.file.When
+	if w.Always {
+		return true
+	}
+	matched := false
+	if h.command != nil {
+		if spec.Process == nil || len(spec.Process.Args) == 0 || !h.command.MatchString(spec.Process.Args[0]) {
+			return false
+		}
+		matched = true
+	}
+	if w.HasBindMounts {
+		if !hasBindMount(spec) {
+			return false
+		}
+		matched = true
+	}
+	for k, re := range h.annots {
+		v, ok := spec.Annotations[k]
+		if !ok || !re.MatchString(v) {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+func hasBindMount(spec *specs.Spec) bool {
+	for _, m := range spec.Mounts {
+		if m.Type == "bind" {
+			return true
+		}
+		for _, o := range m.Options {
+			if o == "bind" || o == "rbind" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runsAt reports whether h declares stage among its configured stages.
+// Hooks with no stages default to prestart, matching runc/CRI-O's
+// historical (pre-OCI-hooks-v2) hooks.d behavior.
+func (h hook) runsAt(stage Stage) bool {
+	if len(h.file.Stages) == 0 {
+		return stage == Prestart
+	}
+	for _, s := range h.file.Stages {
+		if Stage(s) == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Inject merges every loaded hook that matches spec into spec.Hooks at the
+// stage(s) it declares. It reports whether spec was modified. Call this
+// once, before the sandbox process is created, so that every stage's hooks
+// are already part of the spec the sentry and Sandbox.destroy consult.
+func (m *Manager) Inject(spec *specs.Spec) bool {
+	injected := false
+	for _, h := range m.hooks {
+		if !h.matches(spec) {
+			continue
+		}
+		for _, stage := range []Stage{Prestart, CreateRuntime, CreateContainer, StartContainer, Poststart, Poststop} {
+			if h.runsAt(stage) {
+				appendHook(spec, stage, h.file.Hook)
+				injected = true
+			}
+		}
+	}
+	return injected
+}
+
+func appendHook(spec *specs.Spec, stage Stage, h specs.Hook) {
+	if spec.Hooks == nil {
+		spec.Hooks = &specs.Hooks{}
+	}
+	switch stage {
+	case Prestart:
+		spec.Hooks.Prestart = append(spec.Hooks.Prestart, h)
+	case CreateRuntime:
+		spec.Hooks.CreateRuntime = append(spec.Hooks.CreateRuntime, h)
+	case CreateContainer:
+		spec.Hooks.CreateContainer = append(spec.Hooks.CreateContainer, h)
+	case StartContainer:
+		spec.Hooks.StartContainer = append(spec.Hooks.StartContainer, h)
+	case Poststart:
+		spec.Hooks.Poststart = append(spec.Hooks.Poststart, h)
+	case Poststop:
+		spec.Hooks.Poststop = append(spec.Hooks.Poststop, h)
+	}
+}
+
+// State is the subset of the OCI runtime "state" object (see the OCI
+// runtime spec's Query State operation) that's meaningful to pass to a
+// hook on its stdin. It's marshaled to JSON exactly once per stage and
+// reused across every hook configured for that stage.
+type State struct {
+	Version     string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// HooksForStage returns the slice of spec.Hooks belonging to stage, or nil
+// if spec has no hooks configured for it.
+func HooksForStage(spec *specs.Spec, stage Stage) []specs.Hook {
+	if spec == nil || spec.Hooks == nil {
+		return nil
+	}
+	switch stage {
+	case Prestart:
+		return spec.Hooks.Prestart
+	case CreateRuntime:
+		return spec.Hooks.CreateRuntime
+	case CreateContainer:
+		return spec.Hooks.CreateContainer
+	case StartContainer:
+		return spec.Hooks.StartContainer
+	case Poststart:
+		return spec.Hooks.Poststart
+	case Poststop:
+		return spec.Hooks.Poststop
+	}
+	return nil
+}
+
+// Run executes every hook in hs in order, delivering state's JSON encoding
+// on each hook's stdin per the OCI runtime spec. A hook's Timeout, if set,
+// bounds how long it's allowed to run before being killed. Its stderr is
+// copied into the debug log line-by-line as it's produced, so a hung or
+// chatty hook is visible before it fails or times out. Run stops and
+// returns the first error it hits; the caller decides whether that aborts
+// the lifecycle transition (pre-run stages) or is merely logged
+// (poststart/poststop).
+func Run(hs []specs.Hook, state State) error {
+	if len(hs) == 0 {
+		return nil
+	}
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshaling hook state: %w", err)
+	}
+	for _, h := range hs {
+		if err := runHook(h, stateJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runHook(h specs.Hook, stateJSON []byte) error {
+	ctx := context.Background()
+	if h.Timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*h.Timeout)*time.Second)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, h.Path, h.Args...)
+	cmd.Env = h.Env
+	cmd.Stdin = bytes.NewReader(stateJSON)
+	cmd.Stderr = hookLogWriter{path: h.Path}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("hook %q timed out after %ds", h.Path, *h.Timeout)
+		}
+		return fmt.Errorf("running hook %q: %w: %s", h.Path, err, stdout.String())
+	}
+	return nil
+}
+
+// hookLogWriter forwards each line written to it to the debug log, tagged
+// with the hook that produced it. It's used as a hook's cmd.Stderr so
+// output streams into the log as it's generated, rather than only being
+// visible (or lost) after the hook exits.
+type hookLogWriter struct {
+	path string
+}
+
+func (w hookLogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line != "" {
+			log.Debugf("hook %q: %s", w.path, line)
+		}
+	}
+	return len(p), nil
+}
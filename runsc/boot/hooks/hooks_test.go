@@ -0,0 +1,155 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func writeHookFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("writing hook file %q: %v", name, err)
+	}
+}
+
+func TestInjectMatchesAnnotation(t *testing.T) {
+	dir := t.TempDir()
+	writeHookFile(t, dir, "nvidia.json", `{
+		"hook": {"path": "/usr/bin/nvidia-ctk", "args": ["nvidia-ctk", "hook"]},
+		"when": {"annotations": {"com.nvidia/gpu": ".*"}},
+		"stages": ["prestart", "poststop"]
+	}`)
+
+	mgr, err := New(dir)
+	if err != nil {
+		t.Fatalf("New(%q): %v", dir, err)
+	}
+
+	spec := &specs.Spec{Annotations: map[string]string{"com.nvidia/gpu": "gpu0"}}
+	if !mgr.Inject(spec) {
+		t.Fatal("want Inject to report a match, got none")
+	}
+	if spec.Hooks == nil || len(spec.Hooks.Prestart) != 1 || len(spec.Hooks.Poststop) != 1 {
+		t.Fatalf("hooks not injected at the declared stages: %+v", spec.Hooks)
+	}
+	if len(spec.Hooks.Poststart) != 0 {
+		t.Fatalf("hook injected at an undeclared stage: %+v", spec.Hooks.Poststart)
+	}
+
+	unrelated := &specs.Spec{Annotations: map[string]string{"other": "value"}}
+	if mgr.Inject(unrelated) {
+		t.Error("want no match for spec without the configured annotation")
+	}
+}
+
+func TestInjectDefaultStageIsPrestart(t *testing.T) {
+	dir := t.TempDir()
+	writeHookFile(t, dir, "always.json", `{
+		"hook": {"path": "/usr/bin/audit-hook"},
+		"when": {"always": true}
+	}`)
+
+	mgr, err := New(dir)
+	if err != nil {
+		t.Fatalf("New(%q): %v", dir, err)
+	}
+
+	spec := &specs.Spec{}
+	if !mgr.Inject(spec) {
+		t.Fatal("want Inject to report a match for an always-on hook")
+	}
+	if len(spec.Hooks.Prestart) != 1 {
+		t.Fatalf("want hook at the default prestart stage, got %+v", spec.Hooks)
+	}
+}
+
+func TestInjectHasBindMounts(t *testing.T) {
+	dir := t.TempDir()
+	writeHookFile(t, dir, "bind.json", `{
+		"hook": {"path": "/usr/bin/bind-hook"},
+		"when": {"hasBindMounts": true},
+		"stages": ["createRuntime"]
+	}`)
+
+	mgr, err := New(dir)
+	if err != nil {
+		t.Fatalf("New(%q): %v", dir, err)
+	}
+
+	noMounts := &specs.Spec{}
+	if mgr.Inject(noMounts) {
+		t.Error("want no match without a bind mount")
+	}
+
+	withBind := &specs.Spec{Mounts: []specs.Mount{{Type: "bind"}}}
+	if !mgr.Inject(withBind) {
+		t.Fatal("want a match with a bind mount present")
+	}
+}
+
+// writeScript writes an executable shell script to dir/name and returns its
+// path.
+func writeScript(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("hooks are exec'd as subprocesses; only tested on linux")
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("writing script %q: %v", name, err)
+	}
+	return path
+}
+
+func TestRunDeliversStateOnStdin(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "state.json")
+	path := writeScript(t, dir, "capture.sh", "cat > "+out+"\n")
+
+	state := State{Version: "1.0.2", ID: "container1", Status: "creating", Pid: 1234, Bundle: "/bundle"}
+	if err := Run([]specs.Hook{{Path: path}}, state); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading captured stdin: %v", err)
+	}
+	if !strings.Contains(string(got), `"id":"container1"`) {
+		t.Fatalf("captured stdin doesn't contain the expected state: %s", got)
+	}
+}
+
+func TestRunAbortsOnFirstFailingHook(t *testing.T) {
+	dir := t.TempDir()
+	ran := filepath.Join(dir, "second-ran")
+	failing := writeScript(t, dir, "fail.sh", "exit 1\n")
+	second := writeScript(t, dir, "second.sh", "touch "+ran+"\n")
+
+	err := Run([]specs.Hook{{Path: failing}, {Path: second}}, State{ID: "container1"})
+	if err == nil {
+		t.Fatal("want Run to return an error when a hook exits non-zero")
+	}
+	if _, statErr := os.Stat(ran); statErr == nil {
+		t.Fatal("want the hook after the failing one to not run")
+	}
+}
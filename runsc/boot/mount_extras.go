@@ -0,0 +1,275 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/runsc/config"
+)
+
+// cdiMounter resolves a container spec's CDI device requests and
+// subscription/secret files into host-side mounts and device nodes, rooted
+// at rootFD (an O_PATH descriptor for the container's resolved mount
+// namespace root). It is a narrower helper than the sentry's own
+// containerMounter/Loader (see loader.go): those mount the container's
+// rootfs and spec.Mounts themselves through the in-sentry VFS; this type
+// only covers the two sources of extra mounts that aren't part of
+// spec.Mounts to begin with, so its output is meant to be folded into
+// whatever mounts the real Loader applies rather than replacing them.
+type cdiMounter struct {
+	rootFD int
+	spec   *specs.Spec
+	conf   *config.Config
+
+	// cdiMounts and cdiDevices are additional mounts and device nodes
+	// resolved from the container's CDI device requests by
+	// resolveCDIAndSubscriptions, to be applied by applyExtraMounts
+	// alongside spec.Mounts. cdiEnv is folded into the container's process
+	// environment by the same caller that builds procArgs.Envv.
+	cdiMounts  []specs.Mount
+	cdiDevices []specs.LinuxDevice
+	cdiEnv     []string
+
+	// subscriptionMounts and subscriptionFiles are the tmpfs mounts and
+	// file contents resolved from conf.SubscriptionsDir by
+	// resolveCDIAndSubscriptions, to be applied and written out by
+	// applyExtraMounts alongside spec.Mounts.
+	subscriptionMounts []specs.Mount
+	subscriptionFiles  []subscriptionFile
+}
+
+// makeMountPoint ensures destPath exists under the container mount
+// namespace rooted at rootFD, creating it (and any missing parent
+// directories) as a directory or an empty regular file depending on
+// isDir, then pins and returns the resolved final component via
+// pinMountTarget so the caller can mount onto it without re-resolving a
+// string path.
+func makeMountPoint(rootFD int, destPath string, isDir bool) (*pinnedMountTarget, error) {
+	clean := filepath.Clean("/" + destPath)
+	full := filepath.Join(fmt.Sprintf("/proc/self/fd/%d", rootFD), clean)
+
+	if isDir {
+		if err := os.MkdirAll(full, 0755); err != nil {
+			return nil, fmt.Errorf("creating mount point directory %q: %w", clean, err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			return nil, fmt.Errorf("creating parent directories for mount point %q: %w", clean, err)
+		}
+		f, err := os.OpenFile(full, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("creating mount point file %q: %w", clean, err)
+		}
+		f.Close()
+	}
+	return pinMountTarget(rootFD, clean)
+}
+
+// parseMountOptions splits an OCI mount's Options into the mount(2) flags
+// they set and the remaining comma-joined data string, the same split
+// every mount(8)-alike performs before calling mount(2).
+func parseMountOptions(opts []string) (uintptr, string) {
+	var flags uintptr
+	var data []string
+	for _, o := range opts {
+		switch o {
+		case "ro":
+			flags |= unix.MS_RDONLY
+		case "nosuid":
+			flags |= unix.MS_NOSUID
+		case "nodev":
+			flags |= unix.MS_NODEV
+		case "noexec":
+			flags |= unix.MS_NOEXEC
+		case "bind":
+			flags |= unix.MS_BIND
+		case "rbind":
+			flags |= unix.MS_BIND | unix.MS_REC
+		default:
+			data = append(data, o)
+		}
+	}
+	return flags, strings.Join(data, ",")
+}
+
+// mountSubmount creates m's mount point under the container mount
+// namespace rooted at rootFD and mounts it there. The mount(2) call itself
+// goes through pinMountTarget/mountAtPinnedTarget rather than a plain
+// string destination, closing the window between creating the mount point
+// and mounting onto it that a symlink swap could otherwise use to redirect
+// the mount outside the resolved directory (CVE-2021-30465).
+func mountSubmount(rootFD int, m specs.Mount) error {
+	isDir := true
+	if m.Type == "bind" {
+		st, err := os.Stat(m.Source)
+		if err != nil {
+			return fmt.Errorf("stat-ing bind mount source %q: %w", m.Source, err)
+		}
+		isDir = st.IsDir()
+	}
+
+	target, err := makeMountPoint(rootFD, m.Destination, isDir)
+	if err != nil {
+		return fmt.Errorf("creating mount point for %q: %w", m.Destination, err)
+	}
+	defer target.Close()
+
+	flags, data := parseMountOptions(m.Options)
+	if m.Type == "bind" {
+		flags |= unix.MS_BIND
+	}
+	if err := mountAtPinnedTarget(m.Source, m.Type, flags, data, target); err != nil {
+		return fmt.Errorf("mounting %q onto %q: %w", m.Source, m.Destination, err)
+	}
+	return nil
+}
+
+// makeDeviceNode creates a device node for d under the container mount
+// namespace rooted at rootFD, the same way nvidia-container-runtime-hook
+// creates device nodes for a CDI-resolved GPU today.
+func makeDeviceNode(rootFD int, d specs.LinuxDevice) error {
+	clean := filepath.Clean("/" + d.Path)
+	full := filepath.Join(fmt.Sprintf("/proc/self/fd/%d", rootFD), clean)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return fmt.Errorf("creating parent directories for device node %q: %w", clean, err)
+	}
+
+	var mode uint32
+	switch d.Type {
+	case "c", "u":
+		mode = unix.S_IFCHR
+	case "b":
+		mode = unix.S_IFBLK
+	default:
+		return fmt.Errorf("device %q has unsupported type %q", clean, d.Type)
+	}
+	if d.FileMode != nil {
+		mode |= uint32(*d.FileMode)
+	} else {
+		mode |= 0600
+	}
+
+	dev := unix.Mkdev(uint32(d.Major), uint32(d.Minor))
+	if err := unix.Mknod(full, mode, int(dev)); err != nil {
+		if err == unix.EEXIST {
+			return nil
+		}
+		return fmt.Errorf("creating device node %q: %w", clean, err)
+	}
+	return nil
+}
+
+// resolveCDIAndSubscriptions resolves spec's CDI device requests - those
+// named in its cdi.k8s.io/* annotations, plus any passed explicitly via
+// argsCDIDevices ("runsc run --device vendor.com/gpu=all") - and records
+// the resulting device nodes, mounts, and environment variables on
+// mounter for applyExtraMounts to apply. Without this, a CDI device
+// request is silently dropped: cdiDeviceRefs/resolveCDIForContainer never
+// run, and neither the requested device node nor its supporting mounts
+// ever reach the sandbox.
+func resolveCDIAndSubscriptions(mounter *cdiMounter, argsCDIDevices []string) error {
+	refs := cdiDeviceRefs(mounter.spec, argsCDIDevices)
+	devices, mounts, env, ok, err := resolveCDIForContainer(refs)
+	if err != nil {
+		return fmt.Errorf("resolving CDI devices for container: %w", err)
+	}
+	if ok {
+		mounter.cdiDevices = devices
+		mounter.cdiMounts = mounts
+		mounter.cdiEnv = env
+	}
+
+	subMounts, subFiles, err := subscriptionMounts(mounter.conf, mounter.spec.Mounts)
+	if err != nil {
+		return fmt.Errorf("resolving subscriptions for container: %w", err)
+	}
+	mounter.subscriptionMounts = subMounts
+	mounter.subscriptionFiles = subFiles
+	return nil
+}
+
+// applyExtraMounts applies the mounts and device nodes
+// resolveCDIAndSubscriptions resolved from the container's CDI device
+// requests, followed by any subscription/secret files resolved from
+// conf.SubscriptionsDir, so a "runsc run --device ..." flag, cdi.k8s.io/*
+// annotation, or configured subscriptions directory actually reaches the
+// running sandbox instead of being silently dropped. It's meant to be
+// called as part of the real containerMounter.mountAll (loader.go), after
+// spec.Mounts itself has been mounted through the sentry's VFS; it does
+// not mount spec.Mounts itself.
+func (m *cdiMounter) applyExtraMounts() error {
+	for _, cm := range m.cdiMounts {
+		if err := mountSubmount(m.rootFD, cm); err != nil {
+			return fmt.Errorf("mounting CDI-resolved mount %q: %w", cm.Destination, err)
+		}
+	}
+	for _, d := range m.cdiDevices {
+		if err := makeDeviceNode(m.rootFD, d); err != nil {
+			return fmt.Errorf("creating CDI-resolved device node: %w", err)
+		}
+	}
+
+	for i, sm := range m.subscriptionMounts {
+		if err := mountSubscription(m.rootFD, sm, m.subscriptionFiles[i]); err != nil {
+			return fmt.Errorf("mounting subscription %q: %w", sm.Destination, err)
+		}
+	}
+	return nil
+}
+
+// mountSubscription mounts a writable tmpfs at sm.Destination, writes f's
+// contents into it, then remounts it read-only per sm.Options. The
+// contents have to be written after the tmpfs is mounted (there's nowhere
+// to put them beforehand) but before sm's own "ro" option is applied, or
+// the write would fail with EROFS.
+func mountSubscription(rootFD int, sm specs.Mount, f subscriptionFile) error {
+	target, err := makeMountPoint(rootFD, sm.Destination, true)
+	if err != nil {
+		return fmt.Errorf("creating mount point: %w", err)
+	}
+	defer target.Close()
+
+	flags, data := parseMountOptions(stripOption(sm.Options, "ro"))
+	if err := mountAtPinnedTarget("tmpfs", "tmpfs", flags, data, target); err != nil {
+		return fmt.Errorf("mounting tmpfs: %w", err)
+	}
+
+	full := filepath.Join(fmt.Sprintf("/proc/self/fd/%d", rootFD), f.Destination)
+	if err := os.WriteFile(full, f.Contents, f.Mode); err != nil {
+		return fmt.Errorf("writing contents: %w", err)
+	}
+
+	if err := mountAtPinnedTarget("", "", unix.MS_REMOUNT|unix.MS_RDONLY, "", target); err != nil {
+		return fmt.Errorf("remounting read-only: %w", err)
+	}
+	return nil
+}
+
+// stripOption returns opts with every occurrence of remove filtered out.
+func stripOption(opts []string, remove string) []string {
+	out := make([]string, 0, len(opts))
+	for _, o := range opts {
+		if o != remove {
+			out = append(out, o)
+		}
+	}
+	return out
+}
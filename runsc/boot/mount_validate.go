@@ -0,0 +1,218 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// pinnedMountTarget is a host directory entry resolved and pinned by
+// pinMountTarget, ready to be used as the destination of a host mount(2)
+// call via its /proc/self/fd path. Keeping the open FD around for the
+// mount(2) call itself (rather than re-resolving destPath as a string)
+// closes the TOCTOU window a symlink swap could otherwise use to redirect
+// the mount outside the resolved directory: the same class of bug fixed in
+// runc as CVE-2021-30465.
+type pinnedMountTarget struct {
+	fd *os.File
+
+	// parentFD and name let verifyMountTargetUnchanged re-resolve the
+	// directory entry fd was opened from: fstat-ing fd itself always
+	// reports the inode fd was opened against, even if the name was since
+	// swapped to point elsewhere, so detecting a swap needs a fresh,
+	// NOFOLLOW lookup of name within parentFD instead.
+	parentFD int
+	name     string
+
+	dev uint64
+	ino uint64
+}
+
+// procSelfFD returns the /proc/self/fd path mount(2) should use as the
+// target instead of t's original string path.
+func (t *pinnedMountTarget) procSelfFD() string {
+	return fmt.Sprintf("/proc/self/fd/%d", t.fd.Fd())
+}
+
+// Close releases the pinned FD and its parent directory FD. Callers must
+// call this once the mount(2) call (or the decision not to make one) has
+// completed.
+func (t *pinnedMountTarget) Close() error {
+	err := t.fd.Close()
+	if cerr := unix.Close(t.parentFD); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// pinMountTarget resolves destPath component-by-component starting at
+// rootFD (an O_PATH descriptor for the container's resolved mount
+// namespace root), opening every intermediate component with
+// O_PATH|O_NOFOLLOW so that a symlink swapped in after resolution starts
+// can't be followed, and returns an O_PATH descriptor pinning the final
+// component. It rejects destPath if the walk would cross a symlink
+// (absolute or relative) or a ".." component that resolves above rootFD.
+//
+// destPath is a slash-separated path. A ".." component is walked like any
+// other while it stays below rootFD; only one that would climb above rootFD
+// is rejected.
+func pinMountTarget(rootFD int, destPath string) (*pinnedMountTarget, error) {
+	if strings.HasPrefix(destPath, "/") {
+		destPath = destPath[1:]
+	}
+	components := strings.Split(destPath, "/")
+
+	// dup rootFD so the walk can close intermediate FDs without affecting
+	// the caller's copy.
+	cur, err := unix.FcntlInt(uintptr(rootFD), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("duplicating mount namespace root FD: %w", err)
+	}
+	parent := -1
+	lastName := ""
+	depth := 0
+	for _, component := range components {
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if depth == 0 {
+				unix.Close(cur)
+				if parent >= 0 {
+					unix.Close(parent)
+				}
+				return nil, fmt.Errorf("mount destination %q escapes the resolved root via \"..\"", destPath)
+			}
+			depth--
+		default:
+			depth++
+		}
+
+		flags := unix.O_PATH | unix.O_NOFOLLOW | unix.O_CLOEXEC
+		next, err := unix.Openat(cur, component, flags, 0)
+		if err != nil {
+			unix.Close(cur)
+			if parent >= 0 {
+				unix.Close(parent)
+			}
+			if err == unix.ELOOP {
+				return nil, fmt.Errorf("mount destination %q crosses a symlink at component %q, rejecting to avoid a TOCTOU mount escape", destPath, component)
+			}
+			return nil, fmt.Errorf("resolving mount destination %q at component %q: %w", destPath, component, err)
+		}
+
+		// Reject a symlink disguised as a regular component:
+		// O_NOFOLLOW above already prevents Openat from following a
+		// symlink, but Fstat confirms what was actually opened rather than
+		// assuming ELOOP is the only way Openat signals one (some
+		// filesystems can return success with an O_PATH FD to a symlink
+		// itself when O_NOFOLLOW is set, which must not be walked into or
+		// mounted over).
+		var st unix.Stat_t
+		if err := unix.Fstat(next, &st); err != nil {
+			unix.Close(next)
+			unix.Close(cur)
+			if parent >= 0 {
+				unix.Close(parent)
+			}
+			return nil, fmt.Errorf("stat-ing resolved mount destination %q: %w", destPath, err)
+		}
+		if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+			unix.Close(next)
+			unix.Close(cur)
+			if parent >= 0 {
+				unix.Close(parent)
+			}
+			return nil, fmt.Errorf("mount destination %q crosses a symlink at component %q, rejecting to avoid a TOCTOU mount escape", destPath, component)
+		}
+
+		if parent >= 0 {
+			unix.Close(parent)
+		}
+		parent = cur
+		cur = next
+		lastName = component
+	}
+	if parent < 0 {
+		// destPath resolved to rootFD itself (all "." components).
+		parent = cur
+		cur, err = unix.Openat(parent, ".", unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err != nil {
+			unix.Close(parent)
+			return nil, fmt.Errorf("re-opening mount namespace root: %w", err)
+		}
+		lastName = "."
+	}
+
+	var finalStat unix.Stat_t
+	if err := unix.Fstat(cur, &finalStat); err != nil {
+		unix.Close(cur)
+		unix.Close(parent)
+		return nil, fmt.Errorf("stat-ing resolved mount destination %q: %w", destPath, err)
+	}
+	return &pinnedMountTarget{
+		fd:       os.NewFile(uintptr(cur), destPath),
+		parentFD: parent,
+		name:     lastName,
+		dev:      uint64(finalStat.Dev),
+		ino:      finalStat.Ino,
+	}, nil
+}
+
+// verifyMountTargetUnchanged re-resolves t.name within t.parentFD with a
+// fresh, symlink-rejecting lookup and confirms it still refers to the same
+// inode pinMountTarget originally resolved. Fstat-ing t.fd itself wouldn't
+// catch a swap: an open FD keeps referring to the inode it was opened
+// against no matter what the directory entry is later changed to point at,
+// so detecting the swap requires looking the name up again.
+func verifyMountTargetUnchanged(t *pinnedMountTarget) error {
+	fresh, err := unix.Openat(t.parentFD, t.name, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("re-resolving mount target %q: %w", t.name, err)
+	}
+	defer unix.Close(fresh)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fresh, &st); err != nil {
+		return fmt.Errorf("re-stat-ing mount target %q: %w", t.name, err)
+	}
+	if uint64(st.Dev) != t.dev || st.Ino != t.ino {
+		return fmt.Errorf("mount target %q changed underneath us: was dev=%d ino=%d, now dev=%d ino=%d", t.name, t.dev, t.ino, st.Dev, st.Ino)
+	}
+	return nil
+}
+
+// mountAtPinnedTarget performs a host mount(2) of source onto the
+// directory pinned by target, via target's /proc/self/fd path rather than
+// its original string destination, and confirms with
+// verifyMountTargetUnchanged that the directory entry wasn't swapped out
+// from under the resolved FD first.
+//
+// This is the low-level primitive mountSubmount and mountSubscription (in
+// mount_extras.go) route host-backed mount destinations through instead
+// of calling unix.Mount directly against a string path.
+func mountAtPinnedTarget(source, fstype string, flags uintptr, data string, target *pinnedMountTarget) error {
+	if err := verifyMountTargetUnchanged(target); err != nil {
+		return err
+	}
+	if err := unix.Mount(source, target.procSelfFD(), fstype, flags, data); err != nil {
+		return fmt.Errorf("mounting %q onto %q: %w", source, target.procSelfFD(), err)
+	}
+	return verifyMountTargetUnchanged(target)
+}
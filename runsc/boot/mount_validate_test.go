@@ -0,0 +1,115 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func openRootFD(t *testing.T, root string) int {
+	t.Helper()
+	fd, err := unix.Open(root, unix.O_PATH, 0)
+	if err != nil {
+		t.Fatalf("opening root %q: %v", root, err)
+	}
+	t.Cleanup(func() { unix.Close(fd) })
+	return fd
+}
+
+func TestPinMountTargetResolvesPlainDirectory(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(root+"/foo/bar", 0755); err != nil {
+		t.Fatal(err)
+	}
+	rootFD := openRootFD(t, root)
+
+	target, err := pinMountTarget(rootFD, "/foo/bar")
+	if err != nil {
+		t.Fatalf("pinMountTarget: %v", err)
+	}
+	defer target.Close()
+
+	var st unix.Stat_t
+	if err := unix.Stat(root+"/foo/bar", &st); err != nil {
+		t.Fatal(err)
+	}
+	if target.ino != st.Ino {
+		t.Errorf("pinned target ino %d, want %d", target.ino, st.Ino)
+	}
+}
+
+func TestPinMountTargetRejectsSymlinkComponent(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(root+"/foo", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", root+"/foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	rootFD := openRootFD(t, root)
+
+	if _, err := pinMountTarget(rootFD, "/foo/bar"); err == nil {
+		t.Fatalf("pinMountTarget succeeded on a path with a symlink component, want error")
+	}
+}
+
+func TestPinMountTargetRejectsDotDotEscape(t *testing.T) {
+	root := t.TempDir()
+	rootFD := openRootFD(t, root)
+
+	if _, err := pinMountTarget(rootFD, "/../etc"); err == nil {
+		t.Fatalf("pinMountTarget succeeded on a path escaping the root via \"..\", want error")
+	}
+}
+
+// TestPinMountTargetDetectsSymlinkSwappedAfterResolve reproduces the
+// CVE-2021-30465-style race this validator exists to close: /foo/bar is a
+// real directory when pinMountTarget resolves it, but gets replaced with a
+// symlink to a path outside root before the caller gets around to mounting
+// onto it. verifyMountTargetUnchanged must catch this rather than letting
+// mountAtPinnedTarget proceed against the swapped-in symlink's target.
+func TestPinMountTargetDetectsSymlinkSwappedAfterResolve(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(root+"/foo/bar", 0755); err != nil {
+		t.Fatal(err)
+	}
+	rootFD := openRootFD(t, root)
+
+	target, err := pinMountTarget(rootFD, "/foo/bar")
+	if err != nil {
+		t.Fatalf("pinMountTarget: %v", err)
+	}
+	defer target.Close()
+
+	// Simulate the race: something else removes the resolved directory
+	// and swaps in a symlink at the same path.
+	if err := os.Remove(root + "/foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", root+"/foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyMountTargetUnchanged(target); err == nil {
+		t.Fatalf("verifyMountTargetUnchanged did not detect the swapped mount target; a mount would have escaped the resolved root")
+	}
+
+	if err := mountAtPinnedTarget("none", "tmpfs", 0, "", target); err == nil {
+		t.Fatalf("mountAtPinnedTarget succeeded against a swapped mount target, want error")
+	}
+}
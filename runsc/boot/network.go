@@ -15,21 +15,29 @@
 package boot
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 
+	"github.com/containernetworking/cni/libcni"
+	cni100 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 	"gvisor.dev/gvisor/pkg/hostos"
 	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/socket/netfilter"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netfilter/nft"
 	"gvisor.dev/gvisor/pkg/sentry/socket/plugin"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/link/bridge"
 	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
 	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
 	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
@@ -75,6 +83,34 @@ type Network struct {
 	// PluginStack is a third-party network stack to use in place of
 	// netstack when non-nil.
 	PluginStack plugin.PluginStack
+
+	// mu protects nicIDs below. CreateLinksAndRoutes and the hot-plug
+	// methods (AddLink, RemoveLink, AddRoute, DelRoute, AddAddress,
+	// DelAddress) can all be invoked as urpc methods, so nothing guarantees
+	// they're serialized with each other.
+	mu sync.Mutex
+
+	// nicIDs maps a link's Name to the NICID CreateLinksAndRoutes or AddLink
+	// assigned it, so later hot-plug calls can address a link by the same
+	// name runsc already knows it by.
+	nicIDs map[string]tcpip.NICID
+
+	// routing is the dynamic routing daemon started by CreateLinksAndRoutes
+	// when args.Routing.Protocol isn't RoutingNone. nil otherwise.
+	routing *ripDaemon
+}
+
+// Destroy stops any dynamic routing daemon started by CreateLinksAndRoutes.
+// It's a no-op if RoutingConfig.Protocol was RoutingNone, and must be called
+// as part of sandbox/network teardown to avoid leaking the daemon's
+// goroutines.
+func (n *Network) Destroy() {
+	n.mu.Lock()
+	routing := n.routing
+	n.mu.Unlock()
+	if routing != nil {
+		routing.Stop()
+	}
 }
 
 // Route represents a route in the network stack.
@@ -120,6 +156,82 @@ type FDBasedLink struct {
 	ProcessorsPerChannel int
 }
 
+// SRIOVLink configures an fd-based link backed by an SR-IOV virtual
+// function, giving the sandbox a hardware queue instead of a veth/tap pair.
+// Unlike FDBasedLink, the underlying FD isn't passed in via FilePayload:
+// CreateLinksAndRoutes opens it itself, after programming and relocating the
+// VF.
+type SRIOVLink struct {
+	// PFName is the physical function's netdev name on the host (e.g.
+	// "eth0").
+	PFName string
+
+	// VFIndex selects which virtual function of PFName to use.
+	VFIndex int
+
+	Name        string
+	MTU         int
+	Addresses   []IPWithPrefix
+	Routes      []Route
+	LinkAddress net.HardwareAddr
+	Neighbors   []Neighbor
+
+	// VLAN configures an 802.1Q VLAN tag on the VF. Zero means untagged.
+	VLAN int
+
+	// SpoofCheck and Trust mirror `ip link set <pf> vf <idx> spoofchk/trust`.
+	SpoofCheck bool
+	Trust      bool
+
+	QDisc config.QueueingDiscipline
+
+	// ProcessorsPerChannel controls how many goroutines are used to handle
+	// packets on the VF's queue.
+	ProcessorsPerChannel int
+}
+
+// BridgeMember is a single port of a BridgeLink. It behaves like a
+// standalone FDBasedLink for the purpose of FD handling, but its frames
+// join the bridge's shared L2 domain instead of going to a NIC of their
+// own.
+type BridgeMember struct {
+	Name        string
+	MTU         int
+	LinkAddress net.HardwareAddr
+
+	// NumChannels is how many of CreateLinksAndRoutesArgs' FDs this member
+	// consumes, the same as FDBasedLink.NumChannels.
+	NumChannels int
+
+	// Forwarding, when false, disables flooding/forwarding to this port (STP
+	// blocking state) while the bridge still learns from and delivers BPDUs
+	// to it.
+	Forwarding bool
+}
+
+// BridgeLink configures an in-sentry L2 bridge aggregating multiple
+// fdbased member links into a single NIC, the way a host bridge(8) device
+// aggregates several host interfaces into one broadcast domain. Unlike
+// FDBasedLinks, a BridgeLink's Members never become NICs of their own: only
+// the bridge itself does, named Name. Route and address handling stays on
+// the bridge NIC.
+type BridgeLink struct {
+	Name        string
+	MTU         int
+	Addresses   []IPWithPrefix
+	Routes      []Route
+	LinkAddress net.HardwareAddr
+	Neighbors   []Neighbor
+	QDisc       config.QueueingDiscipline
+
+	// HandleSTP enables passive recognition of STP BPDUs, delivering them
+	// to the bridge NIC instead of flooding them like ordinary data frames,
+	// so a further STP implementation can process them.
+	HandleSTP bool
+
+	Members []BridgeMember
+}
+
 // BindOpt indicates whether the sentry or runsc process is responsible for
 // binding the AF_XDP socket.
 type BindOpt int
@@ -160,6 +272,35 @@ type LoopbackLink struct {
 	GVisorGRO bool
 }
 
+// CNINetworkArgs configures a single CNI ADD invocation used to populate the
+// addresses and routes of one of the FDBasedLinks below, instead of having
+// the caller compute and pass them directly. This lets runsc be driven by
+// standard CNI config lists (e.g. bridge + portmap + bandwidth + firewall
+// chains) the way Kubernetes/CRI setups expect, rather than requiring a shim
+// that pre-translates plugin output into our own types.
+type CNINetworkArgs struct {
+	// CNIVersion (e.g. "1.0.0") is only reported in error messages. The
+	// plugin chain runs at the cniVersion its ConfigList declares, and the
+	// result is always converted to CNI spec 1.0.0 before being translated
+	// into our own types.
+	CNIVersion string
+
+	// ConfigList is the raw CNI network configuration list JSON, as found
+	// under /etc/cni/net.d.
+	ConfigList []byte
+
+	// ContainerID and IfName identify this sandbox to the plugin chain, the
+	// same way they would identify a runc container invoking the same CNI
+	// config.
+	ContainerID string
+	IfName      string
+
+	// NetNSPath is the network namespace the ADD operation targets. It must
+	// already exist; CNI plugins move or create interfaces inside it, they
+	// don't create the namespace itself.
+	NetNSPath string
+}
+
 // CreateLinksAndRoutesArgs are arguments to CreateLinkAndRoutes.
 type CreateLinksAndRoutesArgs struct {
 	// FilePayload contains the fds associated with the FDBasedLinks. The
@@ -170,6 +311,19 @@ type CreateLinksAndRoutesArgs struct {
 	LoopbackLinks []LoopbackLink
 	FDBasedLinks  []FDBasedLink
 	XDPLinks      []XDPLink
+	SRIOVLinks    []SRIOVLink
+	BridgeLinks   []BridgeLink
+
+	// CNI, if non-nil, is run before FDBasedLinks are configured. Its
+	// result's addresses and routes are merged into the FDBasedLink whose
+	// Name matches CNI.IfName; CreateLinksAndRoutes fails if no such link
+	// exists.
+	CNI *CNINetworkArgs
+
+	// Routing, if its Protocol isn't RoutingNone, starts a dynamic routing
+	// daemon after links and static routes have been set up, to learn
+	// additional routes rather than requiring every route be known upfront.
+	Routing RoutingConfig
 
 	Defaultv4Gateway DefaultRoute
 	Defaultv6Gateway DefaultRoute
@@ -181,7 +335,10 @@ type CreateLinksAndRoutesArgs struct {
 	LogPackets bool
 
 	// NATBlob indicates whether FilePayload also contains an iptables NAT
-	// ruleset.
+	// ruleset. It's only applied once, at boot; use ApplyRuleset afterwards
+	// to install incremental iptables or nftables rules, e.g. to support
+	// `iptables -A` or kube-proxy's nftables mode against a running
+	// sandbox.
 	NATBlob bool
 
 	// DisconnectOk indicates that link endpoints should have the capability
@@ -252,16 +409,94 @@ func (n *Network) InitPluginStack(args *InitPluginStackArgs, _ *struct{}) error
 	})
 }
 
+// runCNI invokes the plugin chain described by args' ConfigList with ADD,
+// using the containernetworking/cni library conventions (spec version
+// negotiation, request/response over the plugins' stdin/stdout), and
+// translates the resulting CNI Result into the addresses and routes to
+// apply to the FDBasedLink named args.IfName.
+func runCNI(args *CNINetworkArgs) ([]IPWithPrefix, []Route, error) {
+	netList, err := libcni.ConfListFromBytes(args.ConfigList)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing CNI config list: %w", err)
+	}
+	cniConfig := libcni.NewCNIConfig(filepath.SplitList(os.Getenv("CNI_PATH")), nil)
+	rt := &libcni.RuntimeConf{
+		ContainerID: args.ContainerID,
+		NetNS:       args.NetNSPath,
+		IfName:      args.IfName,
+	}
+	res, err := cniConfig.AddNetworkList(context.Background(), netList, rt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("running CNI ADD for network list %q: %w", netList.Name, err)
+	}
+	result, err := cni100.NewResultFromResult(res)
+	if err != nil {
+		return nil, nil, fmt.Errorf("converting CNI result to spec version %s: %w", args.CNIVersion, err)
+	}
+	return cniResultToAddressesAndRoutes(result)
+}
+
+// cniResultToAddressesAndRoutes converts a CNI spec v1.0.x Result into the
+// address and route types CreateLinksAndRoutes already understands.
+func cniResultToAddressesAndRoutes(result *cni100.Result) ([]IPWithPrefix, []Route, error) {
+	if len(result.Interfaces) == 0 {
+		return nil, nil, fmt.Errorf("CNI result has no interfaces")
+	}
+	addrs := make([]IPWithPrefix, 0, len(result.IPs))
+	for _, ipCfg := range result.IPs {
+		prefixLen, _ := ipCfg.Address.Mask.Size()
+		addrs = append(addrs, IPWithPrefix{
+			Address:   ipCfg.Address.IP,
+			PrefixLen: prefixLen,
+		})
+	}
+	routes := make([]Route, 0, len(result.Routes))
+	for _, r := range result.Routes {
+		route := Route{Gateway: r.GW}
+		if r.Dst != nil {
+			route.Destination = *r.Dst
+		}
+		routes = append(routes, route)
+	}
+	return addrs, routes, nil
+}
+
 // CreateLinksAndRoutes creates links and routes in a network stack.  It should
-// only be called once.
+// only be called once. Afterwards, use AddLink/RemoveLink, AddRoute/DelRoute,
+// and AddAddress/DelAddress to attach, detach, or reconfigure interfaces on
+// the running sandbox.
 func (n *Network) CreateLinksAndRoutes(args *CreateLinksAndRoutesArgs, _ *struct{}) error {
 	if len(args.FDBasedLinks) > 0 && len(args.XDPLinks) > 0 {
 		return fmt.Errorf("received both fdbased and XDP links, but only one can be used at a time")
 	}
+	if args.CNI != nil {
+		addrs, cniRoutes, err := runCNI(args.CNI)
+		if err != nil {
+			return fmt.Errorf("running CNI plugins for interface %q: %w", args.CNI.IfName, err)
+		}
+		matched := false
+		for i := range args.FDBasedLinks {
+			if args.FDBasedLinks[i].Name != args.CNI.IfName {
+				continue
+			}
+			args.FDBasedLinks[i].Addresses = append(args.FDBasedLinks[i].Addresses, addrs...)
+			args.FDBasedLinks[i].Routes = append(args.FDBasedLinks[i].Routes, cniRoutes...)
+			matched = true
+			break
+		}
+		if !matched {
+			return fmt.Errorf("CNI result for interface %q did not match any FDBasedLink by name", args.CNI.IfName)
+		}
+	}
 	wantFDs := 0
 	for _, l := range args.FDBasedLinks {
 		wantFDs += l.NumChannels
 	}
+	for _, l := range args.BridgeLinks {
+		for _, m := range l.Members {
+			wantFDs += m.NumChannels
+		}
+	}
 	for _, link := range args.XDPLinks {
 		// We have to keep several FDs alive when the sentry is
 		// responsible for binding, but when runsc binds we only expect
@@ -517,6 +752,28 @@ func (n *Network) CreateLinksAndRoutes(args *CreateLinksAndRoutesArgs, _ *struct
 		}
 	}
 
+	for _, link := range args.BridgeLinks {
+		nicID, linkRoutes, newOffset, err := n.setupBridgeLink(link, args.FilePayload.Files, fdOffset)
+		if err != nil {
+			return fmt.Errorf("setting up bridge link %q: %w", link.Name, err)
+		}
+		fdOffset = newOffset
+		nicids[link.Name] = nicID
+		routes = append(routes, linkRoutes...)
+	}
+
+	for _, link := range args.SRIOVLinks {
+		if len(args.XDPLinks) > 0 {
+			return fmt.Errorf("SR-IOV links cannot be combined with XDP links")
+		}
+		nicID, linkRoutes, err := n.setupSRIOVLink(link)
+		if err != nil {
+			return fmt.Errorf("setting up SR-IOV link %q (PF %q VF %d): %w", link.Name, link.PFName, link.VFIndex, err)
+		}
+		nicids[link.Name] = nicID
+		routes = append(routes, linkRoutes...)
+	}
+
 	if !args.Defaultv4Gateway.Route.Empty() {
 		nicID, ok := nicids[args.Defaultv4Gateway.Name]
 		if !ok {
@@ -544,6 +801,17 @@ func (n *Network) CreateLinksAndRoutes(args *CreateLinksAndRoutesArgs, _ *struct
 	log.Infof("Setting routes %+v", routes)
 	n.Stack.SetRouteTable(routes)
 
+	n.mu.Lock()
+	n.nicIDs = nicids
+	var routingErr error
+	if args.Routing.Protocol != RoutingNone {
+		routingErr = n.startRouting(args.Routing, routes)
+	}
+	n.mu.Unlock()
+	if routingErr != nil {
+		return fmt.Errorf("starting dynamic routing: %w", routingErr)
+	}
+
 	// Set NAT table rules if necessary.
 	if args.NATBlob {
 		log.Infof("Replacing NAT table")
@@ -583,6 +851,569 @@ func (n *Network) createNICWithAddrs(id tcpip.NICID, ep stack.LinkEndpoint, opts
 	return nil
 }
 
+// AddLinkArgs are arguments to AddLink.
+type AddLinkArgs struct {
+	// FilePayload holds the link's FDs, the same way CreateLinksAndRoutesArgs
+	// does for FDBasedLinks. The number of FDs must match Link.NumChannels.
+	urpc.FilePayload
+
+	Link FDBasedLink
+}
+
+// AddLink creates a new fd-based NIC and attaches it to the already-running
+// stack. Unlike CreateLinksAndRoutes, which only ever runs once at boot,
+// AddLink may be called any number of times after that, to satisfy a CNI ADD
+// issued against a running sandbox, attach a secondary interface for
+// Kubernetes multi-network, or reattach an interface after live migration.
+func (n *Network) AddLink(args *AddLinkArgs, _ *struct{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	link := args.Link
+	if _, ok := n.nicIDs[link.Name]; ok {
+		return fmt.Errorf("interface %q already exists", link.Name)
+	}
+	if got, want := len(args.FilePayload.Files), link.NumChannels; got != want {
+		return fmt.Errorf("args.FilePayload.Files has %d FDs but link %q needs %d", got, link.Name, want)
+	}
+
+	FDs := make([]int, 0, link.NumChannels)
+	for i := 0; i < link.NumChannels; i++ {
+		oldFD := args.FilePayload.Files[i].Fd()
+		newFD, err := unix.Dup(int(oldFD))
+		if err != nil {
+			return fmt.Errorf("failed to dup FD %v: %v", oldFD, err)
+		}
+		FDs = append(FDs, newFD)
+	}
+
+	mac := tcpip.LinkAddress(link.LinkAddress)
+	linkEP, err := fdbased.New(&fdbased.Options{
+		FDs:                  FDs,
+		MTU:                  uint32(link.MTU),
+		EthernetHeader:       mac != "",
+		Address:              mac,
+		PacketDispatchMode:   fdbased.RecvMMsg,
+		GSOMaxSize:           link.GSOMaxSize,
+		GVisorGSOEnabled:     link.GVisorGSOEnabled,
+		TXChecksumOffload:    link.TXChecksumOffload,
+		RXChecksumOffload:    link.RXChecksumOffload,
+		GRO:                  link.GVisorGRO,
+		ProcessorsPerChannel: link.ProcessorsPerChannel,
+	})
+	if err != nil {
+		return err
+	}
+
+	var qDisc stack.QueueingDiscipline
+	switch link.QDisc {
+	case config.QDiscNone:
+	case config.QDiscFIFO:
+		log.Infof("Enabling FIFO QDisc on %q", link.Name)
+		qDisc = fifo.New(linkEP, runtime.GOMAXPROCS(0), 1000)
+	}
+
+	nicID := n.Stack.NextNICID()
+	log.Infof("Hot-adding interface %q with id %d on addresses %+v", link.Name, nicID, link.Addresses)
+	opts := stack.NICOptions{
+		Name:               link.Name,
+		QDisc:              qDisc,
+		DeliverLinkPackets: true,
+	}
+	if err := n.createNICWithAddrs(nicID, linkEP, opts, link.Addresses); err != nil {
+		return err
+	}
+
+	var routes []tcpip.Route
+	for _, r := range link.Routes {
+		route, err := r.toTcpipRoute(nicID)
+		if err != nil {
+			return err
+		}
+		routes = append(routes, route)
+	}
+	for _, neigh := range link.Neighbors {
+		proto, tcpipAddr := ipToAddressAndProto(neigh.IP)
+		n.Stack.AddStaticNeighbor(nicID, proto, tcpipAddr, tcpip.LinkAddress(neigh.HardwareAddr))
+	}
+	if len(routes) > 0 {
+		n.Stack.SetRouteTable(append(n.Stack.GetRouteTable(), routes...))
+	}
+
+	if n.nicIDs == nil {
+		n.nicIDs = make(map[string]tcpip.NICID)
+	}
+	n.nicIDs[link.Name] = nicID
+	return nil
+}
+
+// RemoveLinkArgs are arguments to RemoveLink.
+type RemoveLinkArgs struct {
+	// Name is the link's name, as given in the FDBasedLink or AddLinkArgs
+	// that created it.
+	Name string
+}
+
+// RemoveLink detaches and destroys the NIC named by args.Name, along with
+// every route that pointed at it.
+func (n *Network) RemoveLink(args *RemoveLinkArgs, _ *struct{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nicID, ok := n.nicIDs[args.Name]
+	if !ok {
+		return fmt.Errorf("no such interface %q", args.Name)
+	}
+	if err := n.Stack.RemoveNIC(nicID); err != nil {
+		return fmt.Errorf("removing NIC %q (id %d): %w", args.Name, nicID, err)
+	}
+	delete(n.nicIDs, args.Name)
+
+	var kept []tcpip.Route
+	for _, r := range n.Stack.GetRouteTable() {
+		if r.NIC != nicID {
+			kept = append(kept, r)
+		}
+	}
+	n.Stack.SetRouteTable(kept)
+
+	if n.routing != nil {
+		n.routing.interfaceDown(nicID)
+	}
+	return nil
+}
+
+// AddRouteArgs are arguments to AddRoute.
+type AddRouteArgs struct {
+	// Name is the interface the route should be installed against.
+	Name  string
+	Route Route
+}
+
+// AddRoute installs a single additional route against an existing interface,
+// leaving the rest of the route table untouched.
+func (n *Network) AddRoute(args *AddRouteArgs, _ *struct{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nicID, ok := n.nicIDs[args.Name]
+	if !ok {
+		return fmt.Errorf("invalid interface name %q for route", args.Name)
+	}
+	route, err := args.Route.toTcpipRoute(nicID)
+	if err != nil {
+		return err
+	}
+	n.Stack.SetRouteTable(append(n.Stack.GetRouteTable(), route))
+	return nil
+}
+
+// DelRouteArgs are arguments to DelRoute.
+type DelRouteArgs struct {
+	// Name is the interface the route was installed against.
+	Name  string
+	Route Route
+}
+
+// DelRoute removes a single route previously installed by CreateLinksAndRoutes
+// or AddRoute against an existing interface, leaving the rest of the route
+// table untouched.
+func (n *Network) DelRoute(args *DelRouteArgs, _ *struct{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nicID, ok := n.nicIDs[args.Name]
+	if !ok {
+		return fmt.Errorf("invalid interface name %q for route", args.Name)
+	}
+	want, err := args.Route.toTcpipRoute(nicID)
+	if err != nil {
+		return err
+	}
+	var kept []tcpip.Route
+	removed := false
+	for _, r := range n.Stack.GetRouteTable() {
+		if !removed && r == want {
+			removed = true
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if !removed {
+		return fmt.Errorf("no matching route %+v on interface %q", args.Route, args.Name)
+	}
+	n.Stack.SetRouteTable(kept)
+	return nil
+}
+
+// AddAddressArgs are arguments to AddAddress.
+type AddAddressArgs struct {
+	// Name is the interface to add the address to.
+	Name    string
+	Address IPWithPrefix
+}
+
+// AddAddress adds a single address to an existing interface.
+func (n *Network) AddAddress(args *AddAddressArgs, _ *struct{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nicID, ok := n.nicIDs[args.Name]
+	if !ok {
+		return fmt.Errorf("no such interface %q", args.Name)
+	}
+	proto, tcpipAddr := ipToAddressAndProto(args.Address.Address)
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol: proto,
+		AddressWithPrefix: tcpip.AddressWithPrefix{
+			Address:   tcpipAddr,
+			PrefixLen: args.Address.PrefixLen,
+		},
+	}
+	if err := n.Stack.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+		return fmt.Errorf("AddProtocolAddress(%d, %+v, {}) failed: %s", nicID, protocolAddr, err)
+	}
+	return nil
+}
+
+// DelAddressArgs are arguments to DelAddress.
+type DelAddressArgs struct {
+	// Name is the interface to remove the address from.
+	Name    string
+	Address IPWithPrefix
+}
+
+// DelAddress removes a single address from an existing interface.
+func (n *Network) DelAddress(args *DelAddressArgs, _ *struct{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nicID, ok := n.nicIDs[args.Name]
+	if !ok {
+		return fmt.Errorf("no such interface %q", args.Name)
+	}
+	_, tcpipAddr := ipToAddressAndProto(args.Address.Address)
+	if err := n.Stack.RemoveAddress(nicID, tcpipAddr); err != nil {
+		return fmt.Errorf("RemoveAddress(%d, %s) failed: %s", nicID, tcpipAddr, err)
+	}
+	return nil
+}
+
+// setupBridgeLink builds an fdbased endpoint for each of link's Members,
+// combines them into a single bridge.Endpoint, and brings that endpoint up
+// as the NIC named link.Name. It returns the new NIC's ID, the routes
+// collected from link.Routes, and the fdOffset into files after consuming
+// every member's FDs.
+func (n *Network) setupBridgeLink(link BridgeLink, files []*os.File, fdOffset int) (tcpip.NICID, []tcpip.Route, int, error) {
+	ports := make([]*bridge.Port, 0, len(link.Members))
+	for _, m := range link.Members {
+		FDs := make([]int, 0, m.NumChannels)
+		for j := 0; j < m.NumChannels; j++ {
+			oldFD := files[fdOffset].Fd()
+			newFD, err := unix.Dup(int(oldFD))
+			if err != nil {
+				return 0, nil, fdOffset, fmt.Errorf("failed to dup FD %v for bridge member %q: %v", oldFD, m.Name, err)
+			}
+			FDs = append(FDs, newFD)
+			fdOffset++
+		}
+
+		mac := tcpip.LinkAddress(m.LinkAddress)
+		memberEP, err := fdbased.New(&fdbased.Options{
+			FDs:                FDs,
+			MTU:                uint32(m.MTU),
+			EthernetHeader:     mac != "",
+			Address:            mac,
+			PacketDispatchMode: fdbased.RecvMMsg,
+		})
+		if err != nil {
+			return 0, nil, fdOffset, fmt.Errorf("creating endpoint for bridge member %q: %w", m.Name, err)
+		}
+		ports = append(ports, &bridge.Port{
+			Endpoint:   memberEP,
+			Name:       m.Name,
+			Forwarding: m.Forwarding,
+		})
+	}
+
+	mac := tcpip.LinkAddress(link.LinkAddress)
+	var linkEP stack.LinkEndpoint = bridge.New(ports, bridge.Options{
+		MTU:       uint32(link.MTU),
+		Address:   mac,
+		HandleSTP: link.HandleSTP,
+	})
+
+	var qDisc stack.QueueingDiscipline
+	switch link.QDisc {
+	case config.QDiscNone:
+	case config.QDiscFIFO:
+		log.Infof("Enabling FIFO QDisc on %q", link.Name)
+		qDisc = fifo.New(linkEP, runtime.GOMAXPROCS(0), 1000)
+	}
+
+	nicID := n.Stack.NextNICID()
+	log.Infof("Enabling bridge interface %q with id %d on addresses %+v aggregating %d members", link.Name, nicID, link.Addresses, len(link.Members))
+	opts := stack.NICOptions{
+		Name:               link.Name,
+		QDisc:              qDisc,
+		DeliverLinkPackets: true,
+	}
+	if err := n.createNICWithAddrs(nicID, linkEP, opts, link.Addresses); err != nil {
+		return 0, nil, fdOffset, err
+	}
+
+	var routes []tcpip.Route
+	for _, r := range link.Routes {
+		route, err := r.toTcpipRoute(nicID)
+		if err != nil {
+			return 0, nil, fdOffset, err
+		}
+		routes = append(routes, route)
+	}
+	for _, neigh := range link.Neighbors {
+		proto, tcpipAddr := ipToAddressAndProto(neigh.IP)
+		n.Stack.AddStaticNeighbor(nicID, proto, tcpipAddr, tcpip.LinkAddress(neigh.HardwareAddr))
+	}
+	return nicID, routes, fdOffset, nil
+}
+
+// RulesetFamily selects which netfilter address family a ruleset applies
+// to.
+type RulesetFamily int
+
+const (
+	// IPv4Ruleset selects the iptables family.
+	IPv4Ruleset RulesetFamily = iota
+	// IPv6Ruleset selects the ip6tables family.
+	IPv6Ruleset
+)
+
+// RulesetFormat selects the wire format a ruleset blob is encoded in.
+type RulesetFormat int
+
+const (
+	// IPTablesFormat is the same ip(6)tables-replace wire format NATBlob
+	// already carries at boot, understood natively by
+	// pkg/sentry/socket/netfilter.
+	IPTablesFormat RulesetFormat = iota
+
+	// NFTablesFormat is the nft(8) binary format carried over an
+	// NFNL_SUBSYS_NFTABLES netlink batch, translated by
+	// pkg/sentry/socket/netfilter/nft before being applied.
+	NFTablesFormat
+)
+
+// RulesetChannel is a single incremental ruleset update. It generalizes the
+// boot-time, one-shot NATBlob FD into something ApplyRuleset can carry any
+// number of times after CreateLinksAndRoutes has already run.
+type RulesetChannel struct {
+	Family RulesetFamily
+	Format RulesetFormat
+
+	// Table names the table being replaced (e.g. "nat", "filter" for
+	// iptables; a user-chosen name for nftables).
+	Table string
+
+	// Blob is the ruleset itself, encoded per Format.
+	Blob []byte
+}
+
+// ApplyRulesetArgs are arguments to ApplyRuleset.
+type ApplyRulesetArgs struct {
+	RulesetChannel
+}
+
+// ApplyRuleset installs args.Blob as args.Table's ruleset, translating from
+// nftables to the iptables-replace format pkg/sentry/socket/netfilter
+// understands when args.Format is NFTablesFormat. Unlike the boot-time
+// NATBlob, it may be called any number of times after CreateLinksAndRoutes.
+func (n *Network) ApplyRuleset(args *ApplyRulesetArgs, _ *struct{}) error {
+	blob := args.Blob
+	if args.Format == NFTablesFormat {
+		translated, err := nft.Translate(args.Blob)
+		if err != nil {
+			return fmt.Errorf("translating nftables ruleset for table %q: %w", args.Table, err)
+		}
+		blob = translated
+	}
+	if err := netfilter.SetEntries(n.Kernel.RootUserNamespace(), n.Stack, blob, args.Family == IPv6Ruleset); err != nil {
+		return fmt.Errorf("applying ruleset to table %q: %w", args.Table, err)
+	}
+	return nil
+}
+
+// DumpRulesetArgs are arguments to DumpRuleset.
+type DumpRulesetArgs struct {
+	Family RulesetFamily
+	Format RulesetFormat
+	Table  string
+}
+
+// DumpRulesetResult is the result of DumpRuleset.
+type DumpRulesetResult struct {
+	Blob []byte
+}
+
+// DumpRuleset retrieves args.Table's current ruleset, translating it to
+// nftables format first if args.Format is NFTablesFormat.
+func (n *Network) DumpRuleset(args *DumpRulesetArgs, result *DumpRulesetResult) error {
+	blob, err := netfilter.GetEntries(n.Kernel.RootUserNamespace(), n.Stack, args.Table, args.Family == IPv6Ruleset)
+	if err != nil {
+		return fmt.Errorf("dumping ruleset for table %q: %w", args.Table, err)
+	}
+	if args.Format == NFTablesFormat {
+		translated, err := nft.TranslateToNFTables(blob)
+		if err != nil {
+			return fmt.Errorf("translating table %q to nftables format: %w", args.Table, err)
+		}
+		blob = translated
+	}
+	result.Blob = blob
+	return nil
+}
+
+// setupSRIOVLink programs link's virtual function, moves it into this
+// process's network namespace, and wires it up as an fdbased NIC with
+// checksum and GSO offloads enabled by default, since VFs handle those in
+// hardware.
+func (n *Network) setupSRIOVLink(link SRIOVLink) (tcpip.NICID, []tcpip.Route, error) {
+	if err := configureSRIOVVF(link); err != nil {
+		return 0, nil, err
+	}
+	vfName, err := sriovVFNetdevName(link.PFName, link.VFIndex)
+	if err != nil {
+		return 0, nil, err
+	}
+	vf, err := netlink.LinkByName(vfName)
+	if err != nil {
+		return 0, nil, fmt.Errorf("looking up VF netdev %q: %w", vfName, err)
+	}
+	if err := netlink.LinkSetNsPid(vf, os.Getpid()); err != nil {
+		return 0, nil, fmt.Errorf("moving VF netdev %q into this namespace: %w", vfName, err)
+	}
+	fd, err := bindRawSocketToInterface(vfName)
+	if err != nil {
+		return 0, nil, fmt.Errorf("binding raw socket to VF netdev %q: %w", vfName, err)
+	}
+
+	mac := tcpip.LinkAddress(link.LinkAddress)
+	linkEP, err := fdbased.New(&fdbased.Options{
+		FDs:                  []int{fd},
+		MTU:                  uint32(link.MTU),
+		EthernetHeader:       mac != "",
+		Address:              mac,
+		PacketDispatchMode:   fdbased.RecvMMsg,
+		GVisorGSOEnabled:     true,
+		TXChecksumOffload:    true,
+		RXChecksumOffload:    true,
+		ProcessorsPerChannel: link.ProcessorsPerChannel,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var qDisc stack.QueueingDiscipline
+	switch link.QDisc {
+	case config.QDiscNone:
+	case config.QDiscFIFO:
+		log.Infof("Enabling FIFO QDisc on %q", link.Name)
+		qDisc = fifo.New(linkEP, runtime.GOMAXPROCS(0), 1000)
+	}
+
+	nicID := n.Stack.NextNICID()
+	log.Infof("Enabling SR-IOV interface %q with id %d on addresses %+v (PF %q VF %d)", link.Name, nicID, link.Addresses, link.PFName, link.VFIndex)
+	opts := stack.NICOptions{
+		Name:               link.Name,
+		QDisc:              qDisc,
+		DeliverLinkPackets: true,
+	}
+	if err := n.createNICWithAddrs(nicID, linkEP, opts, link.Addresses); err != nil {
+		return 0, nil, err
+	}
+
+	var routes []tcpip.Route
+	for _, r := range link.Routes {
+		route, err := r.toTcpipRoute(nicID)
+		if err != nil {
+			return 0, nil, err
+		}
+		routes = append(routes, route)
+	}
+	for _, neigh := range link.Neighbors {
+		proto, tcpipAddr := ipToAddressAndProto(neigh.IP)
+		n.Stack.AddStaticNeighbor(nicID, proto, tcpipAddr, tcpip.LinkAddress(neigh.HardwareAddr))
+	}
+	return nicID, routes, nil
+}
+
+// configureSRIOVVF programs the VF's MAC, VLAN, spoof-check, and trust
+// settings on its PF through netlink, the same way `ip link set <pf> vf
+// <idx> ...` would.
+func configureSRIOVVF(link SRIOVLink) error {
+	pf, err := netlink.LinkByName(link.PFName)
+	if err != nil {
+		return fmt.Errorf("looking up PF %q: %w", link.PFName, err)
+	}
+	if len(link.LinkAddress) > 0 {
+		if err := netlink.LinkSetVfHardwareAddr(pf, link.VFIndex, link.LinkAddress); err != nil {
+			return fmt.Errorf("setting VF %d MAC on PF %q: %w", link.VFIndex, link.PFName, err)
+		}
+	}
+	if link.VLAN != 0 {
+		if err := netlink.LinkSetVfVlan(pf, link.VFIndex, link.VLAN); err != nil {
+			return fmt.Errorf("setting VF %d VLAN on PF %q: %w", link.VFIndex, link.PFName, err)
+		}
+	}
+	if err := netlink.LinkSetVfSpoofchk(pf, link.VFIndex, link.SpoofCheck); err != nil {
+		return fmt.Errorf("setting VF %d spoofchk on PF %q: %w", link.VFIndex, link.PFName, err)
+	}
+	if err := netlink.LinkSetVfTrust(pf, link.VFIndex, link.Trust); err != nil {
+		return fmt.Errorf("setting VF %d trust on PF %q: %w", link.VFIndex, link.PFName, err)
+	}
+	return nil
+}
+
+// sriovVFNetdevName finds the netdev name the kernel assigned to a PF's
+// virtual function, by way of /sys/class/net/<pf>/device/virtfn<idx>/net.
+func sriovVFNetdevName(pfName string, vfIndex int) (string, error) {
+	dir := fmt.Sprintf("/sys/class/net/%s/device/virtfn%d/net", pfName, vfIndex)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("listing %q: %w", dir, err)
+	}
+	if len(entries) != 1 {
+		return "", fmt.Errorf("PF %q VF %d has %d netdevs under %q, want exactly 1", pfName, vfIndex, len(entries), dir)
+	}
+	return entries[0].Name(), nil
+}
+
+// bindRawSocketToInterface opens an AF_PACKET socket bound to name, for use
+// as an fdbased endpoint's underlying FD.
+func bindRawSocketToInterface(name string) (int, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return -1, fmt.Errorf("looking up interface %q: %w", name, err)
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return -1, fmt.Errorf("creating AF_PACKET socket: %w", err)
+	}
+	sa := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ALL),
+		Ifindex:  iface.Index,
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("binding to interface %q (index %d): %w", name, iface.Index, err)
+	}
+	return fd, nil
+}
+
+// htons converts a 16-bit value from host to network byte order.
+func htons(v uint16) uint16 {
+	return (v << 8) | (v >> 8)
+}
+
 // ipToAddressAndProto converts IP to tcpip.Address and a protocol number.
 //
 // Note: don't use 'len(ip)' to determine IP version because length is always 16.
@@ -0,0 +1,44 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import "gvisor.dev/gvisor/pkg/urpc"
+
+// RestoreOpts is the argument to containerManager.Restore (ContMgrRestore).
+// It carries the state file(s) the sentry should restore from, along with
+// enough information about how they're encoded to decode them correctly.
+type RestoreOpts struct {
+	urpc.FilePayload
+
+	// Background indicates that the caller will wait on
+	// ContMgrWaitRestore rather than block until the restore completes.
+	Background bool
+
+	// HavePagesFile indicates that FilePayload's second and third files
+	// are the pages metadata and pages files, rather than just a single
+	// combined state file.
+	HavePagesFile bool
+
+	// HaveDeviceFile indicates that FilePayload carries an extra file for
+	// the platform's device FD (e.g. /dev/kvm), appended after the state
+	// (and, if present, pages) files.
+	HaveDeviceFile bool
+
+	// ArchiveCompression is the codec the state file as a whole was
+	// wrapped in when it was written, sniffed from its header by
+	// DetectCheckpointCompression. The sentry needs this to decompress
+	// the stream before parsing it as a state file.
+	ArchiveCompression CheckpointCompression
+}
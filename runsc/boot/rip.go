@@ -0,0 +1,499 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// RoutingProtocol selects a dynamic routing protocol for Network to run
+// alongside the static routes passed to CreateLinksAndRoutes.
+type RoutingProtocol int
+
+const (
+	// RoutingNone runs no dynamic routing; the route table only ever
+	// contains what CreateLinksAndRoutesArgs and the AddRoute/DelRoute urpc
+	// methods explicitly install.
+	RoutingNone RoutingProtocol = iota
+
+	// RoutingRIPv2 runs a RIPv2 (RFC 2453) daemon in-sentry, advertising and
+	// learning routes over the configured interfaces.
+	RoutingRIPv2
+)
+
+// RoutingInterfaceConfig configures dynamic routing on a single interface.
+type RoutingInterfaceConfig struct {
+	// Name is the interface's name, as given to CreateLinksAndRoutesArgs.
+	Name string
+
+	// Cost is the RIP metric charged to routes learned through this
+	// interface. Must be between 1 and ripInfinity-1. The daemon advertises
+	// only routes it has learned; this interface's static and directly
+	// connected routes are not advertised.
+	Cost int
+}
+
+// RoutingConfig selects and configures a dynamic routing protocol.
+type RoutingConfig struct {
+	Protocol   RoutingProtocol
+	Interfaces []RoutingInterfaceConfig
+}
+
+const (
+	ripPort             = 520
+	ripMulticastAddr    = "\xe0\x00\x00\x09" // 224.0.0.9
+	ripHeaderSize       = 4
+	ripEntrySize        = 20
+	ripMaxEntries       = 25
+	ripInfinity         = 16
+	ripCommandRequest   = 1
+	ripCommandResponse  = 2
+	ripVersion2         = 2
+	ripRouteExpiry      = 180 * time.Second
+	ripRouteGarbageWait = 60 * time.Second // Added to ripRouteExpiry: total 240s since last heard.
+	ripMinUpdateSeconds = 30
+	ripMaxUpdateSeconds = 60
+)
+
+// ripRouteKey identifies a destination in the RIP route database.
+type ripRouteKey struct {
+	dest tcpip.Address
+	mask tcpip.AddressMask
+}
+
+// ripRoute is a single entry in the RIP route database, learned from a
+// neighbor's advertisement.
+type ripRoute struct {
+	gateway   tcpip.Address
+	metric    int
+	viaNIC    tcpip.NICID
+	learnedAt time.Time
+	valid     bool
+}
+
+// ripDaemon implements a RIPv2 speaker that learns routes over one or more
+// NICs of a stack.Stack and periodically reconciles the learned routes into
+// the stack's route table alongside a fixed set of static routes.
+type ripDaemon struct {
+	netStack *stack.Stack
+	costs    map[tcpip.NICID]int
+	static   []tcpip.Route
+
+	mu     sync.Mutex
+	routes map[ripRouteKey]*ripRoute
+
+	triggerCh chan struct{}
+	stopCh    chan struct{}
+}
+
+// startRouting validates args, builds the NIC-cost map from the already
+// assigned n.nicIDs, and starts the routing daemon args.Protocol selects.
+// staticRoutes are preserved in the merged route table alongside whatever
+// the daemon learns.
+func (n *Network) startRouting(args RoutingConfig, staticRoutes []tcpip.Route) error {
+	if args.Protocol != RoutingRIPv2 {
+		return fmt.Errorf("unsupported routing protocol: %d", args.Protocol)
+	}
+
+	costs := make(map[tcpip.NICID]int, len(args.Interfaces))
+	for _, ifc := range args.Interfaces {
+		nicID, ok := n.nicIDs[ifc.Name]
+		if !ok {
+			return fmt.Errorf("invalid interface name %q for dynamic routing", ifc.Name)
+		}
+		if ifc.Cost <= 0 || ifc.Cost >= ripInfinity {
+			return fmt.Errorf("interface %q cost %d out of range [1, %d)", ifc.Name, ifc.Cost, ripInfinity)
+		}
+		costs[nicID] = ifc.Cost
+	}
+	if len(costs) == 0 {
+		return fmt.Errorf("no interfaces configured for dynamic routing")
+	}
+
+	d := &ripDaemon{
+		netStack:  n.Stack,
+		costs:     costs,
+		static:    staticRoutes,
+		routes:    make(map[ripRouteKey]*ripRoute),
+		triggerCh: make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+	}
+	n.routing = d
+	d.start()
+	return nil
+}
+
+// start launches the daemon's background goroutines: one receiver per
+// configured NIC, a send loop driving periodic and triggered
+// advertisements, and an expiry loop aging out stale routes.
+func (d *ripDaemon) start() {
+	for nicID := range d.costs {
+		go d.recvLoop(nicID)
+	}
+	go d.sendLoop()
+	go d.expiryLoop()
+}
+
+// Stop terminates the daemon's recvLoop, sendLoop, and expiryLoop goroutines
+// by closing stopCh. It must be called at most once.
+func (d *ripDaemon) Stop() {
+	close(d.stopCh)
+}
+
+// trigger requests an out-of-cycle advertisement on every interface, per
+// RFC 2453's triggered-update rule for routes whose metric changed.
+func (d *ripDaemon) trigger() {
+	select {
+	case d.triggerCh <- struct{}{}:
+	default:
+		// An advertisement is already pending; no need to queue another.
+	}
+}
+
+// interfaceDown invalidates every route learned through nicID, the same
+// way the expiry timer invalidates a route nothing re-advertised in time,
+// and triggers an immediate update so neighbors hear about it within one
+// advertisement cycle rather than waiting out the normal expiry.
+func (d *ripDaemon) interfaceDown(nicID tcpip.NICID) {
+	d.mu.Lock()
+	changed := false
+	for _, r := range d.routes {
+		if r.viaNIC == nicID && r.valid {
+			r.valid = false
+			r.metric = ripInfinity
+			changed = true
+		}
+	}
+	d.mu.Unlock()
+	if changed {
+		d.reconcile()
+		d.trigger()
+	}
+}
+
+// nextUpdateInterval returns a random interval in [30s, 60s), the jitter
+// RFC 2453 requires to avoid synchronized advertisement storms.
+func nextUpdateInterval() time.Duration {
+	return (ripMinUpdateSeconds + time.Duration(rand.Intn(ripMaxUpdateSeconds-ripMinUpdateSeconds))) * time.Second
+}
+
+// sendLoop advertises on every configured interface once at startup, again
+// every nextUpdateInterval, and immediately whenever trigger is called.
+func (d *ripDaemon) sendLoop() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case <-timer.C:
+			d.advertiseAll()
+			timer.Reset(nextUpdateInterval())
+		case <-d.triggerCh:
+			d.advertiseAll()
+		}
+	}
+}
+
+// expiryLoop periodically ages the route database: a route not re-heard
+// within ripRouteExpiry is marked invalid (advertised at ripInfinity, per
+// poisoned reverse) and removed entirely ripRouteGarbageWait after that.
+func (d *ripDaemon) expiryLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case <-ticker.C:
+			d.expireRoutes()
+		}
+	}
+}
+
+func (d *ripDaemon) expireRoutes() {
+	now := time.Now()
+	d.mu.Lock()
+	changed := false
+	for key, r := range d.routes {
+		age := now.Sub(r.learnedAt)
+		switch {
+		case age >= ripRouteExpiry+ripRouteGarbageWait:
+			delete(d.routes, key)
+			changed = true
+		case age >= ripRouteExpiry && r.valid:
+			r.valid = false
+			r.metric = ripInfinity
+			changed = true
+		}
+	}
+	d.mu.Unlock()
+	if changed {
+		d.reconcile()
+		d.trigger()
+	}
+}
+
+// bestRoutes returns the current winning route for every destination with
+// at least one valid entry, breaking ties between equal-cost routes
+// deterministically by (metric, learned-time) so the chosen route doesn't
+// flap between equally good candidates on every reconciliation.
+func (d *ripDaemon) bestRoutes() []tcpip.Route {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	type candidate struct {
+		key   ripRouteKey
+		route *ripRoute
+	}
+	byKey := make(map[ripRouteKey][]candidate)
+	for key, r := range d.routes {
+		if !r.valid {
+			continue
+		}
+		byKey[key] = append(byKey[key], candidate{key: key, route: r})
+	}
+
+	var out []tcpip.Route
+	for key, cands := range byKey {
+		sort.Slice(cands, func(i, j int) bool {
+			if cands[i].route.metric != cands[j].route.metric {
+				return cands[i].route.metric < cands[j].route.metric
+			}
+			return cands[i].route.learnedAt.Before(cands[j].route.learnedAt)
+		})
+		best := cands[0].route
+		subnet, err := tcpip.NewSubnet(key.dest, key.mask)
+		if err != nil {
+			continue
+		}
+		out = append(out, tcpip.Route{
+			Destination: subnet,
+			Gateway:     best.gateway,
+			NIC:         best.viaNIC,
+		})
+	}
+	return out
+}
+
+// reconcile installs the union of the daemon's static routes and its
+// current best learned routes into the stack's route table.
+func (d *ripDaemon) reconcile() {
+	merged := append([]tcpip.Route{}, d.static...)
+	merged = append(merged, d.bestRoutes()...)
+	log.Infof("RIP: reconciling route table with %d entries", len(merged))
+	d.netStack.SetRouteTable(merged)
+}
+
+// recvLoop joins the RIP multicast group on nicID and applies every
+// response packet received to the route database until the daemon stops.
+func (d *ripDaemon) recvLoop(nicID tcpip.NICID) {
+	var wq waiter.Queue
+	ep, err := d.netStack.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		log.Warningf("RIP: failed to create listening endpoint on NIC %d: %s", nicID, err)
+		return
+	}
+	defer ep.Close()
+
+	if err := ep.Bind(tcpip.FullAddress{NIC: nicID, Port: ripPort}); err != nil {
+		log.Warningf("RIP: failed to bind to port %d on NIC %d: %s", ripPort, nicID, err)
+		return
+	}
+	if err := ep.SetSockOptInt(tcpip.MulticastTTLOption, 1); err != nil {
+		log.Warningf("RIP: failed to set multicast TTL on NIC %d: %s", nicID, err)
+	}
+	if err := ep.SetSockOpt(&tcpip.AddMembershipOption{
+		NIC:           nicID,
+		MulticastAddr: tcpip.AddrFromSlice([]byte(ripMulticastAddr)),
+	}); err != nil {
+		log.Warningf("RIP: failed to join multicast group on NIC %d: %s", nicID, err)
+		return
+	}
+
+	waitEntry, notifyCh := waiter.NewChannelEntry(waiter.ReadableEvents)
+	wq.EventRegister(&waitEntry)
+	defer wq.EventUnregister(&waitEntry)
+
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		default:
+		}
+		var buf bytes.Buffer
+		if _, err := ep.Read(&buf, tcpip.ReadOptions{}); err != nil {
+			if _, ok := err.(*tcpip.ErrWouldBlock); ok {
+				select {
+				case <-d.stopCh:
+					return
+				case <-notifyCh:
+				}
+				continue
+			}
+			log.Warningf("RIP: read error on NIC %d: %s", nicID, err)
+			continue
+		}
+		d.handlePacket(nicID, buf.Bytes())
+	}
+}
+
+// handlePacket applies a single received RIP message's entries to the route
+// database, charging each one the configured cost of the receiving
+// interface, and skipping the daemon's own split-horizon-poisoned (metric
+// ripInfinity) self-advertisements.
+func (d *ripDaemon) handlePacket(nicID tcpip.NICID, data []byte) {
+	if len(data) < ripHeaderSize || data[0] != ripCommandResponse || data[1] != ripVersion2 {
+		return
+	}
+	cost := d.costs[nicID]
+	body := data[ripHeaderSize:]
+
+	d.mu.Lock()
+	changed := false
+	for off := 0; off+ripEntrySize <= len(body) && off/ripEntrySize < ripMaxEntries; off += ripEntrySize {
+		entry := body[off : off+ripEntrySize]
+		dest := tcpip.AddrFromSlice(entry[4:8])
+		mask := tcpip.MaskFromBytes(entry[8:12])
+		gateway := tcpip.AddrFromSlice(entry[12:16])
+		metric := int(binary.BigEndian.Uint32(entry[16:20]))
+
+		newMetric := metric + cost
+		if newMetric > ripInfinity {
+			newMetric = ripInfinity
+		}
+		key := ripRouteKey{dest: dest, mask: mask}
+		existing, ok := d.routes[key]
+		switch {
+		case !ok && newMetric < ripInfinity:
+			d.routes[key] = &ripRoute{gateway: gateway, metric: newMetric, viaNIC: nicID, learnedAt: time.Now(), valid: true}
+			changed = true
+		case ok && (existing.viaNIC == nicID || newMetric < existing.metric):
+			if existing.metric != newMetric || !existing.valid {
+				changed = true
+			}
+			existing.gateway = gateway
+			existing.metric = newMetric
+			existing.viaNIC = nicID
+			existing.learnedAt = time.Now()
+			existing.valid = newMetric < ripInfinity
+		}
+	}
+	d.mu.Unlock()
+
+	if changed {
+		d.reconcile()
+		d.trigger()
+	}
+}
+
+// advertiseAll sends a RIPv2 response on every configured interface,
+// applying split horizon with poisoned reverse: a route is advertised at
+// ripInfinity on the interface it was learned through, rather than omitted,
+// so neighbors immediately know not to route back through us.
+func (d *ripDaemon) advertiseAll() {
+	entries := d.snapshotEntries()
+	for nicID := range d.costs {
+		d.advertiseOn(nicID, entries)
+	}
+}
+
+type ripEntry struct {
+	dest    tcpip.Address
+	mask    tcpip.AddressMask
+	gateway tcpip.Address
+	metric  int
+	viaNIC  tcpip.NICID
+}
+
+func (d *ripDaemon) snapshotEntries() []ripEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]ripEntry, 0, len(d.routes))
+	for key, r := range d.routes {
+		out = append(out, ripEntry{dest: key.dest, mask: key.mask, gateway: r.gateway, metric: r.metric, viaNIC: r.viaNIC})
+	}
+	return out
+}
+
+func (d *ripDaemon) advertiseOn(nicID tcpip.NICID, entries []ripEntry) {
+	var wq waiter.Queue
+	ep, err := d.netStack.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		log.Warningf("RIP: failed to create sending endpoint on NIC %d: %s", nicID, err)
+		return
+	}
+	defer ep.Close()
+	if err := ep.Bind(tcpip.FullAddress{NIC: nicID, Port: ripPort}); err != nil {
+		log.Warningf("RIP: failed to bind sender on NIC %d: %s", nicID, err)
+		return
+	}
+
+	dst := tcpip.FullAddress{
+		NIC:  nicID,
+		Addr: tcpip.AddrFromSlice([]byte(ripMulticastAddr)),
+		Port: ripPort,
+	}
+
+	for start := 0; start < len(entries) || start == 0; start += ripMaxEntries {
+		end := start + ripMaxEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+		packet := encodeRIPResponse(entries[start:end], nicID)
+		if _, err := ep.Write(bytes.NewReader(packet), tcpip.WriteOptions{To: &dst}); err != nil {
+			log.Warningf("RIP: send failed on NIC %d: %s", nicID, err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+	}
+}
+
+// encodeRIPResponse builds a RIPv2 response packet out of entries,
+// poisoning (metric ripInfinity) any entry learned through outNIC per
+// split-horizon with poisoned reverse.
+func encodeRIPResponse(entries []ripEntry, outNIC tcpip.NICID) []byte {
+	buf := make([]byte, ripHeaderSize+len(entries)*ripEntrySize)
+	buf[0] = ripCommandResponse
+	buf[1] = ripVersion2
+
+	for i, e := range entries {
+		off := ripHeaderSize + i*ripEntrySize
+		metric := e.metric
+		if e.viaNIC == outNIC {
+			metric = ripInfinity
+		}
+		binary.BigEndian.PutUint16(buf[off:off+2], 2) // AFI for IP.
+		copy(buf[off+4:off+8], e.dest.AsSlice())
+		copy(buf[off+8:off+12], e.mask.AsSlice())
+		copy(buf[off+12:off+16], e.gateway.AsSlice())
+		binary.BigEndian.PutUint32(buf[off+16:off+20], uint32(metric))
+	}
+	return buf
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"fmt"
+	"os"
+)
+
+// RootfsBackendName identifies a RootfsBackend implementation. It's the
+// value of the --rootfs-backend flag.
+type RootfsBackendName string
+
+// The set of RootfsBackend implementations runsc knows about.
+const (
+	// GoferRootfsBackendName is the default: the caller (typically
+	// containerd's CRI shim) has already materialized the rootfs and hands
+	// Sandbox a bundle directory exported through a gofer, exactly as
+	// today.
+	GoferRootfsBackendName RootfsBackendName = "gofer"
+
+	// CStorageRootfsBackendName resolves an image reference directly
+	// through containers/storage, letting "runsc run image://..." work
+	// without a CRI shim in front of it.
+	CStorageRootfsBackendName RootfsBackendName = "cstorage"
+)
+
+// RootfsMount is the result of a RootfsBackend resolving an image
+// reference. Exactly one of HostPath or EROFSImage is set.
+type RootfsMount struct {
+	// HostPath is a directory to be exported to the sentry through the
+	// existing gofer path, unchanged from how runsc works today.
+	HostPath string
+
+	// EROFSImage, when non-nil, is a read-only layer stack the sentry can
+	// mount natively via pkg/sentry/fsimpl/erofs, skipping the gofer
+	// round-trip entirely for the read-only portion of the rootfs.
+	EROFSImage *os.File
+}
+
+// RootfsBackend resolves an OCI image reference into a RootfsMount that
+// Sandbox.New/StartSubcontainer can consume. Args.RootfsImage selects the
+// reference; Args.RootfsBackendName selects the implementation.
+type RootfsBackend interface {
+	// Name identifies the backend, for logging and Sandbox state.
+	Name() RootfsBackendName
+
+	// Prepare resolves imageRef (pulling it if necessary) and returns how
+	// to mount it. The caller must call Cleanup once the sandbox no longer
+	// needs it.
+	Prepare(imageRef string) (RootfsMount, error)
+
+	// Cleanup releases any resources Prepare allocated for imageRef, e.g.
+	// unmounting an overlay or releasing a storage layer reference.
+	Cleanup(imageRef string) error
+}
+
+// GoferRootfsBackend is the no-op RootfsBackend matching today's behavior:
+// it assumes the caller already produced a bundle directory and does
+// nothing further.
+type GoferRootfsBackend struct{}
+
+// Name implements RootfsBackend.Name.
+func (GoferRootfsBackend) Name() RootfsBackendName { return GoferRootfsBackendName }
+
+// Prepare implements RootfsBackend.Prepare.
+func (GoferRootfsBackend) Prepare(imageRef string) (RootfsMount, error) {
+	return RootfsMount{HostPath: imageRef}, nil
+}
+
+// Cleanup implements RootfsBackend.Cleanup.
+func (GoferRootfsBackend) Cleanup(imageRef string) error { return nil }
+
+// NewRootfsBackend constructs the RootfsBackend named by name.
+// storageRoot is the containers/storage graph root to use when name is
+// CStorageRootfsBackendName; it's ignored otherwise.
+func NewRootfsBackend(name RootfsBackendName, storageRoot string) (RootfsBackend, error) {
+	switch name {
+	case "", GoferRootfsBackendName:
+		return GoferRootfsBackend{}, nil
+	case CStorageRootfsBackendName:
+		return newCStorageRootfsBackend(storageRoot)
+	default:
+		return nil, fmt.Errorf("unknown rootfs backend %q", name)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/containers/storage"
+	"gvisor.dev/gvisor/pkg/log"
+)
+
+// cstorageRootfsBackend pulls and layers images through containers/storage,
+// rather than requiring the caller to have already materialized a bundle
+// directory. It's the implementation behind CStorageRootfsBackendName.
+type cstorageRootfsBackend struct {
+	store storage.Store
+
+	mu        sync.Mutex
+	mountedAt map[string]string // imageRef -> container ID, for Cleanup.
+}
+
+func newCStorageRootfsBackend(storageRoot string) (RootfsBackend, error) {
+	store, err := storage.GetStore(storage.StoreOptions{GraphRoot: storageRoot})
+	if err != nil {
+		return nil, fmt.Errorf("opening containers/storage store at %q: %w", storageRoot, err)
+	}
+	return &cstorageRootfsBackend{
+		store:     store,
+		mountedAt: make(map[string]string),
+	}, nil
+}
+
+// Name implements RootfsBackend.Name.
+func (*cstorageRootfsBackend) Name() RootfsBackendName { return CStorageRootfsBackendName }
+
+// Prepare implements RootfsBackend.Prepare. It pulls imageRef if it isn't
+// already present in the store, creates a container layer on top of its
+// image layers, and mounts it as a single overlay the gofer can export.
+//
+// A future iteration can return an EROFSImage built directly from the
+// image's read-only layers instead, letting the sentry mount it natively
+// and skip the gofer for the image portion of the overlay; today's
+// HostPath result keeps the existing gofer-backed flow working unchanged.
+func (b *cstorageRootfsBackend) Prepare(imageRef string) (RootfsMount, error) {
+	img, err := b.store.Image(imageRef)
+	if err != nil {
+		img, err = b.store.PullImage(imageRef)
+		if err != nil {
+			return RootfsMount{}, fmt.Errorf("pulling image %q: %w", imageRef, err)
+		}
+	}
+
+	container, err := b.store.CreateContainer("", nil, img.ID, "", "", nil)
+	if err != nil {
+		return RootfsMount{}, fmt.Errorf("creating container layer for image %q: %w", imageRef, err)
+	}
+
+	mountPath, err := b.store.Mount(container.ID, "")
+	if err != nil {
+		return RootfsMount{}, fmt.Errorf("mounting rootfs for image %q: %w", imageRef, err)
+	}
+
+	b.mu.Lock()
+	b.mountedAt[imageRef] = container.ID
+	b.mu.Unlock()
+
+	log.Infof("Prepared rootfs for image %q via containers/storage at %q", imageRef, mountPath)
+	return RootfsMount{HostPath: mountPath}, nil
+}
+
+// Cleanup implements RootfsBackend.Cleanup.
+func (b *cstorageRootfsBackend) Cleanup(imageRef string) error {
+	b.mu.Lock()
+	containerID, ok := b.mountedAt[imageRef]
+	delete(b.mountedAt, imageRef)
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if _, err := b.store.Unmount(containerID, false); err != nil {
+		return fmt.Errorf("unmounting rootfs for image %q: %w", imageRef, err)
+	}
+	if _, err := b.store.DeleteContainer(containerID); err != nil {
+		return fmt.Errorf("deleting container layer for image %q: %w", imageRef, err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/runsc/config"
+)
+
+// subscriptionFile is a single host file discovered under
+// config.Config.SubscriptionsDir that should be materialized inside the
+// sandbox, analogous to containers/common/pkg/subscriptions' handling of
+// paths like /usr/share/rhel/secrets. Only SubscriptionsDir is scanned;
+// /etc/containers/mounts.conf is not read.
+//
+// Unlike a regular OCI bind mount, a subscription's host fd is never handed
+// to the sandbox: mountAll is expected to write Contents into a tmpfs file
+// at Destination through the gofer connection, so the only thing that
+// crosses into the sandbox is the bytes themselves.
+type subscriptionFile struct {
+	// Destination is the absolute path the file should appear at inside
+	// the container, mirroring its path under the subscriptions directory
+	// (e.g. a file at $SubscriptionsDir/usr/share/rhel/secrets/foo mounts
+	// to /usr/share/rhel/secrets/foo).
+	Destination string
+	Mode        fs.FileMode
+	Contents    []byte
+}
+
+// scanSubscriptionsDir walks dir and returns a subscriptionFile for every
+// regular file found, with Destination set to the file's path relative to
+// dir, rooted at "/". It skips directories (mountAll creates the
+// intermediate directories itself when it materializes each file) and
+// returns an error for anything else that isn't a regular file, since a
+// subscription is never expected to be a device node, socket, or symlink.
+func scanSubscriptionsDir(dir string) ([]subscriptionFile, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	var files []subscriptionFile
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("walking subscriptions directory %q at %q: %w", dir, path, err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("subscription %q is not a regular file (mode %v); only regular files can be materialized as secrets", path, info.Mode())
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("computing subscription destination for %q: %w", path, err)
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading subscription %q: %w", path, err)
+		}
+		files = append(files, subscriptionFile{
+			Destination: filepath.Join("/", rel),
+			Mode:        info.Mode().Perm(),
+			Contents:    contents,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// subscriptionMounts resolves conf.SubscriptionsDir (if set) into the
+// []specs.Mount entries containerMounter.mountAll should fold in alongside
+// spec.Mounts, read-only and backed by tmpfs rather than a host bind mount.
+// Each returned specs.Mount has Type "tmpfs" and carries the file's
+// contents out-of-band (mountAll is expected to look the destination up in
+// the accompanying []subscriptionFile slice and write Contents through the
+// gofer connection once the tmpfs mount itself lands) rather than via the
+// Source field, since there's no host path left to bind once a secret's
+// been scanned into memory.
+//
+// specMounts is spec.Mounts, passed in purely so a collision between a
+// subscription's destination and a destination the container spec already
+// claims is caught here with a clear error instead of surfacing later as a
+// confusing "mount point already exists" failure deep inside mountAll.
+func subscriptionMounts(conf *config.Config, specMounts []specs.Mount) ([]specs.Mount, []subscriptionFile, error) {
+	files, err := scanSubscriptionsDir(conf.SubscriptionsDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("scanning subscriptions directory %q: %w", conf.SubscriptionsDir, err)
+	}
+	if len(files) == 0 {
+		return nil, nil, nil
+	}
+
+	claimed := make(map[string]struct{}, len(specMounts))
+	for _, m := range specMounts {
+		claimed[filepath.Clean(m.Destination)] = struct{}{}
+	}
+
+	mounts := make([]specs.Mount, 0, len(files))
+	for _, f := range files {
+		dest := filepath.Clean(f.Destination)
+		if _, ok := claimed[dest]; ok {
+			return nil, nil, fmt.Errorf("subscription destination %q collides with an existing mount in the container spec", dest)
+		}
+		claimed[dest] = struct{}{}
+		mounts = append(mounts, specs.Mount{
+			Destination: dest,
+			Type:        "tmpfs",
+			Options:     []string{"ro"},
+		})
+	}
+	return mounts, files, nil
+}
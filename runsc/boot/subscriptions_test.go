@@ -0,0 +1,105 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/runsc/config"
+)
+
+func writeSubscriptionFixture(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, "usr/share/rhel/secrets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "usr/share/rhel/secrets/ca.crt"), []byte("-----BEGIN CERTIFICATE-----"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "run-secrets-token"), []byte("s3cr3t"), 0400); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanSubscriptionsDir(t *testing.T) {
+	dir := t.TempDir()
+	writeSubscriptionFixture(t, dir)
+
+	files, err := scanSubscriptionsDir(dir)
+	if err != nil {
+		t.Fatalf("scanSubscriptionsDir: %v", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Destination < files[j].Destination })
+
+	want := []string{"/run-secrets-token", "/usr/share/rhel/secrets/ca.crt"}
+	if len(files) != len(want) {
+		t.Fatalf("scanSubscriptionsDir returned %d files, want %d: %+v", len(files), len(want), files)
+	}
+	for i, dest := range want {
+		if files[i].Destination != dest {
+			t.Errorf("files[%d].Destination = %q, want %q", i, files[i].Destination, dest)
+		}
+	}
+}
+
+func TestScanSubscriptionsDirEmptyWhenUnset(t *testing.T) {
+	files, err := scanSubscriptionsDir("")
+	if err != nil {
+		t.Fatalf("scanSubscriptionsDir: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("scanSubscriptionsDir(\"\") = %+v, want empty", files)
+	}
+}
+
+func TestSubscriptionMountsRejectsOverlapWithSpecMounts(t *testing.T) {
+	dir := t.TempDir()
+	writeSubscriptionFixture(t, dir)
+
+	conf := testConfig()
+	conf.SubscriptionsDir = dir
+	specMounts := []specs.Mount{
+		{Destination: "/run-secrets-token", Type: "bind", Source: "/host/other-token"},
+	}
+
+	if _, _, err := subscriptionMounts(conf, specMounts); err == nil {
+		t.Fatalf("subscriptionMounts succeeded despite a destination collision with spec.Mounts, want error")
+	}
+}
+
+func TestSubscriptionMountsNoCollision(t *testing.T) {
+	dir := t.TempDir()
+	writeSubscriptionFixture(t, dir)
+
+	conf := testConfig()
+	conf.SubscriptionsDir = dir
+
+	mounts, files, err := subscriptionMounts(conf, nil)
+	if err != nil {
+		t.Fatalf("subscriptionMounts: %v", err)
+	}
+	if len(mounts) != 2 || len(files) != 2 {
+		t.Fatalf("subscriptionMounts returned %d mounts, %d files, want 2 and 2", len(mounts), len(files))
+	}
+	for _, m := range mounts {
+		if m.Type != "tmpfs" {
+			t.Errorf("mount %+v has Type %q, want \"tmpfs\"", m, m.Type)
+		}
+	}
+}
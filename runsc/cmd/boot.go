@@ -0,0 +1,85 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd implements the "runsc boot" subcommand: the entrypoint
+// createSandboxProcess (runsc/sandbox) execs into to start a sandbox.
+package cmd
+
+import (
+	"context"
+	"flag"
+
+	"github.com/google/subcommands"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/boot"
+	"gvisor.dev/gvisor/runsc/sandbox"
+)
+
+// Boot implements subcommands.Command for the "boot" command.
+type Boot struct {
+	// apparmorProfile and selinuxLabel carry --apparmor-profile and
+	// --selinux-label, the LSM confinement createSandboxProcess resolved
+	// for this sandbox. Only this process can apply them to itself: both
+	// are per-thread attributes that must be set immediately before this
+	// process's own exec into the sentry.
+	apparmorProfile string
+	selinuxLabel    string
+
+	// hostSeccomp carries --host-seccomp, the enforcement mode for this
+	// process's own (host-facing) seccomp filter, separate from the
+	// guest-facing filter the sentry installs for itself once running.
+	hostSeccomp string
+}
+
+// Name implements subcommands.Command.Name.
+func (*Boot) Name() string { return "boot" }
+
+// Synopsis implements subcommands.Command.Synopsis.
+func (*Boot) Synopsis() string { return "launch a sandbox process" }
+
+// Usage implements subcommands.Command.Usage.
+func (*Boot) Usage() string { return "boot [flags] <container id>\n" }
+
+// SetFlags implements subcommands.Command.SetFlags.
+func (b *Boot) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&b.apparmorProfile, "apparmor-profile", "", "AppArmor profile to apply to this process before exec'ing into the sentry")
+	f.StringVar(&b.selinuxLabel, "selinux-label", "", "SELinux label to apply to this process before exec'ing into the sentry")
+	f.StringVar(&b.hostSeccomp, "host-seccomp", "off", "host process seccomp enforcement: off, audit, or enforce")
+}
+
+// Execute implements subcommands.Command.Execute. Host hardening (LSM
+// confinement, host-facing seccomp) is applied first, before this process
+// touches any application data, then the rest of sandbox boot (building
+// and running the sentry) follows in boot.New/Run.
+func (b *Boot) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitCode {
+	if err := sandbox.ApplyLSM(b.apparmorProfile, b.selinuxLabel); err != nil {
+		log.Warningf("Applying host LSM confinement: %v", err)
+		return subcommands.ExitFailure
+	}
+	if err := sandbox.InstallHostSeccomp(b.hostSeccomp); err != nil {
+		log.Warningf("Installing host seccomp filter: %v", err)
+		return subcommands.ExitFailure
+	}
+
+	l, err := boot.New(f.Args())
+	if err != nil {
+		log.Warningf("Creating sandbox: %v", err)
+		return subcommands.ExitFailure
+	}
+	if err := l.Run(); err != nil {
+		log.Warningf("Running sandbox: %v", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
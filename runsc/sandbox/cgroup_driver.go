@@ -0,0 +1,208 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+	"gvisor.dev/gvisor/pkg/sentry/control"
+)
+
+// CgroupDriver selects how the sandbox's cgroup control files are located
+// and updated: either as raw cgroupfs paths, or via the systemd transient
+// unit (slice/scope) that created them. It's the value of the
+// --cgroup-driver flag, mirroring containerd/CRI's SystemdCgroup option,
+// which the vast majority of Kubernetes installs set because kubelet
+// itself defaults to it.
+type CgroupDriver string
+
+// The supported --cgroup-driver values.
+const (
+	// CgroupDriverCgroupfs assumes the sandbox's cgroup is a plain
+	// cgroupfs directory; control files are read and written directly.
+	// This is the default.
+	CgroupDriverCgroupfs CgroupDriver = "cgroupfs"
+
+	// CgroupDriverSystemd means the sandbox's cgroup was created as a
+	// systemd transient unit (a "<slice>/<scope>" pair, e.g.
+	// "kubepods-burstable-pod123.slice/cri-containerd-abc.scope").
+	// Control files systemd enforces invariants over are written through
+	// org.freedesktop.systemd1.Manager.SetUnitProperties instead of a
+	// direct cgroupfs write, so systemd's view of the unit stays
+	// consistent with the kernel's.
+	CgroupDriverSystemd CgroupDriver = "systemd"
+)
+
+// ParseCgroupDriver validates the --cgroup-driver flag value.
+func ParseCgroupDriver(s string) (CgroupDriver, error) {
+	switch CgroupDriver(s) {
+	case "", CgroupDriverCgroupfs:
+		return CgroupDriverCgroupfs, nil
+	case CgroupDriverSystemd:
+		return CgroupDriverSystemd, nil
+	default:
+		return "", fmt.Errorf("invalid --cgroup-driver value %q: want one of cgroupfs, systemd", s)
+	}
+}
+
+// systemdUnitProperty maps the cgroupfs control file names that systemd
+// requires go through SetUnitProperties, rather than a direct write to the
+// control file it created, to their unit property name. Keys not in this
+// map are still written directly to the control file systemd set up,
+// which is safe for anything systemd itself doesn't track the value of.
+var systemdUnitProperty = map[string]string{
+	"memory.max": "MemoryMax",
+	"cpu.max":    "CPUQuotaPerSecUSec",
+	"pids.max":   "TasksMax",
+}
+
+// cgroupPather is the subset of cgroup.Cgroup that exposes its cgroupfs
+// path, needed to derive the systemd slice/scope pair when
+// CgroupDriverSystemd is configured. Cgroup implementations that don't
+// support it can leave it unimplemented; resolveSystemdUnit reports an
+// error in that case rather than silently falling back to a raw write.
+type cgroupPather interface {
+	Path() string
+}
+
+// resolveSystemdUnit splits cg's cgroupfs path into the slice and scope
+// unit names systemd created it under, e.g.
+// "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod123.slice/cri-containerd-abc.scope"
+// becomes ("kubepods-burstable-pod123.slice", "cri-containerd-abc.scope").
+func resolveSystemdUnit(cg cgroupPather) (slice, scope string, err error) {
+	parts := strings.Split(strings.Trim(cg.Path(), "/"), "/")
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("cgroup path %q doesn't look like a systemd unit hierarchy", cg.Path())
+	}
+	slice, scope = parts[len(parts)-2], parts[len(parts)-1]
+	if !strings.HasSuffix(slice, ".slice") || !strings.HasSuffix(scope, ".scope") {
+		return "", "", fmt.Errorf("cgroup path %q doesn't end in a <slice>/<scope> pair", cg.Path())
+	}
+	return slice, scope, nil
+}
+
+// cgroupsWriteViaSystemd writes value to file's systemd unit property prop
+// via SetUnitProperties, rather than writing file's cgroupfs control file
+// directly. This keeps systemd's own view of the unit's resource limits
+// consistent with the kernel's, which systemd would otherwise overwrite
+// the next time it reconciles the unit (e.g. on a daemon-reload).
+func (s *Sandbox) cgroupsWriteViaSystemd(file control.CgroupControlFile, prop, value string) error {
+	cg, ok := s.CgroupJSON.Cgroup.(cgroupPather)
+	if !ok {
+		return fmt.Errorf("cgroup driver is %q but sandbox %q's cgroup doesn't expose a cgroupfs path", CgroupDriverSystemd, s.ID)
+	}
+	_, scope, err := resolveSystemdUnit(cg)
+	if err != nil {
+		return fmt.Errorf("resolving systemd unit for %q: %w", file.Name, err)
+	}
+	variant, err := cgroupValueToVariant(prop, value)
+	if err != nil {
+		return fmt.Errorf("converting %s value %q for systemd unit %q: %w", prop, value, scope, err)
+	}
+	if err := setSystemdUnitProperty(scope, prop, variant); err != nil {
+		return fmt.Errorf("setting %s on systemd unit %q: %w", prop, scope, err)
+	}
+	return nil
+}
+
+// cgroupValueToVariant converts file's cgroupfs-style string value into the
+// dbus.Variant systemd's SetUnitProperties expects for the unit property
+// prop. MemoryMax and TasksMax are D-Bus "t" (uint64) properties, not
+// strings, and CPUQuotaPerSecUSec needs cpu.max's "$MAX $PERIOD" pair
+// collapsed into a single per-second microsecond quota rather than
+// forwarded as-is; passing the raw cgroupfs string through for any of
+// these fails with a D-Bus type-mismatch error.
+func cgroupValueToVariant(prop, value string) (dbus.Variant, error) {
+	switch prop {
+	case "MemoryMax", "TasksMax":
+		limit, err := parseCgroupLimit(value)
+		if err != nil {
+			return dbus.Variant{}, err
+		}
+		return dbus.MakeVariant(limit), nil
+	case "CPUQuotaPerSecUSec":
+		quotaPerSecUSec, err := parseCPUMaxQuotaPerSec(value)
+		if err != nil {
+			return dbus.Variant{}, err
+		}
+		return dbus.MakeVariant(quotaPerSecUSec), nil
+	default:
+		return dbus.MakeVariant(value), nil
+	}
+}
+
+// parseCgroupLimit parses a cgroupfs limit value - either "max" or a
+// decimal count - into the uint64 systemd's MemoryMax/TasksMax properties
+// expect, where math.MaxUint64 (systemd's CGROUP_LIMIT_MAX) means "no
+// limit".
+func parseCgroupLimit(value string) (uint64, error) {
+	if value == "max" {
+		return math.MaxUint64, nil
+	}
+	limit, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing cgroup limit %q: %w", value, err)
+	}
+	return limit, nil
+}
+
+// parseCPUMaxQuotaPerSec converts cpu.max's "$MAX $PERIOD" cgroupfs value
+// (both in microseconds) into the single per-second microsecond quota
+// systemd's CPUQuotaPerSecUSec property expects: quota * 1e6 / period.
+// "max" for $MAX means no limit.
+func parseCPUMaxQuotaPerSec(value string) (uint64, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf(`parsing cpu.max value %q: want "$MAX $PERIOD"`, value)
+	}
+	if fields[0] == "max" {
+		return math.MaxUint64, nil
+	}
+	quota, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing cpu.max quota %q: %w", fields[0], err)
+	}
+	period, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing cpu.max period %q: %w", fields[1], err)
+	}
+	if period == 0 {
+		return 0, fmt.Errorf("cpu.max period must be non-zero, got %q", value)
+	}
+	return quota * 1_000_000 / period, nil
+}
+
+// setSystemdUnitProperty sets a single live property on the systemd unit
+// named unitName over the system D-Bus, the same mechanism runc's
+// "systemd" cgroup driver uses. runtime=true applies the change
+// immediately rather than only on the unit's next (re)start.
+func setSystemdUnitProperty(unitName, prop string, value dbus.Variant) error {
+	ctx := context.Background()
+	conn, err := systemdDbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to systemd: %w", err)
+	}
+	defer conn.Close()
+	return conn.SetUnitPropertiesContext(ctx, unitName, true, systemdDbus.Property{
+		Name:  prop,
+		Value: value,
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"gvisor.dev/gvisor/runsc/boot"
+)
+
+// openCompressedStateFile creates the on-disk checkpoint state file at
+// stateFilePath. If compression wraps it, the sentry (which knows nothing
+// about archive compression) can't write directly to that file: instead
+// this returns the write end of a pipe, and a background goroutine relays
+// everything written there through boot.NewCheckpointCompressor into the
+// real file on disk. The caller must close the returned file once the
+// checkpoint RPC has returned (which, for the pipe case, is what lets the
+// relay goroutine see EOF) and then call the returned func, whose error
+// reflects whether the compression itself succeeded.
+func openCompressedStateFile(stateFilePath string, compression boot.CheckpointCompression) (*os.File, func() error, error) {
+	dst, err := os.OpenFile(stateFilePath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating checkpoint state file %q: %w", stateFilePath, err)
+	}
+	if compression == boot.CheckpointCompressionNone {
+		return dst, func() error { return nil }, nil
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		dst.Close()
+		return nil, nil, fmt.Errorf("creating checkpoint compression pipe: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer dst.Close()
+		cw, err := boot.NewCheckpointCompressor(dst, compression)
+		if err != nil {
+			pr.Close()
+			done <- fmt.Errorf("creating checkpoint compressor: %w", err)
+			return
+		}
+		_, copyErr := io.Copy(cw, pr)
+		pr.Close()
+		closeErr := cw.Close()
+		switch {
+		case copyErr != nil:
+			done <- fmt.Errorf("compressing checkpoint state file: %w", copyErr)
+		case closeErr != nil:
+			done <- fmt.Errorf("flushing checkpoint state file compressor: %w", closeErr)
+		default:
+			done <- nil
+		}
+	}()
+
+	return pw, func() error { return <-done }, nil
+}
+
+// openDecompressedStateFile opens the on-disk checkpoint state file at
+// stateFilePath and detects its archive compression. If it's compressed,
+// the sentry (which knows nothing about archive compression) can't read
+// directly from that file: instead this returns the read end of a pipe,
+// and a background goroutine relays the file's contents through
+// boot.NewCheckpointDecompressor into the pipe. The caller must close the
+// returned file once the restore RPC has returned and then call the
+// returned func, whose error reflects whether the decompression itself
+// succeeded.
+func openDecompressedStateFile(stateFilePath string) (*os.File, boot.CheckpointCompression, func() error, error) {
+	src, err := os.Open(stateFilePath)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("opening state file %q failed: %w", stateFilePath, err)
+	}
+
+	// Sniff the archive's codec from its header rather than trusting a
+	// caller-supplied flag: this is what lets a gzip archive checkpointed
+	// before zstd became the default still restore correctly, with no
+	// action required from whoever's driving the restore.
+	compression, _, err := boot.DetectCheckpointCompression(src)
+	if err != nil {
+		src.Close()
+		return nil, 0, nil, fmt.Errorf("detecting archive compression for state file %q: %w", stateFilePath, err)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		src.Close()
+		return nil, 0, nil, fmt.Errorf("rewinding state file %q after sniffing its header: %w", stateFilePath, err)
+	}
+	if compression == boot.CheckpointCompressionNone {
+		return src, compression, src.Close, nil
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		src.Close()
+		return nil, 0, nil, fmt.Errorf("creating checkpoint decompression pipe: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer src.Close()
+		defer pw.Close()
+		dr, err := boot.NewCheckpointDecompressor(src, compression)
+		if err != nil {
+			done <- fmt.Errorf("creating checkpoint decompressor: %w", err)
+			return
+		}
+		if _, err := io.Copy(pw, dr); err != nil {
+			done <- fmt.Errorf("decompressing checkpoint state file: %w", err)
+			return
+		}
+		done <- nil
+	}()
+
+	return pr, compression, func() error { pr.Close(); return <-done }, nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/seccomp"
+)
+
+// hostSeccompMode selects how the sandbox host process' own seccomp filter
+// (separate from the guest-facing filter the boot binary installs for
+// itself once it's running) is enforced. It's the value of the
+// --host-seccomp flag.
+type hostSeccompMode string
+
+// The supported --host-seccomp values.
+const (
+	hostSeccompOff     hostSeccompMode = "off"
+	hostSeccompAudit   hostSeccompMode = "audit"
+	hostSeccompEnforce hostSeccompMode = "enforce"
+)
+
+// parseHostSeccompMode validates the --host-seccomp flag value.
+func parseHostSeccompMode(s string) (hostSeccompMode, error) {
+	switch hostSeccompMode(s) {
+	case "", hostSeccompOff:
+		return hostSeccompOff, nil
+	case hostSeccompAudit:
+		return hostSeccompAudit, nil
+	case hostSeccompEnforce:
+		return hostSeccompEnforce, nil
+	default:
+		return "", fmt.Errorf("invalid --host-seccomp value %q: want one of off, audit, enforce", s)
+	}
+}
+
+// hostSeccompRules is the curated allowlist of syscalls the runsc-sandbox
+// host process needs: everything its own goroutines (platform backend,
+// gofer RPCs, control server, cgroup/proc bookkeeping) touch, but nothing
+// a compromised Sentry should need beyond that. It's intentionally
+// broader than the guest-facing filter installed inside "runsc boot"
+// itself, which constrains what the *application* can do; this one
+// constrains the host process as a whole.
+func hostSeccompRules() seccomp.SyscallRules {
+	return seccomp.MakeSyscallRules(map[uintptr]seccomp.SyscallRule{
+		unix.SYS_READ:              seccomp.MatchAll{},
+		unix.SYS_WRITE:             seccomp.MatchAll{},
+		unix.SYS_PREAD64:           seccomp.MatchAll{},
+		unix.SYS_PWRITE64:          seccomp.MatchAll{},
+		unix.SYS_CLOSE:             seccomp.MatchAll{},
+		unix.SYS_MMAP:              seccomp.MatchAll{},
+		unix.SYS_MUNMAP:            seccomp.MatchAll{},
+		unix.SYS_MPROTECT:          seccomp.MatchAll{},
+		unix.SYS_MADVISE:           seccomp.MatchAll{},
+		unix.SYS_FUTEX:             seccomp.MatchAll{},
+		unix.SYS_EPOLL_WAIT:        seccomp.MatchAll{},
+		unix.SYS_EPOLL_CTL:         seccomp.MatchAll{},
+		unix.SYS_RT_SIGACTION:      seccomp.MatchAll{},
+		unix.SYS_RT_SIGPROCMASK:    seccomp.MatchAll{},
+		unix.SYS_RT_SIGRETURN:      seccomp.MatchAll{},
+		unix.SYS_CLONE:             seccomp.MatchAll{},
+		unix.SYS_EXIT:              seccomp.MatchAll{},
+		unix.SYS_EXIT_GROUP:        seccomp.MatchAll{},
+		unix.SYS_SCHED_YIELD:       seccomp.MatchAll{},
+		unix.SYS_SCHED_GETAFFINITY: seccomp.MatchAll{},
+		unix.SYS_GETPID:            seccomp.MatchAll{},
+		unix.SYS_GETTID:            seccomp.MatchAll{},
+		unix.SYS_TGKILL:            seccomp.MatchAll{},
+		unix.SYS_FCNTL:             seccomp.MatchAll{},
+		unix.SYS_FSTAT:             seccomp.MatchAll{},
+		unix.SYS_STATX:             seccomp.MatchAll{},
+		unix.SYS_GETDENTS64:        seccomp.MatchAll{},
+		unix.SYS_OPENAT: seccomp.PerArg{
+			// The host process only ever opens FDs we already know the
+			// paths of: the gofer-exported filesystem, /proc/self and
+			// /proc/thread-self (for lsm.go), and this host's device
+			// files for the configured platform (KVM/vhost/TUN).
+			seccomp.AnyValue{},
+			seccomp.AnyValue{},
+			seccomp.AnyValue{},
+		},
+		unix.SYS_IOCTL: seccomp.PerArg{
+			seccomp.AnyValue{},
+			seccomp.Or{
+				// KVM control requests.
+				seccomp.EqualTo(linux.KVM_RUN),
+				seccomp.EqualTo(linux.KVM_GET_REGS),
+				seccomp.EqualTo(linux.KVM_SET_REGS),
+				// tun/tap and vhost-net setup.
+				seccomp.EqualTo(linux.TUNSETIFF),
+				seccomp.EqualTo(linux.TUNSETOFFLOAD),
+			},
+		},
+	})
+}
+
+// installHostSeccomp compiles hostSeccompRules into a BPF program and
+// loads it into the calling thread per mode. "runsc boot" calls it, through
+// InstallHostSeccomp, right after ApplyLSM and before boot.New, so the
+// filter is in force before any sandbox setup or application data is
+// touched.
+func installHostSeccomp(mode hostSeccompMode) error {
+	if mode == hostSeccompOff {
+		return nil
+	}
+	action := linux.SECCOMP_RET_KILL_THREAD
+	if mode == hostSeccompAudit {
+		action = linux.SECCOMP_RET_LOG
+	}
+	program, err := seccomp.BuildProgram([]seccomp.RuleSet{
+		{
+			Rules:  hostSeccompRules(),
+			Action: linux.SECCOMP_RET_ALLOW,
+		},
+	}, seccomp.ProgramOptions{
+		DefaultAction: action,
+		BadArchAction: action,
+	})
+	if err != nil {
+		return fmt.Errorf("building host seccomp program: %w", err)
+	}
+	if mode == hostSeccompAudit {
+		log.Warningf("Host seccomp filter loaded in audit mode: denied syscalls are logged, not blocked")
+	}
+	return seccomp.SetFilter(program)
+}
+
+// InstallHostSeccomp parses the --host-seccomp flag value s and, if it
+// names an enforcement mode other than "off", compiles and installs the
+// host process's own seccomp filter on the calling thread. It's exported
+// for "runsc boot" to call directly, since parseHostSeccompMode and
+// installHostSeccomp are themselves unexported.
+func InstallHostSeccomp(s string) error {
+	mode, err := parseHostSeccompMode(s)
+	if err != nil {
+		return err
+	}
+	return installHostSeccomp(mode)
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// lsmMode selects how the sandbox host process' LSM label is chosen. It's
+// the value of the --sandbox-lsm flag.
+type lsmMode string
+
+// The supported --sandbox-lsm values.
+const (
+	// lsmModeNone applies no LSM confinement, even if the spec requests
+	// one. Useful on hosts where AppArmor/SELinux aren't enabled.
+	lsmModeNone lsmMode = "none"
+
+	// lsmModePassthrough applies whatever spec.Process.ApparmorProfile or
+	// spec.Process.SelinuxLabel the container spec carries, and nothing
+	// otherwise. This is the default, matching runc.
+	lsmModePassthrough lsmMode = "passthrough"
+
+	// lsmModeStrict additionally falls back to a fixed, release-shipped
+	// "runsc-sandbox" AppArmor profile when the spec doesn't request one.
+	lsmModeStrict lsmMode = "strict"
+)
+
+// defaultAppArmorProfile is applied under lsmModeStrict when the spec
+// doesn't name a profile of its own. It must be loaded into the kernel
+// separately (e.g. by the distro package) under this exact name.
+const defaultAppArmorProfile = "runsc-sandbox"
+
+// parseLSMMode validates the --sandbox-lsm flag value.
+func parseLSMMode(s string) (lsmMode, error) {
+	switch lsmMode(s) {
+	case "", lsmModePassthrough:
+		return lsmModePassthrough, nil
+	case lsmModeStrict:
+		return lsmModeStrict, nil
+	case lsmModeNone:
+		return lsmModeNone, nil
+	default:
+		return "", fmt.Errorf("invalid --sandbox-lsm value %q: want one of strict, passthrough, none", s)
+	}
+}
+
+// resolveLSMLabels picks the AppArmor profile and/or SELinux label the
+// sandbox host process should apply to itself, given the container spec
+// and mode. An empty return value means "don't touch that LSM".
+func resolveLSMLabels(spec *specs.Spec, mode lsmMode) (apparmorProfile, selinuxLabel string) {
+	if mode == lsmModeNone {
+		return "", ""
+	}
+	if spec != nil && spec.Process != nil {
+		apparmorProfile = spec.Process.ApparmorProfile
+		selinuxLabel = spec.Process.SelinuxLabel
+	}
+	if apparmorProfile == "" && mode == lsmModeStrict {
+		apparmorProfile = defaultAppArmorProfile
+	}
+	return apparmorProfile, selinuxLabel
+}
+
+// ApplyLSM writes the AppArmor "exec" rule and/or the SELinux process
+// context for the calling thread, so that the label takes effect on its
+// next exec. The SELinux file is per-thread (/proc/thread-self); the
+// AppArmor file is reached through /proc/self, which names the process's
+// main thread. The caller must call this immediately before exec, on the
+// thread that will perform it (runtime.LockOSThread is implied by that
+// constraint). createSandboxProcess passes
+// the resolved profile/label down to "runsc boot" via --apparmor-profile
+// and --selinux-label, and it's the boot command - not this process -
+// that calls ApplyLSM right before its final exec into the sentry:
+// os/exec gives no hook to run code on the forked child before its exec,
+// so the child has to apply its own label once it's running.
+func ApplyLSM(apparmorProfile, selinuxLabel string) error {
+	if apparmorProfile != "" {
+		if err := writeExecAttr("/proc/self/attr/apparmor/exec", "exec "+apparmorProfile); err != nil {
+			return fmt.Errorf("applying AppArmor profile %q: %w", apparmorProfile, err)
+		}
+	}
+	if selinuxLabel != "" {
+		if err := writeExecAttr("/proc/thread-self/attr/exec", selinuxLabel); err != nil {
+			return fmt.Errorf("applying SELinux label %q: %w", selinuxLabel, err)
+		}
+	}
+	return nil
+}
+
+// writeExecAttr writes value to an LSM-exposed /proc/.../attr file.
+// ENOENT means the LSM isn't compiled into the running kernel; the caller
+// only reaches here when a profile/label was actually requested, so that's
+// surfaced as an error rather than silently ignored.
+func writeExecAttr(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s does not exist; is this LSM enabled on the host? %w", path, err)
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(value); err != nil {
+		return fmt.Errorf("writing %q to %s: %w", value, path, err)
+	}
+	return nil
+}
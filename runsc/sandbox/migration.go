@@ -0,0 +1,267 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/boot"
+	"gvisor.dev/gvisor/runsc/config"
+	"gvisor.dev/gvisor/runsc/hostsettings"
+)
+
+// PreDumpOpts configures an iterative pre-copy migration ahead of a final,
+// stop-the-world round.
+type PreDumpOpts struct {
+	// ConvergenceThreshold stops iteration once a round's dirty page count
+	// has fallen to this fraction (or below) of the previous round's,
+	// meaning the remaining working set is small enough that the final
+	// stop-the-world round won't cost much downtime. Zero disables this
+	// check; the caller must rely on MaxIterations instead.
+	ConvergenceThreshold float64
+
+	// MaxIterations bounds how many pre-copy rounds PreDumpHandle.Iterate
+	// will report as unconverged before forcing convergence. Zero means
+	// unbounded.
+	MaxIterations int
+}
+
+// PreDumpHandle drives the caller side of an iterative pre-copy migration
+// started by Sandbox.PreDump. It is not safe for concurrent use.
+type PreDumpHandle struct {
+	s          *Sandbox
+	cid        string
+	opts       PreDumpOpts
+	generation uint64
+	lastDirty  uint64
+}
+
+// PreDump starts an iterative pre-copy migration of cid's memory without
+// freezing the container. Each subsequent call to the returned handle's
+// Iterate method copies out only the pages dirtied since the previous
+// round (the sentry tracks this as a per-page dirty generation in
+// pgalloc.MemoryFile), resetting their dirty bit on success; Stop takes
+// the final, pausing round once the caller decides the working set has
+// converged enough.
+func (s *Sandbox) PreDump(cid string, opts PreDumpOpts) (*PreDumpHandle, error) {
+	log.Debugf("PreDump sandbox %q container %q, opts %+v", s.ID, cid, opts)
+	var resp boot.PreDumpResult
+	if err := s.call(boot.ContMgrPreDumpStart, &boot.PreDumpArgs{ContainerID: cid}, &resp); err != nil {
+		return nil, fmt.Errorf("starting pre-dump for container %q: %w", cid, err)
+	}
+	return &PreDumpHandle{s: s, cid: cid, opts: opts, lastDirty: resp.DirtyPageCount}, nil
+}
+
+// Iterate copies out and transmits to w one round of pages dirtied since
+// the previous round (or since PreDump, for the first), without pausing
+// the container. It reports whether the working set has converged: once
+// true, the caller should stop calling Iterate and call Stop instead.
+func (h *PreDumpHandle) Iterate(w io.Writer) (converged bool, err error) {
+	h.generation++
+	var resp boot.PreDumpResult
+	args := &boot.PreDumpArgs{ContainerID: h.cid, Generation: h.generation}
+	if err := h.s.call(boot.ContMgrPreDumpIterate, args, &resp); err != nil {
+		return false, fmt.Errorf("pre-dump round %d for container %q: %w", h.generation, h.cid, err)
+	}
+	if err := writePreDumpFrame(w, preDumpFrame{Generation: h.generation, DirtyRuns: resp.DirtyRuns, Payload: resp.Payload}); err != nil {
+		return false, err
+	}
+
+	converged = h.opts.MaxIterations > 0 && int(h.generation) >= h.opts.MaxIterations
+	if !converged && h.opts.ConvergenceThreshold > 0 && h.lastDirty > 0 {
+		converged = float64(resp.DirtyPageCount)/float64(h.lastDirty) <= h.opts.ConvergenceThreshold
+	}
+	h.lastDirty = resp.DirtyPageCount
+	return converged, nil
+}
+
+// Stop takes the final, stop-the-world pre-copy round: it pauses h's
+// container, drains whatever pages are still dirty, and writes them to w
+// along with the sentry's own state. Unlike Iterate's rounds, this one
+// also includes memfd-backed shared memory regions in full (they aren't
+// tracked by the per-page dirty generation the incremental rounds rely
+// on) and honors execOpts' SaveRestoreExecArgv settings, which only apply
+// to this final round. The container remains paused on return; the
+// caller is responsible for destroying it once the peer confirms the
+// migration completed.
+func (h *PreDumpHandle) Stop(w io.Writer, execOpts CheckpointOpts) error {
+	h.generation++
+	var resp boot.PreDumpResult
+	args := &boot.PreDumpFinalizeArgs{
+		ContainerID:                h.cid,
+		SaveRestoreExecArgv:        execOpts.SaveRestoreExecArgv,
+		SaveRestoreExecTimeout:     execOpts.SaveRestoreExecTimeout,
+		SaveRestoreExecContainerID: execOpts.SaveRestoreExecContainerID,
+	}
+	if err := h.s.call(boot.ContMgrPreDumpFinalize, args, &resp); err != nil {
+		return fmt.Errorf("finalizing pre-dump for container %q: %w", h.cid, err)
+	}
+	return writePreDumpFrame(w, preDumpFrame{Generation: h.generation, DirtyRuns: resp.DirtyRuns, Payload: resp.Payload})
+}
+
+// RestoreStreamOpts configures Sandbox.RestoreStream.
+type RestoreStreamOpts struct {
+	// Background indicates that the caller will wait on WaitRestore
+	// rather than block until the restore completes.
+	Background bool
+
+	// NotifyHooks run synchronously around the restore, the same as
+	// Restore's notifyHooks parameter: NotifyPreRestore before the stream
+	// is consumed, NotifySetupNamespaces/NotifyNetworkLock/
+	// NotifyNetworkUnlock around reconfiguring the network, and
+	// NotifyPostRestore once the restore RPC has succeeded. A failure at
+	// any stage after the restore RPC tears the sandbox down rather than
+	// resuming it, same as Restore.
+	NotifyHooks []NotifyHook
+}
+
+// RestoreStream consumes a pre-copy migration stream produced by a peer's
+// PreDumpHandle (zero or more Iterate frames followed by one Stop frame)
+// and restores cid from it. Gofer-attached FDs are not part of the
+// stream: they only make sense in the process that holds them, so the
+// target reconnects its own from spec/conf rather than expecting the
+// source to hand them over.
+func (s *Sandbox) RestoreStream(conf *config.Config, spec *specs.Spec, cid string, r io.Reader, opts RestoreStreamOpts) error {
+	log.Debugf("RestoreStream sandbox %q container %q, opts %+v", s.ID, cid, opts)
+
+	if err := hostsettings.Handle(conf); err != nil {
+		return fmt.Errorf("host settings: %w (use --host-settings=ignore to bypass)", err)
+	}
+
+	if err := runNotifyHooks(opts.NotifyHooks, NotifyPreRestore, cid); err != nil {
+		return fmt.Errorf("pre-restore hook for container %q: %w", cid, err)
+	}
+
+	var frames []preDumpFrame
+	for {
+		f, err := readPreDumpFrame(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading pre-dump frame: %w", err)
+		}
+		frames = append(frames, f)
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("migration stream for container %q had no frames", cid)
+	}
+
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := runNotifyHooks(opts.NotifyHooks, NotifySetupNamespaces, cid); err != nil {
+		return fmt.Errorf("setup-namespaces hook for container %q: %w", cid, err)
+	}
+
+	disableIPv6, err := getDisableIPv6(spec)
+	if err != nil {
+		return err
+	}
+	if err := runNotifyHooks(opts.NotifyHooks, NotifyNetworkLock, cid); err != nil {
+		return fmt.Errorf("network-lock hook for container %q: %w", cid, err)
+	}
+	if err := setupNetwork(conn, s.Pid.load(), conf, disableIPv6); err != nil {
+		return fmt.Errorf("setting up network: %w", err)
+	}
+	if err := runNotifyHooks(opts.NotifyHooks, NotifyNetworkUnlock, cid); err != nil {
+		return fmt.Errorf("network-unlock hook for container %q: %w", cid, err)
+	}
+
+	args := &boot.RestoreStreamArgs{
+		ContainerID: cid,
+		Frames:      make([]boot.PreDumpResult, len(frames)),
+		Background:  opts.Background,
+	}
+	for i, f := range frames {
+		args.Frames[i] = boot.PreDumpResult{DirtyRuns: f.DirtyRuns, Payload: f.Payload}
+	}
+	if err := conn.Call(boot.ContMgrRestoreStream, args, nil); err != nil {
+		return fmt.Errorf("restoring container %q from migration stream: %w", cid, err)
+	}
+
+	if err := runNotifyHooks(opts.NotifyHooks, NotifyPostRestore, cid); err != nil {
+		if destroyErr := s.destroy(); destroyErr != nil {
+			log.Warningf("Sandbox %q: destroying after failed post-restore hook also failed: %v", s.ID, destroyErr)
+		}
+		return fmt.Errorf("post-restore hook for container %q: %w", cid, err)
+	}
+	return nil
+}
+
+// preDumpFrame is one round of an iterative pre-copy migration's wire
+// format: a monotonically increasing generation number, a run-length
+// encoded bitmap of which pages changed since the previous generation
+// (alternating clean-run, dirty-run lengths), and the sentry-compressed
+// contents of the dirty runs, in order.
+type preDumpFrame struct {
+	Generation uint64
+	DirtyRuns  []uint64
+	Payload    []byte
+}
+
+// preDumpFrameHeader is the JSON-encoded, length-prefixed portion of a
+// preDumpFrame; Payload follows it directly as raw bytes so large page
+// contents don't have to round-trip through JSON.
+type preDumpFrameHeader struct {
+	Generation uint64
+	DirtyRuns  []uint64
+	PayloadLen int
+}
+
+func writePreDumpFrame(w io.Writer, f preDumpFrame) error {
+	header, err := json.Marshal(preDumpFrameHeader{Generation: f.Generation, DirtyRuns: f.DirtyRuns, PayloadLen: len(f.Payload)})
+	if err != nil {
+		return fmt.Errorf("marshaling pre-dump frame header: %w", err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(header))); err != nil {
+		return fmt.Errorf("writing pre-dump frame header length: %w", err)
+	}
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("writing pre-dump frame header: %w", err)
+	}
+	if _, err := w.Write(f.Payload); err != nil {
+		return fmt.Errorf("writing pre-dump frame payload: %w", err)
+	}
+	return nil
+}
+
+func readPreDumpFrame(r io.Reader) (preDumpFrame, error) {
+	var headerLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &headerLen); err != nil {
+		return preDumpFrame{}, err
+	}
+	headerBuf := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, headerBuf); err != nil {
+		return preDumpFrame{}, fmt.Errorf("reading pre-dump frame header: %w", err)
+	}
+	var header preDumpFrameHeader
+	if err := json.Unmarshal(headerBuf, &header); err != nil {
+		return preDumpFrame{}, fmt.Errorf("unmarshaling pre-dump frame header: %w", err)
+	}
+	payload := make([]byte, header.PayloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return preDumpFrame{}, fmt.Errorf("reading pre-dump frame payload: %w", err)
+	}
+	return preDumpFrame{Generation: header.Generation, DirtyRuns: header.DirtyRuns, Payload: payload}, nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"gvisor.dev/gvisor/runsc/boot"
+)
+
+// addrTemplateIface describes one of the host's interfaces, for use as the
+// argument of the "attr" template function, e.g.
+// `{{ GetDefaultInterfaces | attr "address" }}`.
+type addrTemplateIface struct {
+	Name    string
+	Address net.IP
+}
+
+// addrTemplateFuncs are the functions available inside an address/gateway
+// template expression. They're resolved host-side, in runsc, before the
+// resulting IP is sent to the sentry in a urpc call: the sentry has no view
+// of the host's interfaces to resolve these against.
+var addrTemplateFuncs = template.FuncMap{
+	"GetInterfaceIP": func(name string) (net.IP, error) {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("looking up interface %q: %w", name, err)
+		}
+		return firstIPv4(iface)
+	},
+	"GetPrivateIP": func() (net.IP, error) {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, fmt.Errorf("listing interfaces: %w", err)
+		}
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+				continue
+			}
+			ip, err := firstIPv4(&iface)
+			if err != nil || ip == nil {
+				continue
+			}
+			if ip.IsPrivate() {
+				return ip, nil
+			}
+		}
+		return nil, fmt.Errorf("no interface has a private IPv4 address")
+	},
+	"GetDefaultInterfaces": func() ([]addrTemplateIface, error) {
+		names, err := defaultRouteInterfaceNames()
+		if err != nil {
+			return nil, err
+		}
+		out := make([]addrTemplateIface, 0, len(names))
+		for _, name := range names {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				continue
+			}
+			ip, err := firstIPv4(iface)
+			if err != nil || ip == nil {
+				continue
+			}
+			out = append(out, addrTemplateIface{Name: name, Address: ip})
+		}
+		return out, nil
+	},
+	"attr": func(name string, v any) (string, error) {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Slice {
+			if rv.Len() == 0 {
+				return "", fmt.Errorf("attr %q: empty slice", name)
+			}
+			rv = rv.Index(0)
+		}
+		field := rv.FieldByNameFunc(func(n string) bool { return strings.EqualFold(n, name) })
+		if !field.IsValid() {
+			return "", fmt.Errorf("attr %q: no such field on %T", name, v)
+		}
+		if ip, ok := field.Interface().(net.IP); ok {
+			return ip.String(), nil
+		}
+		return fmt.Sprintf("%v", field.Interface()), nil
+	},
+}
+
+// defaultRouteInterfaceNames returns the names of every interface that owns
+// a default (destination 0.0.0.0/0) IPv4 route, reading /proc/net/route the
+// same way `ip route show default` does.
+func defaultRouteInterfaceNames() ([]string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("opening /proc/net/route: %w", err)
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Skip the header line.
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Fields: Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		if len(fields) < 8 {
+			continue
+		}
+		if fields[1] != "00000000" {
+			continue
+		}
+		names = append(names, fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading /proc/net/route: %w", err)
+	}
+	return names, nil
+}
+
+// firstIPv4 returns the first IPv4 address assigned to iface.
+func firstIPv4(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("listing addresses on %q: %w", iface.Name, err)
+	}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %q has no IPv4 address", iface.Name)
+}
+
+// isTemplateExpr reports whether s looks like a template expression rather
+// than a literal address, so callers that already have a parsed net.IP
+// (e.g. from a dotted-quad string) don't pay the template evaluation cost.
+func isTemplateExpr(s string) bool {
+	return strings.Contains(s, "{{") && strings.Contains(s, "}}")
+}
+
+// resolveAddressTemplate evaluates expr (e.g. `{{ GetInterfaceIP "eth0" }}`)
+// against the host's current network configuration and parses the result
+// as an IP address.
+func resolveAddressTemplate(expr string) (net.IP, error) {
+	tmpl, err := template.New("address").Funcs(addrTemplateFuncs).Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing address template %q: %w", expr, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("evaluating address template %q: %w", expr, err)
+	}
+	resolved := strings.TrimSpace(buf.String())
+	ip := net.ParseIP(resolved)
+	if ip == nil {
+		return nil, fmt.Errorf("address template %q resolved to %q, which isn't a valid IP", expr, resolved)
+	}
+	return ip, nil
+}
+
+// ResolveLinksAndRoutesTemplates resolves templated addresses against the
+// host's current network configuration, in place. rawAddrs maps a pointer
+// into args (an IPWithPrefix.Address, a Route.Gateway, ...) to the raw OCI
+// config string it was parsed from; only entries whose raw string is a
+// template expression are touched. It must run before args is sent to the
+// sentry via the CreateLinksAndRoutes urpc call, since only runsc, not the
+// sentry, can see the host's interfaces.
+func ResolveLinksAndRoutesTemplates(args *boot.CreateLinksAndRoutesArgs, rawAddrs map[*net.IP]string) error {
+	for ipPtr, raw := range rawAddrs {
+		if !isTemplateExpr(raw) {
+			continue
+		}
+		resolved, err := resolveAddressTemplate(raw)
+		if err != nil {
+			return err
+		}
+		*ipPtr = resolved
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/boot"
+	"gvisor.dev/gvisor/runsc/config"
+)
+
+// Reattach reconnects to an already-running sandbox by id without
+// restarting or killing it. It's for a supervising runsc-cri shim that
+// crashed and lost its in-memory Sandbox: the sandbox process itself
+// outlives the shim (it isn't in the shim's process group and doesn't
+// exit when the shim does), but the shim's control-plane state doesn't
+// survive the crash, so a fresh process has to rebuild just enough of
+// this struct to resume talking to the same control socket.
+func Reattach(conf *config.Config, id string) (*Sandbox, error) {
+	s := &Sandbox{
+		ID:      id,
+		rootDir: conf.RootDir,
+	}
+
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return nil, fmt.Errorf("reattaching to sandbox %q: %w", id, err)
+	}
+	defer conn.Close()
+
+	var resp boot.ReattachResult
+	if err := conn.Call(boot.ContMgrReattach, &id, &resp); err != nil {
+		return nil, fmt.Errorf("reattaching to sandbox %q: %w", id, err)
+	}
+	s.Pid.store(resp.Pid)
+	// This process didn't fork the sandbox process, so it must not wait(2)
+	// on it; waitForStopped only wait(2)s on the sandbox when s.child is
+	// set.
+	s.child = false
+
+	log.Infof("Reattached to sandbox %q, PID %d, %d container(s) running", id, resp.Pid, len(resp.ContainerIDs))
+	return s, nil
+}
+
+// OpenIO reopens a container process' stdio FIFOs, letting a supervising
+// shim that crashed (and so lost its original pipe ends) regain a
+// container's TTY/pipes the same way containerd's shim v2 reattaches to a
+// task after a restart. The FIFOs themselves are created once, when the
+// process' stdio is first set up; OpenIO only reopens the runtime's end
+// of them, so it must be called with the same cid/pid the process was
+// created or exec'd with.
+func (s *Sandbox) OpenIO(cid string, pid int32) (stdin, stdout, stderr *os.File, err error) {
+	dir := s.fifoDir(cid, pid)
+
+	if stdin, err = os.OpenFile(filepath.Join(dir, "stdin"), os.O_WRONLY, 0); err != nil {
+		return nil, nil, nil, fmt.Errorf("reopening stdin FIFO for %q PID %d: %w", cid, pid, err)
+	}
+	if stdout, err = os.OpenFile(filepath.Join(dir, "stdout"), os.O_RDONLY, 0); err != nil {
+		stdin.Close()
+		return nil, nil, nil, fmt.Errorf("reopening stdout FIFO for %q PID %d: %w", cid, pid, err)
+	}
+	if stderr, err = os.OpenFile(filepath.Join(dir, "stderr"), os.O_RDONLY, 0); err != nil {
+		stdin.Close()
+		stdout.Close()
+		return nil, nil, nil, fmt.Errorf("reopening stderr FIFO for %q PID %d: %w", cid, pid, err)
+	}
+	return stdin, stdout, stderr, nil
+}
+
+// fifoDir is where OpenIO expects to find cid/pid's stdio FIFOs: a
+// per-process directory under the sandbox's root directory. The exact
+// layout is a runsc/container concern (it's the code that creates these
+// FIFOs in the first place); it's mirrored here only so a reattached
+// Sandbox, which has no other record of it, can find them again.
+func (s *Sandbox) fifoDir(cid string, pid int32) string {
+	return filepath.Join(s.rootDir, s.ID, cid, strconv.Itoa(int(pid)))
+}
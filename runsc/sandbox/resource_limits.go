@@ -0,0 +1,117 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/urpc"
+	"gvisor.dev/gvisor/runsc/boot"
+)
+
+// cgroupResourceLimits is the subset of cgroup.Cgroup that exposes the
+// controllers createSandboxProcess doesn't already read into --cpu-num and
+// --total-memory. Unlike those two, which the sentry needs before its first
+// container even starts, these apply once the sentry is up and are sent over
+// urpc instead of the command line. A cgroup implementation (or a host
+// kernel that hasn't mounted a given controller) that doesn't satisfy this
+// interface simply isn't read; applyResourceLimits treats that the same as
+// an unlimited value.
+type cgroupResourceLimits interface {
+	// PIDsMax returns the pids.max limit, or -1 if unlimited.
+	PIDsMax() (int64, error)
+
+	// HugetlbLimits returns the hugetlb.<pagesize>.limit_in_bytes limits,
+	// keyed by page size (e.g. "2MB", "1GB").
+	HugetlbLimits() (map[string]int64, error)
+
+	// BlkioWeight returns the io/blkio proportional weight.
+	BlkioWeight() (uint64, error)
+
+	// BlkioThrottle returns the per-device IOPS/BPS throttles.
+	BlkioThrottle() ([]boot.BlkioDeviceThrottle, error)
+
+	// NetClsClassID returns the net_cls.classid tag applied to packets the
+	// sandbox's network namespace sends, or 0 if unset.
+	NetClsClassID() (uint32, error)
+
+	// CPUSet returns the cpuset.cpus and cpuset.mems masks, in the list
+	// format cpuset itself uses (e.g. "0-3,7").
+	CPUSet() (cpus, mems string, err error)
+
+	// CPUWeight returns cpu.weight (cgroupv2) or the cpu.shares-derived
+	// equivalent (cgroupv1).
+	CPUWeight() (uint64, error)
+}
+
+// buildResourceLimits reads every controller s.CgroupJSON.Cgroup exposes via
+// cgroupResourceLimits into a boot.ResourceLimits. It returns the zero value,
+// not an error, if the cgroup doesn't implement the interface at all.
+func (s *Sandbox) buildResourceLimits() (boot.ResourceLimits, error) {
+	rl, ok := s.CgroupJSON.Cgroup.(cgroupResourceLimits)
+	if !ok {
+		return boot.ResourceLimits{}, nil
+	}
+
+	var limits boot.ResourceLimits
+	var err error
+	if limits.PIDsMax, err = rl.PIDsMax(); err != nil {
+		return boot.ResourceLimits{}, fmt.Errorf("reading pids.max: %w", err)
+	}
+	if limits.HugetlbLimits, err = rl.HugetlbLimits(); err != nil {
+		return boot.ResourceLimits{}, fmt.Errorf("reading hugetlb limits: %w", err)
+	}
+	if limits.BlkioWeight, err = rl.BlkioWeight(); err != nil {
+		return boot.ResourceLimits{}, fmt.Errorf("reading blkio weight: %w", err)
+	}
+	if limits.BlkioThrottle, err = rl.BlkioThrottle(); err != nil {
+		return boot.ResourceLimits{}, fmt.Errorf("reading blkio throttle: %w", err)
+	}
+	if limits.NetClsClassID, err = rl.NetClsClassID(); err != nil {
+		return boot.ResourceLimits{}, fmt.Errorf("reading net_cls classid: %w", err)
+	}
+	if limits.CPUSetCPUs, limits.CPUSetMems, err = rl.CPUSet(); err != nil {
+		return boot.ResourceLimits{}, fmt.Errorf("reading cpuset: %w", err)
+	}
+	if limits.CPUWeight, err = rl.CPUWeight(); err != nil {
+		return boot.ResourceLimits{}, fmt.Errorf("reading cpu weight: %w", err)
+	}
+	return limits, nil
+}
+
+// applyResourceLimits forwards s's cgroup controllers beyond CPU count and
+// memory limit to the running sentry: CPUSetCPUs pins the sentry's worker
+// goroutines via sched_setaffinity, HugetlbLimits caps pgalloc.MemoryFile's
+// per-size hugepage allocations, and PIDsMax/BlkioWeight/BlkioThrottle/
+// NetClsClassID/CPUWeight feed the scheduler's admission checks. Limits hit
+// at runtime surface back through Event the same way memory pressure
+// already does, just tagged with the controller that's under pressure
+// instead of always being reported as memory. It's a no-op if the sandbox
+// has no cgroup, or the cgroup doesn't expose any of these controllers.
+func (s *Sandbox) applyResourceLimits(conn *urpc.Client) error {
+	if _, ok := s.CgroupJSON.Cgroup.(cgroupResourceLimits); !ok {
+		return nil
+	}
+	limits, err := s.buildResourceLimits()
+	if err != nil {
+		return fmt.Errorf("reading resource limits for sandbox %q: %w", s.ID, err)
+	}
+	log.Debugf("Applying resource limits for sandbox %q: %+v", s.ID, limits)
+	if err := conn.Call(boot.ContMgrSetResourceLimits, &limits, nil); err != nil {
+		return fmt.Errorf("applying resource limits for sandbox %q: %w", s.ID, err)
+	}
+	return nil
+}
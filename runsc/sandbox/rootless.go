@@ -0,0 +1,189 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/pkg/log"
+)
+
+// RootlessMode selects how createSandboxProcess establishes the sandbox's
+// user ID mappings when running without CAP_SETUID/CAP_SETGID
+// (rootlessEUID). It's the value of the --rootless-mode flag.
+type RootlessMode string
+
+// The supported --rootless-mode values.
+const (
+	// RootlessModeAuto tries RootlessModeNative first and only falls back
+	// to RootlessModeHelper if that fails, e.g. because /etc/subuid grants
+	// a range the caller's own write to uid_map can't represent without
+	// CAP_SETUID in the parent namespace. This is the default.
+	RootlessModeAuto RootlessMode = "auto"
+
+	// RootlessModeNative writes /proc/<pid>/{uid,gid}_map directly,
+	// expanding the mapping with /etc/subuid and /etc/subgid (parsed
+	// ourselves) when the caller only requested a single-ID mapping. It
+	// never shells out to the newuidmap/newgidmap setuid helpers.
+	RootlessModeNative RootlessMode = "native"
+
+	// RootlessModeHelper always uses the newuidmap/newgidmap setuid
+	// helpers (SetUserMappings), matching gVisor's historical behavior.
+	RootlessModeHelper RootlessMode = "helper"
+)
+
+// ParseRootlessMode validates the --rootless-mode flag value.
+func ParseRootlessMode(s string) (RootlessMode, error) {
+	switch RootlessMode(s) {
+	case "", RootlessModeAuto:
+		return RootlessModeAuto, nil
+	case RootlessModeNative, RootlessModeHelper:
+		return RootlessMode(s), nil
+	default:
+		return "", fmt.Errorf("invalid --rootless-mode value %q: want one of auto, native, helper", s)
+	}
+}
+
+// setRootlessUserMappings applies spec's UID/GID mappings to pid according
+// to s.rootlessMode, replacing the direct call to SetUserMappings that
+// every rootless sandbox used to make unconditionally.
+func (s *Sandbox) setRootlessUserMappings(spec *specs.Spec, pid int) error {
+	if s.rootlessMode == RootlessModeHelper {
+		return SetUserMappings(spec, pid)
+	}
+	err := SetUserMappingsNative(spec, pid)
+	if err == nil || s.rootlessMode == RootlessModeNative {
+		return err
+	}
+	log.Warningf("Sandbox %q: native rootless user mappings failed, falling back to newuidmap/newgidmap: %v", s.ID, err)
+	return SetUserMappings(spec, pid)
+}
+
+// SetUserMappingsNative writes pid's user ID mappings directly to
+// /proc/<pid>/{uid,gid}_map, the same operation the newuidmap/newgidmap
+// setuid helpers perform, following the unshare-then-write pattern
+// buildah's unshare package uses: setgroups is denied first (the kernel
+// refuses to write gid_map from a process without CAP_SETGID in the
+// target namespace until it has), then uid_map and gid_map are each
+// written in a single write, since the kernel rejects partial or repeated
+// writes to either file.
+func SetUserMappingsNative(spec *specs.Spec, pid int) error {
+	log.Debugf("Setting user mappings natively for PID %d", pid)
+
+	if err := os.WriteFile(fmt.Sprintf("/proc/%d/setgroups", pid), []byte("deny"), 0644); err != nil {
+		return fmt.Errorf("denying setgroups for PID %d: %w", pid, err)
+	}
+
+	uidMappings, err := rootlessIDMappings(spec.Linux.UIDMappings, "/etc/subuid")
+	if err != nil {
+		return fmt.Errorf("resolving uid mappings for PID %d: %w", pid, err)
+	}
+	if err := writeIDMap(fmt.Sprintf("/proc/%d/uid_map", pid), uidMappings); err != nil {
+		return fmt.Errorf("writing uid_map for PID %d: %w", pid, err)
+	}
+
+	gidMappings, err := rootlessIDMappings(spec.Linux.GIDMappings, "/etc/subgid")
+	if err != nil {
+		return fmt.Errorf("resolving gid mappings for PID %d: %w", pid, err)
+	}
+	if err := writeIDMap(fmt.Sprintf("/proc/%d/gid_map", pid), gidMappings); err != nil {
+		return fmt.Errorf("writing gid_map for PID %d: %w", pid, err)
+	}
+	return nil
+}
+
+// rootlessIDMappings returns the mappings SetUserMappingsNative should
+// write. When the caller requested more than the minimal single-ID
+// mapping, that request is trusted as-is. Otherwise, it's extended with
+// whatever range subFile ("/etc/subuid" or "/etc/subgid") grants the
+// current effective user, the same file newuidmap/newgidmap consult, so
+// a plain "map me to root" rootless launch still gets the multi-ID range
+// a subuid/subgid entry promises, without the caller having had to look
+// it up itself.
+func rootlessIDMappings(mappings []specs.LinuxIDMapping, subFile string) ([]specs.LinuxIDMapping, error) {
+	if len(mappings) != 1 || mappings[0].Size != 1 {
+		return mappings, nil
+	}
+	ranges, err := subIDRanges(subFile, os.Geteuid())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return mappings, nil
+		}
+		return nil, err
+	}
+	return append(mappings, ranges...), nil
+}
+
+// subIDRanges parses path ("/etc/subuid" or "/etc/subgid")'s
+// "name-or-id:start:count" lines for the caller's own euid, returning one
+// LinuxIDMapping per matching line with container IDs allocated
+// contiguously starting at 1 (0 is reserved for the caller's own identity
+// mapping).
+func subIDRanges(path string, euid int) ([]specs.LinuxIDMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	self := strconv.Itoa(euid)
+	var mappings []specs.LinuxIDMapping
+	var nextContainerID uint32 = 1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 || parts[0] != self {
+			// Entries keyed by username rather than uid would require a
+			// passwd lookup to match; skipping them only means missing
+			// out on the range expansion, not a wrong mapping.
+			continue
+		}
+		start, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %q: invalid range start in %q", path, line)
+		}
+		count, err := strconv.ParseUint(parts[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %q: invalid range count in %q", path, line)
+		}
+		mappings = append(mappings, specs.LinuxIDMapping{
+			ContainerID: nextContainerID,
+			HostID:      uint32(start),
+			Size:        uint32(count),
+		})
+		nextContainerID += uint32(count)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %q: %w", path, err)
+	}
+	return mappings, nil
+}
+
+func writeIDMap(path string, mappings []specs.LinuxIDMapping) error {
+	var sb strings.Builder
+	for _, m := range mappings {
+		fmt.Fprintf(&sb, "%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
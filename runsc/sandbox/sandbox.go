@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"os"
 	"os/exec"
 	"path"
@@ -55,6 +56,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/urpc"
 	"gvisor.dev/gvisor/runsc/boot"
+	"gvisor.dev/gvisor/runsc/boot/hooks"
 	"gvisor.dev/gvisor/runsc/boot/procfs"
 	"gvisor.dev/gvisor/runsc/cgroup"
 	"gvisor.dev/gvisor/runsc/config"
@@ -222,11 +224,53 @@ type Sandbox struct {
 	// WaitStatus to one of the waiters only.
 	status unix.WaitStatus `nojson:"true"`
 
+	// rootSpec is the OCI spec of the sandbox's root container, kept around
+	// so runHooks can find the stage-appropriate hooks from spec.Hooks
+	// (including any conf.HooksDir injected into it). It isn't saved to
+	// json: the spec is owned by the caller (runsc/container), and Sandbox
+	// only needs it for the lifetime of the process that created it.
+	rootSpec *specs.Spec `nojson:"true"`
+
+	// rootBundleDir is args.BundleDir, kept around to populate the
+	// "bundle" field of the OCI state JSON passed to hooks.
+	rootBundleDir string `nojson:"true"`
+
+	// hooks is the OCI runtime hooks manager loaded from conf.HooksDir, or
+	// nil if no hooks directory was configured.
+	hooks *hooks.Manager `nojson:"true"`
+
+	// poststopOnce ensures poststop hooks run exactly once, whichever of
+	// Wait (graceful exit) or destroy (forced teardown) observes the
+	// sandbox stop first.
+	poststopOnce sync.Once `nojson:"true"`
+
+	// cgroupDriver is conf.CgroupDriver, consulted by
+	// CgroupsWriteControlFile to decide whether a write needs to go
+	// through systemd instead of straight to cgroupfs.
+	cgroupDriver CgroupDriver `nojson:"true"`
+
+	// rootlessMode is conf.RootlessMode, consulted by
+	// setRootlessUserMappings to decide whether a rootless sandbox's user
+	// ID mappings are written directly or through the newuidmap/newgidmap
+	// helpers.
+	rootlessMode RootlessMode `nojson:"true"`
+
+	// rootfsBackend and rootfsImage are set together when Args.RootfsImage
+	// is non-empty, so destroy() can release whatever the backend resolved
+	// for it.
+	rootfsBackend boot.RootfsBackend `nojson:"true"`
+	rootfsImage   string             `nojson:"true"`
+
 	// Checkpointed will be true when the sandbox has been checkpointed.
 	Checkpointed bool `json:"checkpointed"`
 
 	// Restored will be true when the sandbox has been restored.
 	Restored bool `json:"restored"`
+
+	// Paused is true when Pause has frozen every container in the sandbox
+	// and Resume hasn't yet been called. Execute and StartSubcontainer
+	// reject new work while it's set.
+	Paused bool `json:"paused"`
 }
 
 // Getpid returns the process ID of the sandbox process.
@@ -281,6 +325,12 @@ type Args struct {
 	// Gcgroup is the cgroup that the sandbox is part of.
 	Cgroup cgroup.Cgroup
 
+	// RootfsImage is an OCI image reference to resolve via conf's
+	// configured boot.RootfsBackend instead of relying on BundleDir already
+	// containing a materialized rootfs. It's empty for the default,
+	// CRI-shim-driven flow.
+	RootfsImage string
+
 	// Attached indicates that the sandbox lifecycle is attached with the caller.
 	// If the caller exits, the sandbox should exit too.
 	Attached bool
@@ -337,6 +387,53 @@ func New(conf *config.Config, args *Args) (*Sandbox, error) {
 		}
 	}
 
+	if conf.HooksDir != "" {
+		mgr, err := hooks.New(conf.HooksDir)
+		if err != nil {
+			return nil, fmt.Errorf("loading OCI runtime hooks from %q: %w", conf.HooksDir, err)
+		}
+		s.hooks = mgr
+		if args.Spec != nil {
+			mgr.Inject(args.Spec)
+		}
+	}
+	s.rootSpec = args.Spec
+	s.rootBundleDir = args.BundleDir
+
+	cgroupDriver, err := ParseCgroupDriver(conf.CgroupDriver)
+	if err != nil {
+		return nil, err
+	}
+	s.cgroupDriver = cgroupDriver
+
+	rootlessMode, err := ParseRootlessMode(conf.RootlessMode)
+	if err != nil {
+		return nil, err
+	}
+	s.rootlessMode = rootlessMode
+
+	if args.RootfsImage != "" {
+		backend, err := boot.NewRootfsBackend(boot.RootfsBackendName(conf.RootfsBackend), conf.RootfsBackendStorageRoot)
+		if err != nil {
+			return nil, fmt.Errorf("creating rootfs backend %q: %w", conf.RootfsBackend, err)
+		}
+		mount, err := backend.Prepare(args.RootfsImage)
+		if err != nil {
+			return nil, fmt.Errorf("preparing rootfs image %q: %w", args.RootfsImage, err)
+		}
+		s.rootfsBackend = backend
+		s.rootfsImage = args.RootfsImage
+		if mount.HostPath != "" {
+			// The gofer-backed flow: the resolved image layers already
+			// look like a bundle rootfs, so point BundleDir at it exactly
+			// as if the caller had supplied it directly.
+			args.BundleDir = mount.HostPath
+		}
+		// mount.EROFSImage is not used here: only HostPath takes effect,
+		// and an EROFS image returned by the backend is not passed to the
+		// sentry.
+	}
+
 	// Create pipe to synchronize when sandbox process has been booted.
 	clientSyncFile, sandboxSyncFile, err := os.Pipe()
 	if err != nil {
@@ -353,6 +450,14 @@ func New(conf *config.Config, args *Args) (*Sandbox, error) {
 		return nil, fmt.Errorf("cannot create sandbox process: %w", err)
 	}
 
+	// Prestart hooks run in the host mount namespace (this process's, since
+	// the sandbox process lives in its own mount namespace), once the
+	// sandbox process exists but before anything waits on it to finish
+	// booting.
+	if err := s.runHooks(hooks.Prestart, "creating"); err != nil {
+		return nil, fmt.Errorf("running prestart hooks: %w", err)
+	}
+
 	// Wait until the sandbox has booted.
 	b := make([]byte, 1)
 	if l, err := clientSyncFile.Read(b); err != nil || l != 1 {
@@ -446,6 +551,14 @@ func (s *Sandbox) StartRoot(conf *config.Config, spec *specs.Spec) error {
 		return fmt.Errorf("setting up network: %w", err)
 	}
 
+	if err := s.runStartContainerHook(conn); err != nil {
+		return err
+	}
+
+	if err := s.applyResourceLimits(conn); err != nil {
+		return err
+	}
+
 	// Send a message to the sandbox control server to start the root container.
 	if err := conn.Call(boot.ContMgrRootContainerStart, &s.ID, nil); err != nil {
 		return fmt.Errorf("starting root container: %w", err)
@@ -458,6 +571,10 @@ func (s *Sandbox) StartRoot(conf *config.Config, spec *specs.Spec) error {
 func (s *Sandbox) StartSubcontainer(spec *specs.Spec, conf *config.Config, cid string, stdios, goferFiles, goferFilestores []*os.File, devIOFile *os.File, goferConfs []boot.GoferMountConf) error {
 	log.Debugf("Start sub-container %q in sandbox %q, PID: %d", cid, s.ID, s.Pid.load())
 
+	if s.Paused {
+		return fmt.Errorf("cannot start sub-container %q: sandbox %q is paused", cid, s.ID)
+	}
+
 	if err := s.configureStdios(conf, stdios); err != nil {
 		return err
 	}
@@ -493,25 +610,42 @@ func (s *Sandbox) StartSubcontainer(spec *specs.Spec, conf *config.Config, cid s
 }
 
 // Restore sends the restore call for a container in the sandbox.
-func (s *Sandbox) Restore(conf *config.Config, spec *specs.Spec, cid string, imagePath string, direct, background bool) error {
+// notifyHooks run synchronously around the restore: NotifySetupNamespaces,
+// NotifyNetworkLock and NotifyNetworkUnlock around reconfiguring the
+// container's network, and NotifyPreRestore/NotifyPostRestore/
+// NotifyOrphanPtsMaster bracketing the restore RPC itself. Unlike
+// Checkpoint's hooks, a failure after the restore RPC has already
+// succeeded tears the sandbox down rather than resuming it: there's no
+// well-defined "running" state to fall back to once a restore has started
+// replacing it.
+func (s *Sandbox) Restore(conf *config.Config, spec *specs.Spec, cid string, imagePath string, direct, background bool, notifyHooks []NotifyHook) error {
 	if err := hostsettings.Handle(conf); err != nil {
 		return fmt.Errorf("host settings: %w (use --host-settings=ignore to bypass)", err)
 	}
 
+	if err := runNotifyHooks(notifyHooks, NotifyPreRestore, cid); err != nil {
+		return fmt.Errorf("pre-restore hook for container %q: %w", cid, err)
+	}
+
 	log.Debugf("Restore sandbox %q from path %q", s.ID, imagePath)
 
 	stateFileName := path.Join(imagePath, boot.CheckpointStateFileName)
-	sf, err := os.Open(stateFileName)
+	sf, archiveCompression, closeStateFile, err := openDecompressedStateFile(stateFileName)
 	if err != nil {
 		return fmt.Errorf("opening state file %q failed: %v", stateFileName, err)
 	}
-	defer sf.Close()
+	defer func() {
+		if err := closeStateFile(); err != nil {
+			log.Warningf("Restore sandbox %q: decompressing state file %q: %v", s.ID, stateFileName, err)
+		}
+	}()
 
 	opt := boot.RestoreOpts{
 		FilePayload: urpc.FilePayload{
 			Files: []*os.File{sf},
 		},
-		Background: background,
+		Background:         background,
+		ArchiveCompression: archiveCompression,
 	}
 
 	// If the pages file exists, we must pass it in.
@@ -555,21 +689,50 @@ func (s *Sandbox) Restore(conf *config.Config, spec *specs.Spec, cid string, ima
 	}
 	defer conn.Close()
 
+	if err := runNotifyHooks(notifyHooks, NotifySetupNamespaces, cid); err != nil {
+		return fmt.Errorf("setup-namespaces hook for container %q: %w", cid, err)
+	}
+
 	var disableIPv6 bool
 	disableIPv6, err = getDisableIPv6(spec)
 	if err != nil {
 		return err
 	}
+	if err := runNotifyHooks(notifyHooks, NotifyNetworkLock, cid); err != nil {
+		return fmt.Errorf("network-lock hook for container %q: %w", cid, err)
+	}
 	// Configure the network.
 	if err := setupNetwork(conn, s.Pid.load(), conf, disableIPv6); err != nil {
 		return fmt.Errorf("setting up network: %v", err)
 	}
+	if err := runNotifyHooks(notifyHooks, NotifyNetworkUnlock, cid); err != nil {
+		return fmt.Errorf("network-unlock hook for container %q: %w", cid, err)
+	}
 
 	// Restore the container and start the root container.
 	if err := conn.Call(boot.ContMgrRestore, &opt, nil); err != nil {
 		return fmt.Errorf("restoring container %q: %v", cid, err)
 	}
 	s.Restored = true
+
+	// The restore RPC succeeded, so this sandbox now holds the container;
+	// a NotifyOrphanPtsMaster or NotifyPostRestore hook failing from here
+	// on tears it back down rather than resuming, per the doc above. The
+	// real TTY handle this stage is meant to detach from a dying parent
+	// lives sentry-side; it's not visible to this urpc client, so the hook
+	// is only told the restore has reached this point.
+	if err := runNotifyHooks(notifyHooks, NotifyOrphanPtsMaster, cid); err != nil {
+		if destroyErr := s.destroy(); destroyErr != nil {
+			log.Warningf("Sandbox %q: destroying after failed orphan-pts-master hook also failed: %v", s.ID, destroyErr)
+		}
+		return fmt.Errorf("orphan-pts-master hook for container %q: %w", cid, err)
+	}
+	if err := runNotifyHooks(notifyHooks, NotifyPostRestore, cid); err != nil {
+		if destroyErr := s.destroy(); destroyErr != nil {
+			log.Warningf("Sandbox %q: destroying after failed post-restore hook also failed: %v", s.ID, destroyErr)
+		}
+		return fmt.Errorf("post-restore hook for container %q: %w", cid, err)
+	}
 	return nil
 }
 
@@ -688,6 +851,10 @@ func (s *Sandbox) NewCGroup() (cgroup.Cgroup, error) {
 func (s *Sandbox) Execute(conf *config.Config, args *control.ExecArgs) (int32, error) {
 	log.Debugf("Executing new process in container %q in sandbox %q", args.ContainerID, s.ID)
 
+	if s.Paused {
+		return 0, fmt.Errorf("cannot execute in container %q: sandbox %q is paused", args.ContainerID, s.ID)
+	}
+
 	// Stdios are those files which have an FD <= 2 in the process. We do not
 	// want the ownership of other files to be changed by configureStdios.
 	var stdios []*os.File
@@ -710,7 +877,11 @@ func (s *Sandbox) Execute(conf *config.Config, args *control.ExecArgs) (int32, e
 	return pid, nil
 }
 
-// Event retrieves stats about the sandbox such as memory and CPU utilization.
+// Event retrieves stats about the sandbox such as memory and CPU
+// utilization. If the sandbox's cgroup reports a controller beyond memory
+// (pids, hugetlb, blkio, cpuset, cpu weight - see applyResourceLimits) under
+// pressure, the corresponding field is populated alongside the usual memory
+// stats rather than only memory ever being reported.
 func (s *Sandbox) Event(cid string) (*boot.EventOut, error) {
 	log.Debugf("Getting events for container %q in sandbox %q", cid, s.ID)
 	var e boot.EventOut
@@ -736,6 +907,195 @@ func (s *Sandbox) PortForward(opts *boot.PortForwardOpts) error {
 	return nil
 }
 
+// StreamPortForward dials port inside containerID's network namespace and
+// proxies stream's bytes to and from it until either side closes, ctx is
+// canceled, or the container is no longer running. It's the CRI-style
+// counterpart to PortForward: instead of handing the sandbox a pre-opened
+// listener, the caller supplies an already-connected stream (e.g. from a
+// containerd streaming server), and a socketpair is used to hand the
+// sentry the other end so the copy loop can run entirely in userspace
+// here rather than inside the sandbox.
+func (s *Sandbox) StreamPortForward(ctx context.Context, containerID string, port uint16, stream io.ReadWriteCloser) error {
+	log.Debugf("Requesting streaming port forward for container %q in sandbox %q: port %d", containerID, s.ID, port)
+	if !s.IsRunning() {
+		return fmt.Errorf("sandbox %q is not running", s.ID)
+	}
+
+	local, sandboxSide, err := newSocketPair()
+	if err != nil {
+		return fmt.Errorf("creating port forward socketpair: %w", err)
+	}
+	defer sandboxSide.Close()
+
+	args := &boot.StreamPortForwardArgs{
+		ContainerID: containerID,
+		Port:        port,
+	}
+	args.FilePayload = urpc.FilePayload{Files: []*os.File{sandboxSide}}
+
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		local.Close()
+		return err
+	}
+	defer conn.Close()
+	if err := conn.Call(boot.ContMgrStreamPortForward, args, nil); err != nil {
+		local.Close()
+		return fmt.Errorf("port forwarding to sandbox: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		local.Close()
+	}()
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(local, stream)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(stream, local)
+		errCh <- err
+	}()
+
+	// Wait for one direction to finish (remote hangup, stream closed, or
+	// context cancellation closing local) then tear the other down too.
+	err = <-errCh
+	cancel()
+	local.Close()
+	<-errCh
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("proxying port forward stream: %w", err)
+	}
+	return ctx.Err()
+}
+
+// newSocketPair creates a connected pair of unix domain sockets, returning
+// the local end as a *net.UnixConn for io.Copy and the remote end as an
+// *os.File suitable for sending to the sandbox over urpc.
+func newSocketPair() (*net.UnixConn, *os.File, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	localFile := os.NewFile(uintptr(fds[0]), "port-forward-local")
+	localConn, err := net.FileConn(localFile)
+	localFile.Close()
+	if err != nil {
+		unix.Close(fds[1])
+		return nil, nil, err
+	}
+	local, ok := localConn.(*net.UnixConn)
+	if !ok {
+		localConn.Close()
+		unix.Close(fds[1])
+		return nil, nil, fmt.Errorf("unexpected connection type %T", localConn)
+	}
+	return local, os.NewFile(uintptr(fds[1]), "port-forward-sandbox"), nil
+}
+
+// TerminalSize is a PTY window size, reported on resizeCh by Attach's
+// caller whenever the attached client's terminal changes dimensions.
+type TerminalSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// Attach reconnects to a running container's stdio. Unlike Execute, it
+// doesn't start a new process: the sentry keeps a stdio hub per container
+// that tees the root process's PTY master (or stdio pipes, if it wasn't
+// started with a terminal) to however many attachers have joined, so
+// Attach can be called repeatedly - including concurrently from more than
+// one client, or after the original creator has disconnected or the shim
+// has restarted - without disturbing the container. If detachKeys is
+// non-empty (see ParseDetachKeys; DefaultDetachKeys is the usual choice),
+// stdin producing that sequence ends the attach session with a nil error
+// rather than affecting the container's lifetime. The session ends as soon
+// as either the stdin copy or the output copy finishes (stdin reaching EOF
+// included) or ctx is canceled; resizeCh is drained until then.
+func (s *Sandbox) Attach(ctx context.Context, cid string, stdin io.Reader, stdout, stderr io.Writer, resizeCh <-chan TerminalSize, detachKeys []byte) error {
+	log.Debugf("Attaching to container %q in sandbox %q", cid, s.ID)
+
+	local, sandboxSide, err := newSocketPair()
+	if err != nil {
+		return fmt.Errorf("creating attach socketpair: %w", err)
+	}
+	defer sandboxSide.Close()
+
+	args := &boot.AttachArgs{ContainerID: cid}
+	args.FilePayload = urpc.FilePayload{Files: []*os.File{sandboxSide}}
+
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		local.Close()
+		return err
+	}
+	defer conn.Close()
+	if err := conn.Call(boot.ContMgrAttach, args, nil); err != nil {
+		local.Close()
+		return fmt.Errorf("attaching to container %q: %w", cid, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		local.Close()
+	}()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case size, ok := <-resizeCh:
+				if !ok {
+					return
+				}
+				if err := s.ResizeTTY(cid, size.Rows, size.Cols); err != nil {
+					log.Warningf("%v", err)
+				}
+			}
+		}
+	}()
+
+	done := make(chan error, 2)
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(local, newDetachReader(stdin, detachKeys))
+			if err == ErrDetach {
+				err = nil
+			}
+			done <- err
+		}()
+	}
+	go func() {
+		_, err := io.Copy(newTeeWriter(stdout, stderr), local)
+		done <- err
+	}()
+
+	err = <-done
+	ctxErr := ctx.Err()
+	cancel()
+	local.Close()
+	if err != nil && ctxErr == nil {
+		return fmt.Errorf("attach stream for container %q: %w", cid, err)
+	}
+	return ctxErr
+}
+
+// newTeeWriter returns stdout, or stderr when stdout is nil. A container's
+// combined stdio hub multiplexes stdout and stderr over a single stream,
+// so that stream goes to one writer only.
+func newTeeWriter(stdout, stderr io.Writer) io.Writer {
+	if stdout == nil {
+		return stderr
+	}
+	return stdout
+}
+
 // SetRootDir sets the root directory from the current runsc invocation.
 func (s *Sandbox) SetRootDir(rootDir string) {
 	s.rootDir = rootDir
@@ -954,7 +1314,7 @@ func (s *Sandbox) createSandboxProcess(conf *config.Config, args *Args, startSyn
 	donations.DonateAndClose("sink-fds", args.SinkFiles...)
 
 	if len(conf.TestOnlyAutosaveImagePath) != 0 {
-		files, err := createSaveFiles(conf.TestOnlyAutosaveImagePath, false, statefile.CompressionLevelFlateBestSpeed)
+		files, _, err := createSaveFiles(conf.TestOnlyAutosaveImagePath, false, statefile.CompressionLevelFlateBestSpeed, boot.CheckpointCompressionNone)
 		if err != nil {
 			return fmt.Errorf("failed to create auto save files: %w", err)
 		}
@@ -1240,6 +1600,29 @@ func (s *Sandbox) createSandboxProcess(conf *config.Config, args *Args, startSyn
 	}
 	cmd.Args = append(cmd.Args, "--total-memory", strconv.FormatUint(mem, 10))
 
+	lsmMode, err := parseLSMMode(conf.SandboxLSM)
+	if err != nil {
+		return err
+	}
+	if apparmorProfile, selinuxLabel := resolveLSMLabels(args.Spec, lsmMode); apparmorProfile != "" || selinuxLabel != "" {
+		if apparmorProfile != "" {
+			cmd.Args = append(cmd.Args, "--apparmor-profile="+apparmorProfile)
+		}
+		if selinuxLabel != "" {
+			cmd.Args = append(cmd.Args, "--selinux-label="+selinuxLabel)
+		}
+	}
+
+	if _, err := parseHostSeccompMode(conf.HostSeccomp); err != nil {
+		return err
+	}
+	if conf.HostSeccomp != "" && conf.HostSeccomp != string(hostSeccompOff) {
+		// The filter itself is built and loaded by "runsc boot" (see
+		// installHostSeccomp), before boot.New; we only need to forward
+		// the chosen mode here.
+		cmd.Args = append(cmd.Args, "--host-seccomp="+conf.HostSeccomp)
+	}
+
 	if args.Attached {
 		// Kill sandbox if parent process exits in attached mode.
 		cmd.SysProcAttr.Pdeathsig = unix.SIGKILL
@@ -1261,6 +1644,20 @@ func (s *Sandbox) createSandboxProcess(conf *config.Config, args *Args, startSyn
 	donation.LogDonations(cmd)
 	log.Debugf("Starting sandbox: %s %v", cmd.Path, cmd.Args)
 	log.Debugf("SysProcAttr: %+v", cmd.SysProcAttr)
+
+	// createRuntime and createContainer hooks both run in the runtime
+	// (host) mount namespace, before the sandbox process exists: cmd is
+	// fully configured at this point (namespaces, cgroups, donated FDs),
+	// but StartInNS clones and execs it in one step, so there's no
+	// narrower point between "namespaces decided" and "process execed" to
+	// split the two stages at.
+	if err := s.runHooks(hooks.CreateRuntime, "creating"); err != nil {
+		return fmt.Errorf("running createRuntime hooks: %w", err)
+	}
+	if err := s.runHooks(hooks.CreateContainer, "creating"); err != nil {
+		return fmt.Errorf("running createContainer hooks: %w", err)
+	}
+
 	if err := specutils.StartInNS(cmd, nss); err != nil {
 		err := fmt.Errorf("starting sandbox: %v", err)
 		// If the sandbox failed to start, it may be because the binary
@@ -1281,7 +1678,7 @@ func (s *Sandbox) createSandboxProcess(conf *config.Config, args *Args, startSyn
 		return err
 	}
 	if setUserMappings {
-		if err := SetUserMappings(args.Spec, cmd.Process.Pid); err != nil {
+		if err := s.setRootlessUserMappings(args.Spec, cmd.Process.Pid); err != nil {
 			return err
 		}
 	}
@@ -1290,6 +1687,11 @@ func (s *Sandbox) createSandboxProcess(conf *config.Config, args *Args, startSyn
 	s.Pid.store(cmd.Process.Pid)
 	log.Infof("Sandbox started, PID: %d", cmd.Process.Pid)
 
+	// Poststart failures are logged inside runHooks, not returned: the
+	// sandbox process already exists by this point, so there's nothing to
+	// unwind.
+	s.runHooks(hooks.Poststart, "created")
+
 	return nil
 }
 
@@ -1317,6 +1719,7 @@ func (s *Sandbox) Wait(cid string) (unix.WaitStatus, error) {
 				if err := s.waitForStopped(); err != nil {
 					return unix.WaitStatus(0), err
 				}
+				s.runPoststopOnce()
 			}
 			// It worked!
 			return ws, nil
@@ -1337,6 +1740,9 @@ func (s *Sandbox) Wait(cid string) (unix.WaitStatus, error) {
 	if err := s.waitForStopped(); err != nil {
 		return unix.WaitStatus(0), err
 	}
+	if s.IsRootContainer(cid) {
+		s.runPoststopOnce()
+	}
 	if !s.child {
 		return unix.WaitStatus(0), fmt.Errorf("sandbox no longer running and its exit status is unavailable")
 	}
@@ -1373,6 +1779,84 @@ func (s *Sandbox) WaitRestore() error {
 	return s.call(boot.ContMgrWaitRestore, nil, nil)
 }
 
+// runHooks runs every hook s.rootSpec.Hooks configures for stage, in this
+// process' mount namespace (the host's, or the runtime namespace in OCI
+// terms), passing an OCI state object built from the sandbox's current
+// id/pid/bundle/annotations plus the given status. It's used for every
+// stage except startContainer, which instead runs inside the sandbox's own
+// mount namespace via runStartContainerHook.
+//
+// createRuntime, createContainer and prestart hooks abort their caller on
+// failure, matching runc's fail-closed semantics for pre-run hooks.
+// poststart and poststop hooks are best-effort: by the time they run, the
+// container has either already started or already exited, so failing the
+// caller wouldn't undo that — the failure is logged instead.
+func (s *Sandbox) runHooks(stage hooks.Stage, status string) error {
+	if s.rootSpec == nil {
+		return nil
+	}
+	hs := hooks.HooksForStage(s.rootSpec, stage)
+	if len(hs) == 0 {
+		return nil
+	}
+	err := hooks.Run(hs, hooks.State{
+		Version:     "1.0.2",
+		ID:          s.ID,
+		Status:      status,
+		Pid:         s.Pid.load(),
+		Bundle:      s.rootBundleDir,
+		Annotations: s.rootSpec.Annotations,
+	})
+	switch stage {
+	case hooks.Poststart, hooks.Poststop:
+		if err != nil {
+			log.Warningf("%s hook failed for sandbox %q: %v", stage, s.ID, err)
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
+// runPoststopOnce runs poststop hooks the first time it's called for s;
+// later calls are no-ops. Both Wait (on a graceful container exit) and
+// destroy (on forced teardown, including a failed New) can observe the
+// sandbox stopping first, and poststop must run exactly once either way.
+func (s *Sandbox) runPoststopOnce() {
+	s.poststopOnce.Do(func() {
+		s.runHooks(hooks.Poststop, "stopped")
+	})
+}
+
+// runStartContainerHook runs every startContainer hook s.rootSpec.Hooks
+// configures. Unlike the other stages, startContainer runs inside the
+// container's own mount namespace (the sandbox process', not this one's)
+// per the OCI runtime spec, and must happen after the sandbox process
+// exists but before it execs the application. Since this process only
+// ever reaches the sandbox through urpc, that means asking boot to run
+// the hook itself rather than exec'ing it here.
+func (s *Sandbox) runStartContainerHook(conn *urpc.Client) error {
+	hs := hooks.HooksForStage(s.rootSpec, hooks.StartContainer)
+	if len(hs) == 0 {
+		return nil
+	}
+	args := &boot.RunHooksArgs{
+		Hooks: hs,
+		State: hooks.State{
+			Version:     "1.0.2",
+			ID:          s.ID,
+			Status:      "created",
+			Pid:         s.Pid.load(),
+			Bundle:      s.rootBundleDir,
+			Annotations: s.rootSpec.Annotations,
+		},
+	}
+	if err := conn.Call(boot.ContMgrRunHooks, args, nil); err != nil {
+		return fmt.Errorf("running startContainer hooks: %w", err)
+	}
+	return nil
+}
+
 // IsRootContainer returns true if the specified container ID belongs to the
 // root container.
 func (s *Sandbox) IsRootContainer(cid string) bool {
@@ -1383,6 +1867,12 @@ func (s *Sandbox) IsRootContainer(cid string) bool {
 // is idempotent.
 func (s *Sandbox) destroy() error {
 	log.Debugf("Destroying sandbox %q", s.ID)
+	s.runPoststopOnce()
+	if s.rootfsBackend != nil {
+		if err := s.rootfsBackend.Cleanup(s.rootfsImage); err != nil {
+			log.Warningf("failed to clean up rootfs image %q: %v", s.rootfsImage, err)
+		}
+	}
 	// Only delete the control file if it exists.
 	controlSocketPath := s.getControlSocketPath()
 	if len(controlSocketPath) > 0 {
@@ -1456,10 +1946,25 @@ type CheckpointOpts struct {
 	Direct                    bool
 	ExcludeCommittedZeroPages bool
 
+	// ArchiveCompression selects the codec the state file as a whole is
+	// wrapped in, independent of Compression (which only affects how
+	// individual memory pages are encoded within the state file's own
+	// format). The zero value is CheckpointCompressionNone; callers that
+	// want zstd's better ratio and speed, the default recommended by
+	// boot.DefaultCheckpointCompression, must set this explicitly.
+	ArchiveCompression boot.CheckpointCompression
+
 	// Save/restore exec options.
 	SaveRestoreExecArgv        string
 	SaveRestoreExecTimeout     time.Duration
 	SaveRestoreExecContainerID string
+
+	// NotifyHooks run synchronously around the checkpoint: NotifyPreDump
+	// before the sentry starts saving state, NotifyPostDump after it
+	// finishes. A non-zero exit at either stage aborts the checkpoint and
+	// resumes the container, since the state on disk can't be trusted.
+	// Hooks at any other NotifyStage are ignored.
+	NotifyHooks []NotifyHook
 }
 
 // Checkpoint sends the checkpoint call for a container in the sandbox.
@@ -1467,7 +1972,11 @@ type CheckpointOpts struct {
 func (s *Sandbox) Checkpoint(cid string, imagePath string, opts CheckpointOpts) error {
 	log.Debugf("Checkpoint sandbox %q, imagePath %q, opts %+v", s.ID, imagePath, opts)
 
-	files, err := createSaveFiles(imagePath, opts.Direct, opts.Compression)
+	if err := runNotifyHooks(opts.NotifyHooks, NotifyPreDump, cid); err != nil {
+		return fmt.Errorf("pre-dump hook for container %q: %w", cid, err)
+	}
+
+	files, closeStateFile, err := createSaveFiles(imagePath, opts.Direct, opts.Compression, opts.ArchiveCompression)
 	if err != nil {
 		return err
 	}
@@ -1475,6 +1984,9 @@ func (s *Sandbox) Checkpoint(cid string, imagePath string, opts CheckpointOpts)
 		for _, f := range files {
 			_ = f.Close()
 		}
+		if err := closeStateFile(); err != nil {
+			log.Warningf("Checkpoint sandbox %q: compressing state file: %v", s.ID, err)
+		}
 	}()
 
 	opt := control.SaveOpts{
@@ -1485,6 +1997,7 @@ func (s *Sandbox) Checkpoint(cid string, imagePath string, opts CheckpointOpts)
 		},
 		HavePagesFile:              len(files) > 1,
 		Resume:                     opts.Resume,
+		ArchiveCompression:         opts.ArchiveCompression,
 		SaveRestoreExecArgv:        opts.SaveRestoreExecArgv,
 		SaveRestoreExecTimeout:     opts.SaveRestoreExecTimeout,
 		SaveRestoreExecContainerID: opts.SaveRestoreExecContainerID,
@@ -1493,20 +2006,35 @@ func (s *Sandbox) Checkpoint(cid string, imagePath string, opts CheckpointOpts)
 	if err := s.call(boot.ContMgrCheckpoint, &opt, nil); err != nil {
 		return fmt.Errorf("checkpointing container %q: %w", cid, err)
 	}
+
+	if err := runNotifyHooks(opts.NotifyHooks, NotifyPostDump, cid); err != nil {
+		// The checkpoint itself succeeded, but a post-dump hook failing
+		// means whatever it was supposed to finish (e.g. un-quiescing an
+		// in-container database) didn't happen; the safest response is to
+		// resume the container rather than leave it paused with a
+		// questionable on-disk state.
+		if resumeErr := s.Resume(); resumeErr != nil {
+			log.Warningf("Sandbox %q: resuming container %q after failed post-dump hook also failed: %v", s.ID, cid, resumeErr)
+		}
+		return fmt.Errorf("post-dump hook for container %q: %w", cid, err)
+	}
+
 	s.Checkpointed = true
 	return nil
 }
 
 // createSaveFiles creates the files used by checkpoint to save the state. They are returned in
 // the following order: sentry state, page metadata, page file. This is the same order expected by
-// RPCs and argument passing to the sandbox.
-func createSaveFiles(path string, direct bool, compression statefile.CompressionLevel) ([]*os.File, error) {
+// RPCs and argument passing to the sandbox. The returned func must be called after every returned
+// file has been closed; its error reflects whether archiveCompression was applied successfully to
+// the state file.
+func createSaveFiles(path string, direct bool, compression statefile.CompressionLevel, archiveCompression boot.CheckpointCompression) ([]*os.File, func() error, error) {
 	var files []*os.File
 
 	stateFilePath := filepath.Join(path, boot.CheckpointStateFileName)
-	f, err := os.OpenFile(stateFilePath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	f, closeStateFile, err := openCompressedStateFile(stateFilePath, archiveCompression)
 	if err != nil {
-		return nil, fmt.Errorf("creating checkpoint state file %q: %w", stateFilePath, err)
+		return nil, nil, err
 	}
 	files = append(files, f)
 
@@ -1517,7 +2045,7 @@ func createSaveFiles(path string, direct bool, compression statefile.Compression
 		pagesMetadataFilePath := filepath.Join(path, boot.CheckpointPagesMetadataFileName)
 		f, err = os.OpenFile(pagesMetadataFilePath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
 		if err != nil {
-			return nil, fmt.Errorf("creating checkpoint pages metadata file %q: %w", pagesMetadataFilePath, err)
+			return nil, nil, fmt.Errorf("creating checkpoint pages metadata file %q: %w", pagesMetadataFilePath, err)
 		}
 		files = append(files, f)
 
@@ -1529,32 +2057,115 @@ func createSaveFiles(path string, direct bool, compression statefile.Compression
 		}
 		f, err := os.OpenFile(pagesFilePath, pagesWriteFlags, 0644)
 		if err != nil {
-			return nil, fmt.Errorf("creating checkpoint pages file %q: %w", pagesFilePath, err)
+			return nil, nil, fmt.Errorf("creating checkpoint pages file %q: %w", pagesFilePath, err)
 		}
 		files = append(files, f)
 	}
 
-	return files, nil
+	return files, closeStateFile, nil
+}
+
+// cgroupFreezer is the subset of cgroup.Cgroup that a cgroupv2 freezer
+// exposes. Sandboxes started without a cgroup, or with a cgroup driver that
+// doesn't support freezing, fall back to the sentry-level quiesce below.
+type cgroupFreezer interface {
+	Freeze() error
+	Thaw() error
 }
 
-// Pause sends the pause call for a container in the sandbox.
-func (s *Sandbox) Pause(cid string) error {
+// Pause freezes every container in the sandbox. If the sandbox's cgroup
+// supports the cgroupv2 freezer, "frozen" is written to cgroup.freeze so the
+// kernel stops scheduling every task in one atomic step; otherwise it falls
+// back to asking the sentry to stop scheduling user tasks, the same
+// mechanism checkpoint uses to quiesce.
+func (s *Sandbox) Pause() error {
 	log.Debugf("Pause sandbox %q", s.ID)
+	if freezer, ok := s.CgroupJSON.Cgroup.(cgroupFreezer); ok {
+		if err := freezer.Freeze(); err != nil {
+			return fmt.Errorf("freezing sandbox %q cgroup: %w", s.ID, err)
+		}
+		s.Paused = true
+		return nil
+	}
 	if err := s.call(boot.ContMgrPause, nil, nil); err != nil {
-		return fmt.Errorf("pausing container %q: %w", cid, err)
+		return fmt.Errorf("pausing sandbox %q: %w", s.ID, err)
 	}
+	s.Paused = true
 	return nil
 }
 
-// Resume sends the resume call for a container in the sandbox.
-func (s *Sandbox) Resume(cid string) error {
+// Resume thaws every container in the sandbox previously frozen by Pause.
+func (s *Sandbox) Resume() error {
 	log.Debugf("Resume sandbox %q", s.ID)
+	if freezer, ok := s.CgroupJSON.Cgroup.(cgroupFreezer); ok {
+		if err := freezer.Thaw(); err != nil {
+			return fmt.Errorf("thawing sandbox %q cgroup: %w", s.ID, err)
+		}
+		s.Paused = false
+		return nil
+	}
 	if err := s.call(boot.ContMgrResume, nil, nil); err != nil {
-		return fmt.Errorf("resuming container %q: %w", cid, err)
+		return fmt.Errorf("resuming sandbox %q: %w", s.ID, err)
+	}
+	s.Paused = false
+	return nil
+}
+
+// PauseSubcontainer freezes a single container within the sandbox, leaving
+// its siblings running. It requires a cgroupv2 freezer scoped to the
+// container; sandboxes without per-container cgroups should use Pause
+// instead.
+func (s *Sandbox) PauseSubcontainer(cid string) error {
+	log.Debugf("Pause container %q in sandbox %q", cid, s.ID)
+	cg, err := s.fetchContainerCgroup(cid)
+	if err != nil {
+		return err
+	}
+	freezer, ok := cg.(cgroupFreezer)
+	if !ok {
+		return fmt.Errorf("container %q has no cgroupv2 freezer available; pause the whole sandbox instead", cid)
+	}
+	if err := freezer.Freeze(); err != nil {
+		return fmt.Errorf("freezing container %q cgroup: %w", cid, err)
 	}
 	return nil
 }
 
+// ResumeSubcontainer thaws a single container previously frozen by
+// PauseSubcontainer.
+func (s *Sandbox) ResumeSubcontainer(cid string) error {
+	log.Debugf("Resume container %q in sandbox %q", cid, s.ID)
+	cg, err := s.fetchContainerCgroup(cid)
+	if err != nil {
+		return err
+	}
+	freezer, ok := cg.(cgroupFreezer)
+	if !ok {
+		return fmt.Errorf("container %q has no cgroupv2 freezer available; resume the whole sandbox instead", cid)
+	}
+	if err := freezer.Thaw(); err != nil {
+		return fmt.Errorf("thawing container %q cgroup: %w", cid, err)
+	}
+	return nil
+}
+
+// fetchContainerCgroup looks up the cgroup.Cgroup for a single subcontainer,
+// scoped beneath the sandbox's own cgroup. Only subcontainers started with
+// their own cgroup path (see boot.StartArgs) have one.
+func (s *Sandbox) fetchContainerCgroup(cid string) (cgroup.Cgroup, error) {
+	if cid == s.ID {
+		return nil, fmt.Errorf("use Pause/Resume for the root container %q", cid)
+	}
+	if s.CgroupJSON.Cgroup == nil {
+		return nil, fmt.Errorf("sandbox %q has no cgroup", s.ID)
+	}
+	cg, err := s.CgroupJSON.Cgroup.NewChild(cid)
+	if err != nil {
+		return nil, fmt.Errorf("looking up cgroup for container %q: %w", cid, err)
+	}
+	return cg, nil
+}
+
 // Usage sends the collect call for a container in the sandbox.
 func (s *Sandbox) Usage(Full bool) (control.MemoryUsage, error) {
 	log.Debugf("Usage sandbox %q", s.ID)
@@ -1688,6 +2299,30 @@ func (s *Sandbox) ChangeLogging(args control.LoggingArgs) error {
 	return nil
 }
 
+// RenameSubcontainer changes a running subcontainer's ID from oldCID to
+// newCID. It asks the sentry to validate that newCID is unused and update
+// its container registry (keyed by CID) and, if the container has its own
+// cgroup, to move it to the new path; the caller is responsible for
+// rewriting any on-disk container state it keeps for oldCID, since Sandbox
+// itself persists no per-container state beyond the sentry.
+//
+// The root container's ID is the sandbox ID itself and can't be renamed
+// through this call; see Sandbox.ID.
+func (s *Sandbox) RenameSubcontainer(oldCID, newCID string) error {
+	log.Debugf("Renaming container %q to %q in sandbox %q", oldCID, newCID, s.ID)
+	if s.IsRootContainer(oldCID) {
+		return fmt.Errorf("cannot rename root container %q of sandbox %q", oldCID, s.ID)
+	}
+	args := boot.RenameArgs{
+		ContainerID: oldCID,
+		NewID:       newCID,
+	}
+	if err := s.call(boot.ContMgrRename, &args, nil); err != nil {
+		return fmt.Errorf("renaming container %q to %q: %w", oldCID, newCID, err)
+	}
+	return nil
+}
+
 // DestroyContainer destroys the given container. If it is the root container,
 // then the entire sandbox is destroyed.
 func (s *Sandbox) DestroyContainer(cid string) error {
@@ -1851,6 +2486,11 @@ func (s *Sandbox) CgroupsReadControlFile(file control.CgroupControlFile) (string
 // CgroupsWriteControlFile writes a single cgroupfs control file in the sandbox.
 func (s *Sandbox) CgroupsWriteControlFile(file control.CgroupControlFile, value string) error {
 	log.Debugf("CgroupsReadControlFiles sandbox %q", s.ID)
+	if s.cgroupDriver == CgroupDriverSystemd {
+		if prop, ok := systemdUnitProperty[file.Name]; ok {
+			return s.cgroupsWriteViaSystemd(file, prop, value)
+		}
+	}
 	args := control.CgroupsWriteArgs{
 		Args: []control.CgroupsWriteArg{
 			{
@@ -0,0 +1,163 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/urpc"
+	"gvisor.dev/gvisor/runsc/boot"
+)
+
+// DefaultDetachKeys is the escape sequence Attach uses to let a client leave
+// an attached container without killing it, when the caller doesn't
+// configure its own via --detach-keys. It matches Docker and containerd's
+// default so muscle memory carries over.
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// ErrDetach is returned by the reader Attach wraps stdin in, once stdin has
+// produced the configured detach-keys sequence; Attach itself then ends the
+// session with a nil error.
+var ErrDetach = fmt.Errorf("detached from container")
+
+// ParseDetachKeys parses a --detach-keys value: a comma-separated list of
+// either "ctrl-<letter>" or a single literal character. An empty string
+// disables the detach sequence entirely (Attach runs until stdin/stdout
+// close or ctx is canceled).
+func ParseDetachKeys(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var keys []byte
+	for _, key := range strings.Split(s, ",") {
+		switch {
+		case strings.HasPrefix(key, "ctrl-"):
+			letter := strings.TrimPrefix(key, "ctrl-")
+			if len(letter) != 1 {
+				return nil, fmt.Errorf("invalid detach key %q: ctrl- must be followed by exactly one letter", key)
+			}
+			c := letter[0] | 0x20 // fold to lowercase
+			if c < 'a' || c > 'z' {
+				return nil, fmt.Errorf("invalid detach key %q: ctrl- must be followed by a letter", key)
+			}
+			keys = append(keys, c-'a'+1)
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		default:
+			return nil, fmt.Errorf("invalid detach key %q: want \"ctrl-<letter>\" or a single character", key)
+		}
+	}
+	return keys, nil
+}
+
+// detachReader wraps stdin so that Attach can tell the configured
+// detach-keys sequence apart from application input. It passes every byte
+// through to the copy loop until the trailing bytes read so far exactly
+// match keys, at which point it truncates the match off the returned data
+// and reports ErrDetach on the following Read. Bytes of the sequence that
+// an earlier Read already returned have been passed through and stay so.
+type detachReader struct {
+	r        io.Reader
+	keys     []byte
+	matched  int
+	detached bool
+}
+
+func newDetachReader(r io.Reader, keys []byte) io.Reader {
+	if len(keys) == 0 {
+		return r
+	}
+	return &detachReader{r: r, keys: keys}
+}
+
+func (d *detachReader) Read(p []byte) (int, error) {
+	if d.detached {
+		return 0, ErrDetach
+	}
+	n, err := d.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == d.keys[d.matched] {
+			d.matched++
+			if d.matched == len(d.keys) {
+				d.detached = true
+				if i+1 < len(d.keys) {
+					// The match began in an earlier Read.
+					return 0, nil
+				}
+				return i - len(d.keys) + 1, nil
+			}
+			continue
+		}
+		// The byte that broke the match might itself start a new one.
+		if p[i] == d.keys[0] {
+			d.matched = 1
+		} else {
+			d.matched = 0
+		}
+	}
+	return n, err
+}
+
+// ResizeTTY reports a new terminal window size for cid's controlling PTY. It
+// can be called independently of Attach, e.g. from a SIGWINCH handler that
+// outlives any single attach session.
+func (s *Sandbox) ResizeTTY(cid string, rows, cols uint16) error {
+	log.Debugf("Resizing TTY for container %q in sandbox %q to %dx%d", cid, s.ID, cols, rows)
+	if err := s.call(boot.ContMgrResizeTTY, &boot.ResizeTTYArgs{ContainerID: cid, Rows: rows, Cols: cols}, nil); err != nil {
+		return fmt.Errorf("resizing TTY for container %q: %w", cid, err)
+	}
+	return nil
+}
+
+// AttachTTY joins cid's stdio hub the same way Attach does, but instead of
+// driving an io.Copy relay itself, it hands back the local end of the
+// socketpair directly as a single full-duplex FD. That's the shape a
+// supervising shim wants when it's going to epoll the FD itself alongside
+// other containers' (mirroring containerd/console's epoller model) rather
+// than dedicating a goroutine pair to every attached container.
+func (s *Sandbox) AttachTTY(cid string) (*os.File, error) {
+	log.Debugf("Attaching TTY to container %q in sandbox %q", cid, s.ID)
+
+	local, sandboxSide, err := newSocketPair()
+	if err != nil {
+		return nil, fmt.Errorf("creating attach socketpair: %w", err)
+	}
+	defer sandboxSide.Close()
+
+	args := &boot.AttachArgs{ContainerID: cid}
+	args.FilePayload = urpc.FilePayload{Files: []*os.File{sandboxSide}}
+
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		local.Close()
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.Call(boot.ContMgrAttach, args, nil); err != nil {
+		local.Close()
+		return nil, fmt.Errorf("attaching to container %q: %w", cid, err)
+	}
+
+	f, err := local.File()
+	local.Close()
+	if err != nil {
+		return nil, fmt.Errorf("extracting attach socket FD for container %q: %w", cid, err)
+	}
+	return f, nil
+}
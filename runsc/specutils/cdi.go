@@ -0,0 +1,240 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/pkg/sentry/devices/nvproxy/nvconf"
+	"gvisor.dev/gvisor/runsc/config"
+)
+
+// cdiAnnotationPrefix is the Kubernetes CDI device annotation key prefix, as
+// defined by https://github.com/cncf-tags/container-device-interface. The
+// annotation value is a comma-separated list of CDI device references.
+const cdiAnnotationPrefix = "cdi.k8s.io/"
+
+// cdiNvidiaGPUKind is the CDI "vendor/class" device kind that runsc
+// understands; references of any other kind are passed through untouched
+// since they don't concern nvproxy.
+const cdiNvidiaGPUKind = "nvidia.com/gpu"
+
+// cdiSearchPaths are the standard spec directories CDI-aware runtimes (and
+// the CDI device injection libraries they link) search, in priority order:
+// entries in the earlier directory win on a name collision.
+var cdiSearchPaths = []string{"/etc/cdi", "/var/run/cdi"}
+
+// CDIDeviceRefsFromSpec returns the CDI device references of kind
+// cdiNvidiaGPUKind requested via cdi.k8s.io/* annotations or, per OCI
+// runtime's convention for injected devices, via a "cdi.k8s.io/gpu"-style
+// annotation naming a device already present in spec.Linux.Devices. The
+// result is sorted and de-duplicated.
+func CDIDeviceRefsFromSpec(spec *specs.Spec) []string {
+	seen := make(map[string]struct{})
+	for key, value := range spec.Annotations {
+		if !strings.HasPrefix(key, cdiAnnotationPrefix) {
+			continue
+		}
+		for _, ref := range strings.Split(value, ",") {
+			ref = strings.TrimSpace(ref)
+			if kind, _, ok := ParseCDIDeviceRef(ref); ok && kind == cdiNvidiaGPUKind {
+				seen[ref] = struct{}{}
+			}
+		}
+	}
+	refs := make([]string, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// ParseCDIDeviceRef splits a CDI device reference of the form
+// "<vendor>/<class>=<name>" (e.g. "nvidia.com/gpu=0" or
+// "nvidia.com/gpu=GPU-fef8089b") into its kind ("<vendor>/<class>") and name.
+func ParseCDIDeviceRef(ref string) (kind, name string, ok bool) {
+	i := strings.LastIndexByte(ref, '=')
+	if i <= 0 || i == len(ref)-1 {
+		return "", "", false
+	}
+	kind, name = ref[:i], ref[i+1:]
+	if strings.Count(kind, "/") != 1 {
+		return "", "", false
+	}
+	return kind, name, true
+}
+
+// GPUFunctionalityRequestedViaCDI returns true if the container requested
+// GPU functionality via a CDI device reference rather than the legacy
+// nvidia-container-runtime-hook or NVIDIA_VISIBLE_DEVICES.
+func GPUFunctionalityRequestedViaCDI(spec *specs.Spec, conf *config.Config) bool {
+	if !NVProxyEnabled(spec, conf) {
+		return false
+	}
+	return len(CDIDeviceRefsFromSpec(spec)) > 0
+}
+
+// cdiSpec is the subset of the CDI specification's JSON schema runsc needs:
+// https://github.com/cncf-tags/container-device-interface/blob/main/SPEC.md
+// CDI specs may also be written as YAML; runsc only consumes the JSON form,
+// since nothing else in this repo depends on a YAML decoder.
+type cdiSpec struct {
+	CDIVersion string      `json:"cdiVersion"`
+	Kind       string      `json:"kind"`
+	Devices    []cdiDevice `json:"devices"`
+}
+
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	DeviceNodes []specs.LinuxDevice `json:"deviceNodes"`
+	Mounts      []specs.Mount       `json:"mounts"`
+	Env         []string            `json:"env"`
+	Hooks       []specs.Hook        `json:"hooks"`
+}
+
+// CDIResolution is the union of the containerEdits contributed by every CDI
+// device reference resolved by ResolveCDIDevices, in reference order.
+type CDIResolution struct {
+	DeviceNodes []specs.LinuxDevice
+	Mounts      []specs.Mount
+	Env         []string
+	Hooks       []specs.Hook
+}
+
+// ResolveCDIDevices loads the CDI spec files backing each of refs (as
+// returned by CDIDeviceRefsFromSpec) from cdiSearchPaths and merges their
+// containerEdits into a single CDIResolution.
+func ResolveCDIDevices(refs []string) (*CDIResolution, error) {
+	res := &CDIResolution{}
+	for _, ref := range refs {
+		kind, name, ok := ParseCDIDeviceRef(ref)
+		if !ok {
+			return nil, fmt.Errorf("invalid CDI device reference %q", ref)
+		}
+		dev, err := findCDIDevice(kind, name)
+		if err != nil {
+			return nil, fmt.Errorf("resolving CDI device %q: %w", ref, err)
+		}
+		res.DeviceNodes = append(res.DeviceNodes, dev.ContainerEdits.DeviceNodes...)
+		res.Mounts = append(res.Mounts, dev.ContainerEdits.Mounts...)
+		res.Env = append(res.Env, dev.ContainerEdits.Env...)
+		res.Hooks = append(res.Hooks, dev.ContainerEdits.Hooks...)
+	}
+	return res, nil
+}
+
+// findCDIDevice searches cdiSearchPaths, in order, for a *.json spec file of
+// the given kind that defines a device named name.
+func findCDIDevice(kind, name string) (cdiDevice, error) {
+	for _, dir := range cdiSearchPaths {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+				continue
+			}
+			spec, err := loadCDISpecFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return cdiDevice{}, err
+			}
+			if spec.Kind != kind {
+				continue
+			}
+			for _, dev := range spec.Devices {
+				if dev.Name == name {
+					return dev, nil
+				}
+			}
+		}
+	}
+	return cdiDevice{}, fmt.Errorf("no CDI spec in %v defines device %q of kind %q", cdiSearchPaths, name, kind)
+}
+
+func loadCDISpecFile(path string) (cdiSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cdiSpec{}, fmt.Errorf("reading %q: %w", path, err)
+	}
+	var spec cdiSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return cdiSpec{}, fmt.Errorf("parsing CDI spec %q: %w", path, err)
+	}
+	return spec, nil
+}
+
+// NVProxyConfig is the normalized result of resolving GPU access from
+// whichever of the three mechanisms the container used to request it
+// (nvidia-container-runtime-hook, NVIDIA_VISIBLE_DEVICES directly, or CDI
+// device references), so that downstream nvproxy setup only has one shape
+// to consume regardless of how the caller asked for GPU access.
+type NVProxyConfig struct {
+	// DriverCaps is the set of NVIDIA driver capabilities to expose.
+	DriverCaps nvconf.DriverCaps
+
+	// VisibleDevices is the "all" or comma-separated whole-GPU device list
+	// to pass to nvidia-container-cli, as returned by
+	// ParseNvidiaVisibleDevices. Empty when CDI or MIGDevices is used
+	// instead.
+	VisibleDevices string
+
+	// MIGDevices is the set of MIG compute instances requested, when
+	// NVIDIA_VISIBLE_DEVICES named MIG devices instead of whole GPUs.
+	MIGDevices []MIGDevice
+
+	// CDI is the resolved device nodes, mounts, env vars and hooks from CDI
+	// device references, set only when GPU access was requested via CDI.
+	CDI *CDIResolution
+}
+
+// ResolveNVProxyConfig unifies hook-mode, env-var-mode and CDI-mode GPU
+// access requests into a single NVProxyConfig. Precondition:
+// GPUFunctionalityRequested(spec, conf) || GPUFunctionalityRequestedViaCDI(spec, conf).
+func ResolveNVProxyConfig(spec *specs.Spec, conf *config.Config) (*NVProxyConfig, error) {
+	driverCaps, err := NVProxyDriverCapsFromEnv(spec, conf)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &NVProxyConfig{DriverCaps: driverCaps}
+
+	if refs := CDIDeviceRefsFromSpec(spec); len(refs) > 0 {
+		cdi, err := ResolveCDIDevices(refs)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CDI = cdi
+		return cfg, nil
+	}
+
+	visibleDevices, migDevices, err := ParseNvidiaVisibleDevices(spec)
+	if err != nil {
+		return nil, err
+	}
+	cfg.VisibleDevices = visibleDevices
+	cfg.MIGDevices = migDevices
+	return cfg, nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DriverFallbackMode selects how SelectDriverVersion behaves when the
+// host's installed NVIDIA driver doesn't exactly match one of the ABI
+// shims nvproxy has compiled in. It's the value of the
+// --nvproxy-driver-fallback flag.
+type DriverFallbackMode string
+
+// The supported --nvproxy-driver-fallback values.
+const (
+	// DriverFallbackStrict requires an exact driver version match,
+	// matching nvproxy's historical behavior.
+	DriverFallbackStrict DriverFallbackMode = "strict"
+
+	// DriverFallbackNearestMinor allows falling back to the closest
+	// supported shim with the same major version.
+	DriverFallbackNearestMinor DriverFallbackMode = "nearest-minor"
+
+	// DriverFallbackNearestMajor allows falling back to the closest
+	// supported shim of any major version, when no same-major shim is
+	// available.
+	DriverFallbackNearestMajor DriverFallbackMode = "nearest-major"
+)
+
+// ParseDriverFallbackMode validates the --nvproxy-driver-fallback flag
+// value.
+func ParseDriverFallbackMode(s string) (DriverFallbackMode, error) {
+	switch DriverFallbackMode(s) {
+	case "", DriverFallbackStrict:
+		return DriverFallbackStrict, nil
+	case DriverFallbackNearestMinor, DriverFallbackNearestMajor:
+		return DriverFallbackMode(s), nil
+	default:
+		return "", fmt.Errorf("invalid --nvproxy-driver-fallback value %q: want one of strict, nearest-minor, nearest-major", s)
+	}
+}
+
+// DriverVersion is an NVIDIA driver version of the form Major.Minor.Patch,
+// e.g. "535.104.05".
+type DriverVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseDriverVersion parses a driver version string as reported by
+// /proc/driver/nvidia/version or nvidia-smi.
+func ParseDriverVersion(s string) (DriverVersion, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return DriverVersion{}, fmt.Errorf("invalid driver version %q: want Major.Minor.Patch", s)
+	}
+	ints := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return DriverVersion{}, fmt.Errorf("invalid driver version %q: %w", s, err)
+		}
+		ints[i] = n
+	}
+	return DriverVersion{Major: ints[0], Minor: ints[1], Patch: ints[2]}, nil
+}
+
+// String returns v in Major.Minor.Patch form.
+func (v DriverVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// cmp returns -1, 0 or 1 as v is less than, equal to, or greater than o,
+// comparing Major then Minor then Patch.
+func (v DriverVersion) cmp(o DriverVersion) int {
+	for _, d := range [][2]int{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if d[0] != d[1] {
+			if d[0] < d[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// DriverFallbackResult records the outcome of SelectDriverVersion, suitable
+// for logging a structured warning when Exact is false so operators can
+// audit which containers ran under a non-exact ABI shim.
+type DriverFallbackResult struct {
+	// Installed is the host's installed driver version.
+	Installed DriverVersion
+	// Selected is the ABI shim version nvproxy should use.
+	Selected DriverVersion
+	// Exact is true if Selected == Installed.
+	Exact bool
+	// Mode is the fallback mode that produced this result.
+	Mode DriverFallbackMode
+	// Reason explains why Selected was chosen over Installed, empty when
+	// Exact is true.
+	Reason string
+}
+
+// SelectDriverVersion picks the ABI shim from supported (nvproxy's
+// compiled-in driver versions, which need not be sorted) that
+// DriverFallbackMode mode allows for the host's installed driver version,
+// following the same min/max-supported-range-with-fallback approach as
+// COS's GPU installer: an exact match always wins; otherwise the nearest
+// version (by cmp distance) within the modes' allowed major-version scope
+// is selected. It returns an error when supported is empty, under
+// DriverFallbackStrict when no exact match exists, and under
+// DriverFallbackNearestMinor when no supported shim shares installed's
+// major version.
+func SelectDriverVersion(installed DriverVersion, supported []DriverVersion, mode DriverFallbackMode) (DriverFallbackResult, error) {
+	if len(supported) == 0 {
+		return DriverFallbackResult{}, fmt.Errorf("no supported driver versions compiled in")
+	}
+	for _, s := range supported {
+		if s == installed {
+			return DriverFallbackResult{Installed: installed, Selected: s, Exact: true, Mode: mode}, nil
+		}
+	}
+	if mode == DriverFallbackStrict || mode == "" {
+		return DriverFallbackResult{}, fmt.Errorf("installed NVIDIA driver %v has no matching nvproxy ABI shim (supported: %v); rerun with --nvproxy-driver-fallback to allow an approximate match", installed, supported)
+	}
+
+	var best *DriverVersion
+	var bestDist DriverVersion
+	for i, s := range supported {
+		if mode == DriverFallbackNearestMinor && s.Major != installed.Major {
+			continue
+		}
+		dist := versionDistance(installed, s)
+		if best == nil || dist.cmp(bestDist) < 0 {
+			sCopy := supported[i]
+			best = &sCopy
+			bestDist = dist
+		}
+	}
+	if best == nil {
+		return DriverFallbackResult{}, fmt.Errorf("installed NVIDIA driver %v has no nvproxy ABI shim within a matching major version (supported: %v); retry with --nvproxy-driver-fallback=nearest-major", installed, supported)
+	}
+	return DriverFallbackResult{
+		Installed: installed,
+		Selected:  *best,
+		Exact:     false,
+		Mode:      mode,
+		Reason:    fmt.Sprintf("no exact nvproxy ABI shim for driver %v; using closest supported shim %v under --nvproxy-driver-fallback=%s", installed, *best, mode),
+	}, nil
+}
+
+// versionDistance returns the absolute component-wise difference between a
+// and b, used only to compare candidates against each other in
+// SelectDriverVersion: the component order (Major, Minor, Patch) makes a
+// larger Major difference always outweigh any Minor/Patch difference, and
+// likewise Minor outweighs Patch.
+func versionDistance(a, b DriverVersion) DriverVersion {
+	return DriverVersion{Major: abs(a.Major - b.Major), Minor: abs(a.Minor - b.Minor), Patch: abs(a.Patch - b.Patch)}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -16,6 +16,7 @@ package specutils
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -113,34 +114,115 @@ func isNvidiaHookPresent(spec *specs.Spec, conf *config.Config) bool {
 	return false
 }
 
+// MIGDevice identifies a single MIG (Multi-Instance GPU) compute instance
+// requested via NVIDIA_VISIBLE_DEVICES, as opposed to a whole physical GPU.
+// Exactly one of UUID, ParentUUID or (GPUIndex, HasGPUIndex) is set,
+// depending on which of the three MIG formats ParseNvidiaVisibleDevices
+// matched; see its doc comment.
+type MIGDevice struct {
+	// UUID is set for the "MIG-<uuid>" form: the MIG device's own UUID, as
+	// reported by "nvidia-smi -L".
+	UUID string
+
+	// ParentUUID is set for the "MIG-GPU-<parent-uuid>/<gi>/<ci>" form: the
+	// UUID of the physical GPU the MIG slice was carved out of.
+	ParentUUID string
+
+	// GPUIndex is set, with HasGPUIndex true, for the "<gpu>:<gi>"
+	// shorthand: the physical GPU's numeric index.
+	GPUIndex    int32
+	HasGPUIndex bool
+
+	// GPUInstance and ComputeInstance are the MIG GPU instance (GI) and
+	// compute instance (CI) IDs, as carved out by "nvidia-smi mig -cgi/
+	// -cci". ComputeInstance is -1 for the "<gpu>:<gi>" shorthand, which
+	// only selects a GPU instance and leaves the compute instance
+	// unspecified.
+	GPUInstance     int32
+	ComputeInstance int32
+}
+
+var (
+	migGPUUUIDRe   = regexp.MustCompile(`^MIG-GPU-([0-9a-fA-F-]+)/(\d+)/(\d+)$`)
+	migUUIDRe      = regexp.MustCompile(`^MIG-([0-9a-fA-F-]+)$`)
+	migShorthandRe = regexp.MustCompile(`^(\d+):(\d+)$`)
+)
+
+// parseMIGDevice parses gpuDev as one of the three MIG device identifier
+// formats documented by the NVIDIA container toolkit, returning ok == false
+// if gpuDev doesn't match any of them (i.e. it's a whole-GPU identifier
+// instead).
+func parseMIGDevice(gpuDev string) (dev MIGDevice, ok bool, err error) {
+	if m := migGPUUUIDRe.FindStringSubmatch(gpuDev); m != nil {
+		gi, giErr := strconv.ParseInt(m[2], 10, 32)
+		ci, ciErr := strconv.ParseInt(m[3], 10, 32)
+		if giErr != nil || ciErr != nil {
+			return MIGDevice{}, false, fmt.Errorf("invalid MIG device %q: gi/ci out of range", gpuDev)
+		}
+		return MIGDevice{ParentUUID: "GPU-" + m[1], GPUInstance: int32(gi), ComputeInstance: int32(ci)}, true, nil
+	}
+	if m := migUUIDRe.FindStringSubmatch(gpuDev); m != nil {
+		return MIGDevice{UUID: gpuDev, GPUInstance: -1, ComputeInstance: -1}, true, nil
+	}
+	if m := migShorthandRe.FindStringSubmatch(gpuDev); m != nil {
+		gpu, gpuErr := strconv.ParseInt(m[1], 10, 32)
+		gi, giErr := strconv.ParseInt(m[2], 10, 32)
+		if gpuErr != nil || giErr != nil {
+			return MIGDevice{}, false, fmt.Errorf("invalid MIG device %q: gpu/gi out of range", gpuDev)
+		}
+		return MIGDevice{GPUIndex: int32(gpu), HasGPUIndex: true, GPUInstance: int32(gi), ComputeInstance: -1}, true, nil
+	}
+	return MIGDevice{}, false, nil
+}
+
 // ParseNvidiaVisibleDevices parses NVIDIA_VISIBLE_DEVICES env var and returns
-// the devices specified in it. This can be passed to nvidia-container-cli.
+// the whole-GPU devices specified in it, suitable to pass to
+// nvidia-container-cli, along with any MIG compute instances it requested
+// separately. A request is rejected if it mixes whole-GPU and MIG
+// identifiers: nvproxy exposes either a full GPU or a MIG slice of one to a
+// given container, never both at once.
 //
 // Precondition: conf.NVProxyDocker && GPUFunctionalityRequested(spec, conf).
-func ParseNvidiaVisibleDevices(spec *specs.Spec) (string, error) {
+func ParseNvidiaVisibleDevices(spec *specs.Spec) (string, []MIGDevice, error) {
 	nvd, _ := EnvVar(spec.Process.Env, nvidiaVisibleDevsEnv)
 	if nvd == "none" {
-		return "", nil
+		return "", nil, nil
 	}
 	if nvd == "all" {
-		return "all", nil
+		return "all", nil, nil
 	}
 
+	var wholeGPUs []string
+	var migDevices []MIGDevice
 	for _, gpuDev := range strings.Split(nvd, ",") {
-		// Validate gpuDev. We only support the following formats for now:
+		if mig, ok, err := parseMIGDevice(gpuDev); err != nil {
+			return "", nil, fmt.Errorf("invalid %q in NVIDIA_VISIBLE_DEVICES %q: %w", gpuDev, nvd, err)
+		} else if ok {
+			migDevices = append(migDevices, mig)
+			continue
+		}
+
+		// Not a MIG identifier; validate it as a whole-GPU one. We only
+		// support the following formats for now:
 		// * GPU indices (e.g. 0,1,2)
 		// * GPU UUIDs (e.g. GPU-fef8089b)
-		//
-		// We do not support MIG devices yet.
 		if strings.HasPrefix(gpuDev, "GPU-") {
+			wholeGPUs = append(wholeGPUs, gpuDev)
 			continue
 		}
-		_, err := strconv.ParseUint(gpuDev, 10, 32)
-		if err != nil {
-			return "", fmt.Errorf("invalid %q in NVIDIA_VISIBLE_DEVICES %q: %w", gpuDev, nvd, err)
+		if _, err := strconv.ParseUint(gpuDev, 10, 32); err != nil {
+			return "", nil, fmt.Errorf("invalid %q in NVIDIA_VISIBLE_DEVICES %q: %w", gpuDev, nvd, err)
 		}
+		wholeGPUs = append(wholeGPUs, gpuDev)
+	}
+
+	if len(migDevices) > 0 && len(wholeGPUs) > 0 {
+		return "", nil, fmt.Errorf("NVIDIA_VISIBLE_DEVICES %q mixes whole-GPU and MIG device identifiers, which isn't supported", nvd)
+	}
+	if len(migDevices) > 0 {
+		return "", migDevices, nil
 	}
-	return nvd, nil
+	return nvd, nil, nil
 }
 
 // NVProxyDriverCapsFromEnv returns the driver capabilities requested by the
@@ -47,6 +47,15 @@ const defaultWait = time.Minute
 
 const testAlpineImage = "gcr.io/gvisor-presubmit/basic/alpine_x86_64:1ce68c8160724eb9"
 
+// matrixWorkers bounds how many dockerd-in-gvisor instances testDockerMatrix
+// runs concurrently. The default of 0 means "unbounded": every matrix cell
+// gets its own instance and they all run in parallel, which is simplest and
+// gives cells the strongest isolation from each other, but can overwhelm a
+// small CI machine once the matrix grows. Set -image.matrix.workers=N to
+// cap concurrency instead, in which case cells share a pool of N instances
+// and are pruned between uses.
+var matrixWorkers = flag.Int("image.matrix.workers", 0, "maximum number of concurrent dockerd-in-gvisor instances testDockerMatrix may run; 0 means unbounded")
+
 func TestHelloWorld(t *testing.T) {
 	ctx := context.Background()
 	d := dockerutil.MakeContainer(ctx, t)
@@ -409,9 +418,56 @@ func dockerInGvisorCapabilities() []string {
 type dockerCommandOptions struct {
 	hostNetwork bool
 	privileged  bool
+	// namePrefix uniquely identifies the matrix cell this dockerCommandOptions
+	// was built for, so testFuncs that need to name a docker object (an image
+	// tag, say) can derive a name that can't collide with a concurrently
+	// running cell.
+	namePrefix string
+}
+
+// dockerdPool hands out a bounded number of dockerd-in-gvisor instances to
+// concurrently running matrix cells, reusing each instance across cells
+// instead of paying for a fresh sandbox per cell. Used when -image.matrix.workers
+// is set; see testDockerMatrix.
+type dockerdPool struct {
+	instances []*dockerutil.Container
+	free      chan *dockerutil.Container
+}
+
+// newDockerdPool starts size dockerd-in-gvisor instances up front.
+func newDockerdPool(ctx context.Context, t *testing.T, overlay bool, size int) *dockerdPool {
+	p := &dockerdPool{free: make(chan *dockerutil.Container, size)}
+	for i := 0; i < size; i++ {
+		d := startDockerdInGvisor(ctx, t, overlay)
+		p.instances = append(p.instances, d)
+		p.free <- d
+	}
+	return p
+}
+
+// acquire blocks until an instance is free, then removes it from the pool.
+func (p *dockerdPool) acquire() *dockerutil.Container {
+	return <-p.free
+}
+
+// release prunes everything the caller's cell left behind in d and returns
+// it to the pool, so the next cell to acquire it starts from a clean slate.
+func (p *dockerdPool) release(ctx context.Context, t *testing.T, d *dockerutil.Container) {
+	if output, err := d.Exec(ctx, dockerutil.ExecOpts{}, "docker", "system", "prune", "-af"); err != nil {
+		t.Logf("docker system prune failed on pooled dockerd instance: %v\n%s", err, output)
+	}
+	p.free <- d
+}
+
+// cleanup tears down every instance in the pool. Callers must not acquire
+// from the pool after calling cleanup.
+func (p *dockerdPool) cleanup(ctx context.Context) {
+	for _, d := range p.instances {
+		d.CleanUp(ctx)
+	}
 }
 
-func testDockerMatrix(ctx context.Context, t *testing.T, d *dockerutil.Container) {
+func testDockerMatrix(ctx context.Context, t *testing.T, overlay bool) {
 	definitions := []struct {
 		name            string
 		testFunc        func(ctx context.Context, t *testing.T, d *dockerutil.Container, opts dockerCommandOptions)
@@ -422,6 +478,13 @@ func testDockerMatrix(ctx context.Context, t *testing.T, d *dockerutil.Container
 		{"docker_build", testDockerBuild, true, false},
 		{"docker_exec", testDockerExec, false, true},
 	}
+
+	var pool *dockerdPool
+	if *matrixWorkers > 0 {
+		pool = newDockerdPool(ctx, t, overlay, *matrixWorkers)
+		t.Cleanup(func() { pool.cleanup(ctx) })
+	}
+
 	for _, def := range definitions {
 		hostNetworkOpts := []bool{false}
 		if def.testHostNetwork {
@@ -433,10 +496,6 @@ func testDockerMatrix(ctx context.Context, t *testing.T, d *dockerutil.Container
 		}
 		for _, hostNetwork := range hostNetworkOpts {
 			for _, privileged := range privilegedOpts {
-				opts := dockerCommandOptions{
-					hostNetwork: hostNetwork,
-					privileged:  privileged,
-				}
 				var nameParts []string
 				nameParts = append(nameParts, def.name)
 				if def.testHostNetwork {
@@ -454,7 +513,22 @@ func testDockerMatrix(ctx context.Context, t *testing.T, d *dockerutil.Container
 					}
 				}
 				name := strings.Join(nameParts, "_")
+				def, hostNetwork, privileged := def, hostNetwork, privileged
 				t.Run(name, func(t *testing.T) {
+					t.Parallel()
+					opts := dockerCommandOptions{
+						hostNetwork: hostNetwork,
+						privileged:  privileged,
+						namePrefix:  strings.ReplaceAll(testutil.RandomID(name), "/", "-"),
+					}
+					var d *dockerutil.Container
+					if pool != nil {
+						d = pool.acquire()
+						defer pool.release(ctx, t, d)
+					} else {
+						d = startDockerdInGvisor(ctx, t, overlay)
+						defer d.CleanUp(ctx)
+					}
 					def.testFunc(ctx, t, d, opts)
 				})
 			}
@@ -466,20 +540,87 @@ func TestDockerWithVFS(t *testing.T) {
 	if testutil.IsRunningWithHostNet() {
 		t.Skip("docker doesn't work with hostinet")
 	}
-	ctx := context.Background()
-	d := startDockerdInGvisor(ctx, t, false)
-	defer d.CleanUp(ctx)
-	testDockerMatrix(ctx, t, d)
+	testDockerMatrix(context.Background(), t, false)
 }
 
 func TestDockerWithOverlay(t *testing.T) {
+	if testutil.IsRunningWithHostNet() {
+		t.Skip("docker doesn't work with hostinet")
+	}
+	testDockerMatrix(context.Background(), t, true)
+}
+
+// TestDaemonRestart checks that a running container configured with
+// --restart=always survives a restart of the outer runsc sandbox running
+// dockerd, with its published port still reachable, while a container
+// without that restart policy is not brought back up. This is analogous to
+// Docker's own TestDaemonRestartWithRunningContainersPorts, adapted to
+// restart the gVisor sandbox hosting dockerd.
+func TestDaemonRestart(t *testing.T) {
 	if testutil.IsRunningWithHostNet() {
 		t.Skip("docker doesn't work with hostinet")
 	}
 	ctx := context.Background()
-	d := startDockerdInGvisor(ctx, t, true)
+	d := startDockerdInGvisor(ctx, t, false)
 	defer d.CleanUp(ctx)
-	testDockerMatrix(ctx, t, d)
+
+	const alwaysName = "test_daemon_restart_always"
+	const defaultName = "test_daemon_restart_default"
+
+	run := func(name, restartPolicy string, extraArgs ...string) {
+		cmd := []string{"docker", "run", "-d", "--name", name, "--restart", restartPolicy}
+		cmd = append(cmd, extraArgs...)
+		if _, err := d.Exec(ctx, dockerutil.ExecOpts{}, cmd...); err != nil {
+			t.Fatalf("docker run %s failed: %v", name, err)
+		}
+	}
+	run(alwaysName, "always", "-p", "1234:80", testAlpineImage, "busybox", "httpd", "-f", "-p", "80", "-h", "/")
+	run(defaultName, "no", testAlpineImage, "sleep", "3600")
+
+	containerStatus := func(name string) string {
+		output, err := d.Exec(ctx, dockerutil.ExecOpts{}, "docker", "inspect", "-f", "{{.State.Status}}", name)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(output)
+	}
+	waitRunning := func(name string) {
+		t.Helper()
+		for i := 0; i < 10; i++ {
+			if containerStatus(name) == "running" {
+				return
+			}
+			time.Sleep(time.Second)
+		}
+		t.Fatalf("container %q never reached the running state", name)
+	}
+	waitRunning(alwaysName)
+	waitRunning(defaultName)
+
+	// Restart the outer sandbox itself (docker restart stops it and starts
+	// it again), rather than just dockerd inside it, so this exercises a
+	// full sandbox restart and not just dockerd's own restart handling.
+	if err := d.Restart(ctx); err != nil {
+		t.Fatalf("restarting dockerd-in-gvisor sandbox failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := d.Exec(ctx, dockerutil.ExecOpts{}, "docker", "info"); err == nil {
+			break
+		}
+		t.Logf("waiting for dockerd to come back up after sandbox restart")
+		time.Sleep(5 * time.Second)
+	}
+
+	if got := containerStatus(alwaysName); got != "running" {
+		t.Errorf("container %q status after sandbox restart = %q, want %q", alwaysName, got, "running")
+	}
+	if got := containerStatus(defaultName); got == "running" {
+		t.Errorf("container %q survived the sandbox restart, want it stopped", defaultName)
+	}
+
+	if output, err := d.Exec(ctx, dockerutil.ExecOpts{}, "wget", "-qO-", "-T", "5", "http://localhost:1234/"); err != nil {
+		t.Errorf("published port 1234 unreachable after sandbox restart: %v\n%s", err, output)
+	}
 }
 
 // The container returned by this function has to be cleaned up by the caller.
@@ -554,7 +695,7 @@ func testDockerBuild(ctx context.Context, t *testing.T, d *dockerutil.Container,
 	if opts.hostNetwork {
 		cmd = append(cmd, "--network", "host")
 	}
-	imageName := "test_docker_build_in_gvisor"
+	imageName := "test_docker_build_in_gvisor_" + opts.namePrefix
 	cmd = append(cmd, "-t", imageName, "-f", "-", ".")
 	_, err := d.ExecProcess(ctx, dockerutil.ExecOpts{}, cmd...)
 	if err != nil {
@@ -630,5 +771,8 @@ func testDockerExec(ctx context.Context, t *testing.T, d *dockerutil.Container,
 func TestMain(m *testing.M) {
 	dockerutil.EnsureSupportedDockerVersion()
 	flag.Parse()
+	if err := dockerutil.ReapOrphans(context.Background(), dockerutil.RunLabel()); err != nil {
+		log.Printf("reaping orphaned containers/networks from a previous run: %v", err)
+	}
 	os.Exit(m.Run())
 }